@@ -0,0 +1,414 @@
+// Package client implements a reporter for the SuiteServe v1 API. It wraps each
+// endpoint in a typed method, retries failed requests with exponential backoff,
+// and batches log lines, which can be written through an io.Writer.
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	defaultRetries = 4
+	defaultBackoff = 250 * time.Millisecond
+	maxBackoff     = 10 * time.Second
+)
+
+type Options struct {
+	// BaseUrl is the URL of the SuiteServe instance, for example
+	// https://localhost:8080.
+	BaseUrl string
+	// CaFile is the path to a PEM file of certificates to trust in addition to
+	// the system pool, such as tls/ca.pem.
+	CaFile string
+	// Token is an API token to authenticate with, such as an ingest token for
+	// the project of the reported suites.
+	Token string
+	// Retries is the number of times to retry a failed request. Zero means the
+	// default; a negative value disables retries.
+	Retries int
+	// Backoff is the delay before the first retry, doubling with each retry.
+	// Zero means the default.
+	Backoff time.Duration
+	// HttpClient, if set, is used for requests instead of a client built from
+	// CaFile.
+	HttpClient *http.Client
+}
+
+type Client struct {
+	base    *url.URL
+	http    *http.Client
+	token   string
+	retries int
+	backoff time.Duration
+}
+
+// Error is returned for responses with a status code other than 2xx.
+type Error struct {
+	StatusCode int
+	Body       string
+	// Code, Detail, Field and RequestId are from the problem details of the
+	// response, if it has any.
+	Code      string
+	Detail    string
+	Field     string
+	RequestId string
+}
+
+func newError(res *http.Response, body []byte) *Error {
+	e := Error{StatusCode: res.StatusCode, Body: string(body)}
+	if res.Header.Get("content-type") == "application/problem+json" {
+		var p struct {
+			Code      string `json:"code"`
+			Detail    string `json:"detail"`
+			Field     string `json:"field"`
+			RequestId string `json:"requestId"`
+		}
+		if json.Unmarshal(body, &p) == nil {
+			e.Code = p.Code
+			e.Detail = p.Detail
+			e.Field = p.Field
+			e.RequestId = p.RequestId
+		}
+	}
+	return &e
+}
+
+func (e *Error) Error() string {
+	msg := e.Detail
+	if msg == "" {
+		msg = strings.TrimSpace(e.Body)
+	}
+	if msg == "" {
+		msg = http.StatusText(e.StatusCode)
+	}
+	if e.RequestId != "" {
+		return fmt.Sprintf("%d: %s (request %s)", e.StatusCode, msg,
+			e.RequestId)
+	}
+	return fmt.Sprintf("%d: %s", e.StatusCode, msg)
+}
+
+type idempotencyKeyCtxKey struct{}
+
+// WithIdempotencyKey returns a context that makes an insert send key as its
+// Idempotency-Key header. Without it, each insert uses a random key, which is
+// kept across the retries of that insert.
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, idempotencyKeyCtxKey{}, key)
+}
+
+func idempotencyKey(ctx context.Context) string {
+	if key, ok := ctx.Value(idempotencyKeyCtxKey{}).(string); ok {
+		return key
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func New(opts Options) (*Client, error) {
+	base, err := url.Parse(strings.TrimSuffix(opts.BaseUrl, "/") + "/v1/")
+	if err != nil {
+		return nil, err
+	}
+	c := Client{
+		base:    base,
+		http:    opts.HttpClient,
+		token:   opts.Token,
+		retries: opts.Retries,
+		backoff: opts.Backoff,
+	}
+	if c.http == nil {
+		c.http, err = newHttpClient(opts.CaFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if c.retries == 0 {
+		c.retries = defaultRetries
+	} else if c.retries < 0 {
+		c.retries = 0
+	}
+	if c.backoff == 0 {
+		c.backoff = defaultBackoff
+	}
+	return &c, nil
+}
+
+func newHttpClient(caFile string) (*http.Client, error) {
+	if caFile == "" {
+		return &http.Client{}, nil
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	b, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf("no certificates in %s", caFile)
+	}
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.TLSClientConfig = &tls.Config{RootCAs: pool}
+	return &http.Client{Transport: tr}, nil
+}
+
+func (c *Client) InsertAttachment(ctx context.Context, a Attachment,
+	r io.Reader) (Id, error) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	meta, err := mw.CreateFormField("meta")
+	if err != nil {
+		return Id{}, err
+	}
+	if err := json.NewEncoder(meta).Encode(a); err != nil {
+		return Id{}, err
+	}
+	filename := ""
+	if a.Filename != nil {
+		filename = *a.Filename
+	}
+	file, err := mw.CreateFormFile("file", filename)
+	if err != nil {
+		return Id{}, err
+	}
+	if _, err := io.Copy(file, r); err != nil {
+		return Id{}, err
+	}
+	if err := mw.Close(); err != nil {
+		return Id{}, err
+	}
+	var id Id
+	err = c.do(ctx, http.MethodPost, "attachments", mw.FormDataContentType(),
+		body.Bytes(), &id)
+	return id, err
+}
+
+func (c *Client) Attachment(ctx context.Context, id Id) (Attachment, error) {
+	var a Attachment
+	return a, c.get(ctx, "attachments/"+id.String(), &a)
+}
+
+func (c *Client) SuiteAttachments(ctx context.Context,
+	suiteId Id) ([]Attachment, error) {
+	var as []Attachment
+	return as, c.get(ctx, "attachments?suite="+suiteId.String(), &as)
+}
+
+func (c *Client) CaseAttachments(ctx context.Context,
+	caseId Id) ([]Attachment, error) {
+	var as []Attachment
+	return as, c.get(ctx, "attachments?case="+caseId.String(), &as)
+}
+
+func (c *Client) InsertSuite(ctx context.Context, s Suite) (Id, error) {
+	var id Id
+	return id, c.send(ctx, http.MethodPost, "suites", s, &id)
+}
+
+func (c *Client) Suite(ctx context.Context, id Id) (Suite, error) {
+	var s Suite
+	return s, c.get(ctx, "suites/"+id.String(), &s)
+}
+
+func (c *Client) SuitePage(ctx context.Context) (SuitePage, error) {
+	var p SuitePage
+	return p, c.get(ctx, "suites", &p)
+}
+
+func (c *Client) FinishSuite(ctx context.Context, id Id, res SuiteResult,
+	at MsTime) error {
+	return c.send(ctx, http.MethodPatch, "suites/"+id.String()+"?finish=true",
+		struct {
+			Result SuiteResult `json:"result"`
+			At     MsTime      `json:"at"`
+		}{res, at}, nil)
+}
+
+func (c *Client) DisconnectSuite(ctx context.Context, id Id, at MsTime) error {
+	return c.send(ctx, http.MethodPatch,
+		"suites/"+id.String()+"?disconnect=true", struct {
+			At MsTime `json:"at"`
+		}{at}, nil)
+}
+
+func (c *Client) InsertCase(ctx context.Context, cs Case) (Id, error) {
+	var id Id
+	return id, c.send(ctx, http.MethodPost, "cases", cs, &id)
+}
+
+func (c *Client) Case(ctx context.Context, id Id) (Case, error) {
+	var cs Case
+	return cs, c.get(ctx, "cases/"+id.String(), &cs)
+}
+
+func (c *Client) SuiteCases(ctx context.Context, suiteId Id) ([]Case, error) {
+	var cs []Case
+	return cs, c.get(ctx, "suites/"+suiteId.String()+"/cases", &cs)
+}
+
+func (c *Client) FinishCase(ctx context.Context, id Id, res CaseResult,
+	at MsTime) error {
+	return c.send(ctx, http.MethodPatch, "cases/"+id.String()+"?finish=true",
+		struct {
+			Result CaseResult `json:"result"`
+			At     MsTime     `json:"at"`
+		}{res, at}, nil)
+}
+
+// RetryCase starts another attempt of a finished case. Log lines and
+// attachments of the case belong to its latest attempt unless they have one.
+func (c *Client) RetryCase(ctx context.Context, id Id, at MsTime) error {
+	return c.send(ctx, http.MethodPatch, "cases/"+id.String()+"?retry=true",
+		struct {
+			At MsTime `json:"at"`
+		}{at}, nil)
+}
+
+// Quarantines returns the tests of the project that are quarantined now.
+func (c *Client) Quarantines(ctx context.Context,
+	project string) ([]QuarantinedTest, error) {
+	var qs []QuarantinedTest
+	return qs, c.get(ctx, "quarantines?format=runner&project="+
+		url.QueryEscape(project), &qs)
+}
+
+func (c *Client) InsertLogLine(ctx context.Context, ll LogLine) (Id, error) {
+	var id Id
+	return id, c.send(ctx, http.MethodPost, "logs", ll, &id)
+}
+
+func (c *Client) InsertLogLines(ctx context.Context,
+	lls []LogLine) ([]Id, error) {
+	var ids []Id
+	return ids, c.send(ctx, http.MethodPost, "logs?batch=true", lls, &ids)
+}
+
+func (c *Client) LogLine(ctx context.Context, id Id) (LogLine, error) {
+	var ll LogLine
+	return ll, c.get(ctx, "logs/"+id.String(), &ll)
+}
+
+func (c *Client) SuiteLogLines(ctx context.Context,
+	suiteId Id) ([]LogLine, error) {
+	var lls []LogLine
+	return lls, c.get(ctx, "suites/"+suiteId.String()+"/logs", &lls)
+}
+
+func (c *Client) CaseLogLines(ctx context.Context,
+	caseId Id) ([]LogLine, error) {
+	var lls []LogLine
+	return lls, c.get(ctx, "cases/"+caseId.String()+"/logs", &lls)
+}
+
+func (c *Client) get(ctx context.Context, path string, dst interface{}) error {
+	return c.do(ctx, http.MethodGet, path, "", nil, dst)
+}
+
+func (c *Client) send(ctx context.Context, method, path string,
+	src, dst interface{}) error {
+	b, err := json.Marshal(src)
+	if err != nil {
+		return err
+	}
+	return c.do(ctx, method, path, "application/json", b, dst)
+}
+
+func (c *Client) do(ctx context.Context, method, path, contentType string,
+	body []byte, dst interface{}) error {
+	u, err := c.base.Parse(path)
+	if err != nil {
+		return err
+	}
+	var key string
+	if method == http.MethodPost {
+		key = idempotencyKey(ctx)
+	}
+	backoff := c.backoff
+	for i := 0; ; i++ {
+		err = c.doOnce(ctx, method, u.String(), contentType, key, body, dst)
+		if err == nil || i >= c.retries || !isRetryable(err) {
+			return err
+		}
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+func (c *Client) doOnce(ctx context.Context, method, u, contentType,
+	key string, body []byte, dst interface{}) error {
+	var rd io.Reader
+	if body != nil {
+		rd = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, rd)
+	if err != nil {
+		return err
+	}
+	if contentType != "" {
+		req.Header.Set("content-type", contentType)
+	}
+	if key != "" {
+		req.Header.Set("idempotency-key", key)
+	}
+	if c.token != "" {
+		req.Header.Set("authorization", "Bearer "+c.token)
+	}
+	res, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return newError(res, b)
+	}
+	if dst == nil || len(b) == 0 {
+		return nil
+	}
+	return json.Unmarshal(b, dst)
+}
+
+func isRetryable(err error) bool {
+	if errors.Is(err, context.Canceled) ||
+		errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var herr *Error
+	if errors.As(err, &herr) {
+		return herr.StatusCode == http.StatusTooManyRequests ||
+			herr.StatusCode >= 500
+	}
+	var uerr *url.Error
+	return errors.As(err, &uerr) || errors.Is(err, io.ErrUnexpectedEOF)
+}
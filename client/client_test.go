@@ -0,0 +1,159 @@
+package client_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/suiteserve/suiteserve/client"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newClient(t *testing.T, h http.Handler) *client.Client {
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	c, err := client.New(client.Options{
+		BaseUrl: srv.URL,
+		Backoff: time.Millisecond,
+	})
+	require.Nil(t, err)
+	return c
+}
+
+func TestClient_Retry(t *testing.T) {
+	id := client.Id(primitive.NewObjectID())
+	var calls int
+	var key string
+	c := newClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		assert.Equal(t, "/v1/suites", r.URL.Path)
+		if calls == 1 {
+			key = r.Header.Get("idempotency-key")
+			assert.NotEmpty(t, key)
+		} else {
+			assert.Equal(t, key, r.Header.Get("idempotency-key"))
+		}
+		if calls < 3 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(id)
+	}))
+	got, err := c.InsertSuite(context.Background(), client.Suite{})
+	require.Nil(t, err)
+	assert.Equal(t, id, got)
+	assert.Equal(t, 3, calls)
+}
+
+func TestClient_NoRetryOnBadRequest(t *testing.T) {
+	var calls int
+	c := newClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		http.Error(w, "bad", http.StatusBadRequest)
+	}))
+	_, err := c.InsertSuite(context.Background(), client.Suite{})
+	var herr *client.Error
+	require.True(t, errors.As(err, &herr))
+	assert.Equal(t, http.StatusBadRequest, herr.StatusCode)
+	assert.Equal(t, 1, calls)
+}
+
+func TestLogWriter(t *testing.T) {
+	caseId := client.Id(primitive.NewObjectID())
+	var mu sync.Mutex
+	var got []client.LogLine
+	c := newClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/logs", r.URL.Path)
+		assert.Equal(t, "true", r.URL.Query().Get("batch"))
+		var lls []client.LogLine
+		require.Nil(t, json.NewDecoder(r.Body).Decode(&lls))
+		mu.Lock()
+		got = append(got, lls...)
+		mu.Unlock()
+		_, _ = fmt.Fprint(w, "[]")
+	}))
+	b := c.NewLogBatcher(caseId, client.BatchOptions{Size: 2})
+	stdout, stderr := b.Writer(false), b.Writer(true)
+	_, err := fmt.Fprint(stdout, "one\r\ntw")
+	require.Nil(t, err)
+	_, err = fmt.Fprint(stderr, "oops\n")
+	require.Nil(t, err)
+	_, err = fmt.Fprint(stdout, "o\nthree")
+	require.Nil(t, err)
+	require.Nil(t, stdout.Close())
+	require.Nil(t, b.Close())
+
+	want := []struct {
+		line  string
+		isErr bool
+	}{
+		{"one", false},
+		{"oops", true},
+		{"two", false},
+		{"three", false},
+	}
+	require.Len(t, got, len(want))
+	for i, w := range want {
+		assert.Equal(t, caseId, *got[i].CaseId)
+		assert.Equal(t, int64(i), *got[i].Idx)
+		assert.Equal(t, w.line, *got[i].Line)
+		assert.Equal(t, w.isErr, got[i].Error != nil && *got[i].Error)
+	}
+}
+
+func TestLogBatcher_FlushKeepsFailedLines(t *testing.T) {
+	caseId := client.Id(primitive.NewObjectID())
+	var mu sync.Mutex
+	fail := true
+	var got []client.LogLine
+	c := newClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if fail {
+			http.Error(w, "bad", http.StatusBadRequest)
+			return
+		}
+		var lls []client.LogLine
+		require.Nil(t, json.NewDecoder(r.Body).Decode(&lls))
+		got = append(got, lls...)
+		_, _ = fmt.Fprint(w, "[]")
+	}))
+	b := c.NewLogBatcher(caseId, client.BatchOptions{
+		Size:          100,
+		FlushInterval: time.Hour,
+	})
+	require.Nil(t, b.Log("one", false))
+	require.NotNil(t, b.Flush(context.Background()))
+	assert.NotNil(t, b.Log("two", false))
+
+	mu.Lock()
+	fail = false
+	mu.Unlock()
+	require.Nil(t, b.Close())
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, got, 2)
+	assert.Equal(t, "one", *got[0].Line)
+	assert.Equal(t, "two", *got[1].Line)
+}
+
+func TestClient_Quarantines(t *testing.T) {
+	c := newClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/quarantines", r.URL.Path)
+		assert.Equal(t, "runner", r.URL.Query().Get("format"))
+		assert.Equal(t, "a b", r.URL.Query().Get("project"))
+		_, _ = fmt.Fprint(w, `[{"fingerprint":"f","name":"TestA","expiresAt":1}]`)
+	}))
+	qs, err := c.Quarantines(context.Background(), "a b")
+	require.Nil(t, err)
+	require.Len(t, qs, 1)
+	assert.Equal(t, "TestA", qs[0].Name)
+	assert.Equal(t, client.NewMsTime(1), qs[0].ExpiresAt)
+}
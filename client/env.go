@@ -0,0 +1,129 @@
+package client
+
+import (
+	"os"
+	"path"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// DetectEnv returns the environment of a suite: the build that the CI
+// provider, if any, describes in the variables that getenv returns, such as
+// os.Getenv, and the machine and Go version of the running program.
+func DetectEnv(getenv func(string) string) SuiteEnv {
+	var env SuiteEnv
+	for _, p := range ciProviders {
+		if getenv(p.detect) != "" {
+			env = p.env(getenv)
+			env.Ci = String(p.name)
+			break
+		}
+	}
+	if host, err := os.Hostname(); err == nil && host != "" {
+		env.Host = &host
+	}
+	env.Os = String(runtime.GOOS)
+	env.Arch = String(runtime.GOARCH)
+	env.GoVersion = String(runtime.Version())
+	return env
+}
+
+type ciProvider struct {
+	name string
+	// detect is the variable that is set in builds of the provider.
+	detect string
+	env    func(getenv func(string) string) SuiteEnv
+}
+
+var ciProviders = []ciProvider{
+	{"github-actions", "GITHUB_ACTIONS", func(getenv func(string) string) SuiteEnv {
+		env := SuiteEnv{
+			Commit: nonEmpty(getenv("GITHUB_SHA")),
+			Branch: nonEmpty(getenv("GITHUB_HEAD_REF")),
+		}
+		// refs/heads/main, or refs/pull/42/merge for pull requests
+		ref := getenv("GITHUB_REF")
+		if env.Branch == nil && strings.HasPrefix(ref, "refs/heads/") {
+			env.Branch = String(strings.TrimPrefix(ref, "refs/heads/"))
+		}
+		if strings.HasPrefix(ref, "refs/pull/") {
+			env.Pr = prNumber(strings.Split(ref, "/")[2])
+		}
+		if id := getenv("GITHUB_RUN_ID"); id != "" {
+			env.BuildUrl = String(getenv("GITHUB_SERVER_URL") + "/" +
+				getenv("GITHUB_REPOSITORY") + "/actions/runs/" + id)
+		}
+		return env
+	}},
+	{"gitlab", "GITLAB_CI", func(getenv func(string) string) SuiteEnv {
+		env := SuiteEnv{
+			Commit:   nonEmpty(getenv("CI_COMMIT_SHA")),
+			Branch:   nonEmpty(getenv("CI_MERGE_REQUEST_SOURCE_BRANCH_NAME")),
+			Pr:       prNumber(getenv("CI_MERGE_REQUEST_IID")),
+			BuildUrl: nonEmpty(getenv("CI_JOB_URL")),
+		}
+		if env.Branch == nil {
+			env.Branch = nonEmpty(getenv("CI_COMMIT_BRANCH"))
+		}
+		return env
+	}},
+	{"circleci", "CIRCLECI", func(getenv func(string) string) SuiteEnv {
+		// the pull request is given by its URL
+		return SuiteEnv{
+			Commit:   nonEmpty(getenv("CIRCLE_SHA1")),
+			Branch:   nonEmpty(getenv("CIRCLE_BRANCH")),
+			Pr:       prNumber(path.Base(getenv("CIRCLE_PULL_REQUEST"))),
+			BuildUrl: nonEmpty(getenv("CIRCLE_BUILD_URL")),
+		}
+	}},
+	{"travis", "TRAVIS", func(getenv func(string) string) SuiteEnv {
+		env := SuiteEnv{
+			Commit:   nonEmpty(getenv("TRAVIS_COMMIT")),
+			Branch:   nonEmpty(getenv("TRAVIS_PULL_REQUEST_BRANCH")),
+			Pr:       prNumber(getenv("TRAVIS_PULL_REQUEST")),
+			BuildUrl: nonEmpty(getenv("TRAVIS_BUILD_WEB_URL")),
+		}
+		if env.Branch == nil {
+			env.Branch = nonEmpty(getenv("TRAVIS_BRANCH"))
+		}
+		return env
+	}},
+	{"buildkite", "BUILDKITE", func(getenv func(string) string) SuiteEnv {
+		return SuiteEnv{
+			Commit:   nonEmpty(getenv("BUILDKITE_COMMIT")),
+			Branch:   nonEmpty(getenv("BUILDKITE_BRANCH")),
+			Pr:       prNumber(getenv("BUILDKITE_PULL_REQUEST")),
+			BuildUrl: nonEmpty(getenv("BUILDKITE_BUILD_URL")),
+		}
+	}},
+	{"jenkins", "JENKINS_URL", func(getenv func(string) string) SuiteEnv {
+		env := SuiteEnv{
+			Commit:   nonEmpty(getenv("GIT_COMMIT")),
+			Branch:   nonEmpty(getenv("CHANGE_BRANCH")),
+			Pr:       prNumber(getenv("CHANGE_ID")),
+			BuildUrl: nonEmpty(getenv("BUILD_URL")),
+		}
+		if env.Branch == nil {
+			env.Branch = nonEmpty(getenv("BRANCH_NAME"))
+		}
+		return env
+	}},
+}
+
+func nonEmpty(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+// prNumber returns the pull request number in s, or nil if s isn't one, such
+// as "false" outside of pull requests.
+func prNumber(s string) *int64 {
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n <= 0 {
+		return nil
+	}
+	return &n
+}
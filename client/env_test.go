@@ -0,0 +1,84 @@
+package client_test
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/suiteserve/suiteserve/client"
+	"runtime"
+	"testing"
+)
+
+func getenv(vars map[string]string) func(string) string {
+	return func(k string) string {
+		return vars[k]
+	}
+}
+
+func TestDetectEnv(t *testing.T) {
+	tests := []struct {
+		name string
+		vars map[string]string
+		want client.SuiteEnv
+	}{
+		{"github push", map[string]string{
+			"GITHUB_ACTIONS":    "true",
+			"GITHUB_SHA":        "abc123",
+			"GITHUB_REF":        "refs/heads/main",
+			"GITHUB_SERVER_URL": "https://github.com",
+			"GITHUB_REPOSITORY": "suiteserve/suiteserve",
+			"GITHUB_RUN_ID":     "7",
+		}, client.SuiteEnv{
+			Commit:   client.String("abc123"),
+			Branch:   client.String("main"),
+			Ci:       client.String("github-actions"),
+			BuildUrl: client.String("https://github.com/suiteserve/suiteserve/actions/runs/7"),
+		}},
+		{"github pull request", map[string]string{
+			"GITHUB_ACTIONS":  "true",
+			"GITHUB_REF":      "refs/pull/42/merge",
+			"GITHUB_HEAD_REF": "fix",
+		}, client.SuiteEnv{
+			Branch: client.String("fix"),
+			Pr:     client.Int64(42),
+			Ci:     client.String("github-actions"),
+		}},
+		{"gitlab", map[string]string{
+			"GITLAB_CI":        "true",
+			"CI_COMMIT_SHA":    "def456",
+			"CI_COMMIT_BRANCH": "main",
+			"CI_JOB_URL":       "https://gitlab.com/a/b/-/jobs/1",
+		}, client.SuiteEnv{
+			Commit:   client.String("def456"),
+			Branch:   client.String("main"),
+			Ci:       client.String("gitlab"),
+			BuildUrl: client.String("https://gitlab.com/a/b/-/jobs/1"),
+		}},
+		{"circleci", map[string]string{
+			"CIRCLECI":            "true",
+			"CIRCLE_BRANCH":       "fix",
+			"CIRCLE_PULL_REQUEST": "https://github.com/a/b/pull/9",
+		}, client.SuiteEnv{
+			Branch: client.String("fix"),
+			Pr:     client.Int64(9),
+			Ci:     client.String("circleci"),
+		}},
+		{"travis push", map[string]string{
+			"TRAVIS":              "true",
+			"TRAVIS_BRANCH":       "main",
+			"TRAVIS_PULL_REQUEST": "false",
+		}, client.SuiteEnv{
+			Branch: client.String("main"),
+			Ci:     client.String("travis"),
+		}},
+		{"none", map[string]string{"CI": "true"}, client.SuiteEnv{}},
+	}
+	for _, tt := range tests {
+		env := client.DetectEnv(getenv(tt.vars))
+		require.NotNil(t, env.Os, tt.name)
+		assert.Equal(t, runtime.GOOS, *env.Os, tt.name)
+		assert.Equal(t, runtime.GOARCH, *env.Arch, tt.name)
+		assert.Equal(t, runtime.Version(), *env.GoVersion, tt.name)
+		env.Host, env.Os, env.Arch, env.GoVersion = nil, nil, nil, nil
+		assert.Equal(t, tt.want, env, tt.name)
+	}
+}
@@ -0,0 +1,200 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	defaultBatchSize     = 100
+	defaultFlushInterval = time.Second
+	flushTimeout         = 30 * time.Second
+)
+
+type BatchOptions struct {
+	// Size is the number of buffered log lines that triggers a flush. Zero
+	// means the default.
+	Size int
+	// FlushInterval is the longest time a log line is buffered for. Zero means
+	// the default.
+	FlushInterval time.Duration
+}
+
+// LogBatcher buffers log lines for a suite or case and inserts them in batches.
+// The lines are indexed in the order they are logged, starting from zero. A
+// LogBatcher is safe for concurrent use.
+type LogBatcher struct {
+	ins     logLineInserter
+	suiteId *Id
+	caseId  *Id
+	size    int
+
+	mu      sync.Mutex
+	idx     int64
+	pending []LogLine
+	err     error
+
+	flushMu sync.Mutex
+	done    chan struct{}
+	wg      sync.WaitGroup
+}
+
+type logLineInserter interface {
+	InsertLogLines(ctx context.Context, lls []LogLine) ([]Id, error)
+}
+
+// NewLogBatcher returns a LogBatcher for the log lines of a case.
+func (c *Client) NewLogBatcher(caseId Id, opts BatchOptions) *LogBatcher {
+	return newLogBatcher(c, nil, &caseId, opts)
+}
+
+// NewSuiteLogBatcher returns a LogBatcher for the log lines of a suite that do
+// not belong to any of its cases.
+func (c *Client) NewSuiteLogBatcher(suiteId Id,
+	opts BatchOptions) *LogBatcher {
+	return newLogBatcher(c, &suiteId, nil, opts)
+}
+
+func newLogBatcher(ins logLineInserter, suiteId, caseId *Id,
+	opts BatchOptions) *LogBatcher {
+	if opts.Size <= 0 {
+		opts.Size = defaultBatchSize
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = defaultFlushInterval
+	}
+	b := LogBatcher{
+		ins:     ins,
+		suiteId: suiteId,
+		caseId:  caseId,
+		size:    opts.Size,
+		done:    make(chan struct{}),
+	}
+	b.wg.Add(1)
+	go b.flushEvery(opts.FlushInterval)
+	return &b
+}
+
+func (b *LogBatcher) flushEvery(d time.Duration) {
+	defer b.wg.Done()
+	ticker := time.NewTicker(d)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.done:
+			return
+		case <-ticker.C:
+			b.flushBackground()
+		}
+	}
+}
+
+func (b *LogBatcher) flushBackground() {
+	ctx, cancel := context.WithTimeout(context.Background(), flushTimeout)
+	defer cancel()
+	_ = b.Flush(ctx)
+}
+
+// Log buffers a log line. It returns the error of the last failed flush, if
+// its lines are still buffered.
+func (b *LogBatcher) Log(line string, isErr bool) error {
+	b.mu.Lock()
+	ll := LogLine{
+		SuiteId: b.suiteId,
+		CaseId:  b.caseId,
+		Idx:     Int64(b.idx),
+		Line:    String(line),
+	}
+	if isErr {
+		ll.Error = Bool(true)
+	}
+	b.idx++
+	b.pending = append(b.pending, ll)
+	full := len(b.pending) >= b.size
+	err := b.err
+	b.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if full {
+		go b.flushBackground()
+	}
+	return nil
+}
+
+// Flush inserts all buffered log lines. If that fails, the lines stay buffered
+// in order, and the next flush tries them again.
+func (b *LogBatcher) Flush(ctx context.Context) error {
+	b.flushMu.Lock()
+	defer b.flushMu.Unlock()
+	b.mu.Lock()
+	lls := b.pending
+	b.pending = nil
+	b.mu.Unlock()
+	if len(lls) == 0 {
+		return nil
+	}
+	_, err := b.ins.InsertLogLines(ctx, lls)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err != nil {
+		// lines logged during the insert go after the failed batch
+		b.pending = append(lls, b.pending...)
+	}
+	b.err = err
+	return err
+}
+
+// Close stops the periodic flushing and flushes any buffered log lines.
+func (b *LogBatcher) Close() error {
+	close(b.done)
+	b.wg.Wait()
+	ctx, cancel := context.WithTimeout(context.Background(), flushTimeout)
+	defer cancel()
+	return b.Flush(ctx)
+}
+
+// Writer returns a LogWriter that logs each line written to it. Lines are
+// terminated by '\n', with any trailing '\r' removed.
+func (b *LogBatcher) Writer(isErr bool) *LogWriter {
+	return &LogWriter{b: b, isErr: isErr}
+}
+
+type LogWriter struct {
+	b     *LogBatcher
+	isErr bool
+
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (w *LogWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		line := bytes.TrimSuffix(w.buf[:i], []byte{'\r'})
+		w.buf = w.buf[i+1:]
+		if err := w.b.Log(string(line), w.isErr); err != nil {
+			return len(p), err
+		}
+	}
+}
+
+// Close logs any unterminated line. It does not close the LogBatcher.
+func (w *LogWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.buf) == 0 {
+		return nil
+	}
+	line := string(w.buf)
+	w.buf = nil
+	return w.b.Log(line, w.isErr)
+}
@@ -0,0 +1,533 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	journalName = "journal.ndjson"
+	blobDirName = "blobs"
+)
+
+// Reporter is implemented by Client and Spool.
+type Reporter interface {
+	InsertAttachment(ctx context.Context, a Attachment, r io.Reader) (Id, error)
+	InsertSuite(ctx context.Context, s Suite) (Id, error)
+	FinishSuite(ctx context.Context, id Id, res SuiteResult, at MsTime) error
+	DisconnectSuite(ctx context.Context, id Id, at MsTime) error
+	InsertCase(ctx context.Context, c Case) (Id, error)
+	FinishCase(ctx context.Context, id Id, res CaseResult, at MsTime) error
+	RetryCase(ctx context.Context, id Id, at MsTime) error
+	InsertLogLines(ctx context.Context, lls []LogLine) ([]Id, error)
+	NewLogBatcher(caseId Id, opts BatchOptions) *LogBatcher
+	NewSuiteLogBatcher(suiteId Id, opts BatchOptions) *LogBatcher
+}
+
+type spoolOp string
+
+const (
+	opInsertAttachment spoolOp = "insertAttachment"
+	opInsertSuite      spoolOp = "insertSuite"
+	opFinishSuite      spoolOp = "finishSuite"
+	opDisconnectSuite  spoolOp = "disconnectSuite"
+	opInsertCase       spoolOp = "insertCase"
+	opFinishCase       spoolOp = "finishCase"
+	opRetryCase        spoolOp = "retryCase"
+	opInsertLogLines   spoolOp = "insertLogLines"
+)
+
+// spoolRecord is a line in the journal. A record with an Op is a pending
+// operation. A Done record acknowledges the operation with the same Seq and,
+// for inserts, maps its TempId to the ServerId. Compaction keeps only pending
+// operations and Done records that map ids.
+type spoolRecord struct {
+	Seq int64   `json:"seq"`
+	Op  spoolOp `json:"op,omitempty"`
+	Key string  `json:"key,omitempty"`
+
+	TempId      *Id         `json:"tempId,omitempty"`
+	Id          *Id         `json:"id,omitempty"`
+	Attachment  *Attachment `json:"attachment,omitempty"`
+	Suite       *Suite      `json:"suite,omitempty"`
+	SuiteResult SuiteResult `json:"suiteResult,omitempty"`
+	Case        *Case       `json:"case,omitempty"`
+	CaseResult  CaseResult  `json:"caseResult,omitempty"`
+	LogLines    []LogLine   `json:"logLines,omitempty"`
+	At          *MsTime     `json:"at,omitempty"`
+
+	Done     bool   `json:"done,omitempty"`
+	ServerId *Id    `json:"serverId,omitempty"`
+	Err      string `json:"err,omitempty"`
+}
+
+// Spool is a Reporter that appends each operation to a journal on disk before
+// returning, then replays the journal against a Client in order. Operations
+// that fail because the server is unreachable are retried until they succeed,
+// so that results reported during an outage are sent once the server is back.
+// Pending operations left by a previous process are replayed when the Spool is
+// opened.
+//
+// Inserts return temporary ids, which may be passed to later operations and
+// are mapped to the ids assigned by the server on replay. ServerId resolves a
+// temporary id once its insert has been replayed.
+type Spool struct {
+	c   *Client
+	dir string
+
+	mu      sync.Mutex
+	f       *os.File
+	seq     int64
+	pending []spoolRecord
+	ids     map[Id]Id
+	err     error
+	changed chan struct{}
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// OpenSpool opens or creates the journal in dir and starts replaying it against
+// c.
+func OpenSpool(c *Client, dir string) (*Spool, error) {
+	if err := os.MkdirAll(filepath.Join(dir, blobDirName), 0755); err != nil {
+		return nil, err
+	}
+	s := Spool{
+		c:       c,
+		dir:     dir,
+		ids:     map[Id]Id{},
+		changed: make(chan struct{}),
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	if err := s.compact(); err != nil {
+		return nil, err
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.wg.Add(1)
+	go s.replay()
+	return &s, nil
+}
+
+func (s *Spool) journalPath() string {
+	return filepath.Join(s.dir, journalName)
+}
+
+func (s *Spool) blobPath(tempId Id) string {
+	return filepath.Join(s.dir, blobDirName, tempId.String())
+}
+
+func (s *Spool) load() error {
+	f, err := os.Open(s.journalPath())
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+	bySeq := map[int64]int{}
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 64<<20)
+	for sc.Scan() {
+		var rec spoolRecord
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			// a torn write at the end of the journal
+			break
+		}
+		if rec.Seq > s.seq {
+			s.seq = rec.Seq
+		}
+		if !rec.Done {
+			bySeq[rec.Seq] = len(s.pending)
+			s.pending = append(s.pending, rec)
+			continue
+		}
+		if rec.TempId != nil && rec.ServerId != nil {
+			s.ids[*rec.TempId] = *rec.ServerId
+		}
+		if i, ok := bySeq[rec.Seq]; ok {
+			s.pending[i].Done = true
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	pending := s.pending[:0]
+	for _, rec := range s.pending {
+		if !rec.Done {
+			pending = append(pending, rec)
+		}
+	}
+	s.pending = pending
+	return nil
+}
+
+// compact rewrites the journal with only the id mappings and the pending
+// operations, then opens it for appending.
+func (s *Spool) compact() error {
+	tmp, err := ioutil.TempFile(s.dir, journalName+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+	for tempId, serverId := range s.ids {
+		tempId, serverId := tempId, serverId
+		err := enc.Encode(spoolRecord{
+			Done:     true,
+			TempId:   &tempId,
+			ServerId: &serverId,
+		})
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	for _, rec := range s.pending {
+		if err := enc.Encode(rec); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), s.journalPath()); err != nil {
+		return err
+	}
+	s.f, err = os.OpenFile(s.journalPath(), os.O_WRONLY|os.O_APPEND, 0644)
+	return err
+}
+
+// Close stops replaying and closes the journal. Operations that have not been
+// replayed yet remain in the journal for the next OpenSpool.
+func (s *Spool) Close() error {
+	s.cancel()
+	s.wg.Wait()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.f.Close()
+}
+
+// Flush waits until every journaled operation has been replayed. It returns the
+// first error the server responded to an operation with, if any. Such
+// operations are not retried.
+func (s *Spool) Flush(ctx context.Context) error {
+	for {
+		s.mu.Lock()
+		n, err, ch := len(s.pending), s.err, s.changed
+		s.mu.Unlock()
+		if n == 0 {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ch:
+		}
+	}
+}
+
+// ServerId returns the id the server assigned for a temporary id returned by an
+// insert, if the insert has been replayed.
+func (s *Spool) ServerId(tempId Id) (Id, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id, ok := s.ids[tempId]
+	return id, ok
+}
+
+func (s *Spool) InsertAttachment(_ context.Context, a Attachment,
+	r io.Reader) (Id, error) {
+	tempId := newTempId()
+	f, err := os.Create(s.blobPath(tempId))
+	if err != nil {
+		return Id{}, err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return Id{}, err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return Id{}, err
+	}
+	if err := f.Close(); err != nil {
+		return Id{}, err
+	}
+	return tempId, s.append(spoolRecord{
+		Op:         opInsertAttachment,
+		TempId:     &tempId,
+		Attachment: &a,
+	})
+}
+
+func (s *Spool) InsertSuite(_ context.Context, suite Suite) (Id, error) {
+	tempId := newTempId()
+	return tempId, s.append(spoolRecord{
+		Op:     opInsertSuite,
+		TempId: &tempId,
+		Suite:  &suite,
+	})
+}
+
+func (s *Spool) FinishSuite(_ context.Context, id Id, res SuiteResult,
+	at MsTime) error {
+	return s.append(spoolRecord{
+		Op:          opFinishSuite,
+		Id:          &id,
+		SuiteResult: res,
+		At:          &at,
+	})
+}
+
+func (s *Spool) DisconnectSuite(_ context.Context, id Id, at MsTime) error {
+	return s.append(spoolRecord{
+		Op: opDisconnectSuite,
+		Id: &id,
+		At: &at,
+	})
+}
+
+func (s *Spool) InsertCase(_ context.Context, c Case) (Id, error) {
+	tempId := newTempId()
+	return tempId, s.append(spoolRecord{
+		Op:     opInsertCase,
+		TempId: &tempId,
+		Case:   &c,
+	})
+}
+
+func (s *Spool) FinishCase(_ context.Context, id Id, res CaseResult,
+	at MsTime) error {
+	return s.append(spoolRecord{
+		Op:         opFinishCase,
+		Id:         &id,
+		CaseResult: res,
+		At:         &at,
+	})
+}
+
+func (s *Spool) RetryCase(_ context.Context, id Id, at MsTime) error {
+	return s.append(spoolRecord{
+		Op: opRetryCase,
+		Id: &id,
+		At: &at,
+	})
+}
+
+// InsertLogLines journals the log lines. Unlike the other inserts, it returns
+// no ids, as log lines cannot be referenced by other operations.
+func (s *Spool) InsertLogLines(_ context.Context,
+	lls []LogLine) ([]Id, error) {
+	return nil, s.append(spoolRecord{
+		Op:       opInsertLogLines,
+		LogLines: lls,
+	})
+}
+
+func (s *Spool) NewLogBatcher(caseId Id, opts BatchOptions) *LogBatcher {
+	return newLogBatcher(s, nil, &caseId, opts)
+}
+
+func (s *Spool) NewSuiteLogBatcher(suiteId Id, opts BatchOptions) *LogBatcher {
+	return newLogBatcher(s, &suiteId, nil, opts)
+}
+
+func newTempId() Id {
+	return Id(primitive.NewObjectID())
+}
+
+func (s *Spool) append(rec spoolRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.seq++
+	rec.Seq = s.seq
+	rec.Key = newTempId().String()
+	if err := s.write(rec); err != nil {
+		return err
+	}
+	s.pending = append(s.pending, rec)
+	s.notifyLocked()
+	return nil
+}
+
+func (s *Spool) write(rec spoolRecord) error {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if _, err := s.f.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	return s.f.Sync()
+}
+
+func (s *Spool) notifyLocked() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+func (s *Spool) replay() {
+	defer s.wg.Done()
+	backoff := s.c.backoff
+	for {
+		s.mu.Lock()
+		var rec *spoolRecord
+		if len(s.pending) > 0 {
+			next := s.pending[0]
+			rec = &next
+		}
+		ch := s.changed
+		s.mu.Unlock()
+		if rec == nil {
+			select {
+			case <-s.ctx.Done():
+				return
+			case <-ch:
+				continue
+			}
+		}
+		serverId, err := s.exec(*rec)
+		if s.ctx.Err() != nil {
+			return
+		}
+		if err != nil && isRetryable(err) {
+			timer := time.NewTimer(backoff)
+			select {
+			case <-s.ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+			if backoff *= 2; backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+			continue
+		}
+		backoff = s.c.backoff
+		if err := s.ack(*rec, serverId, err); err != nil {
+			s.mu.Lock()
+			if s.err == nil {
+				s.err = err
+			}
+			s.mu.Unlock()
+			return
+		}
+	}
+}
+
+func (s *Spool) ack(rec spoolRecord, serverId *Id, opErr error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	done := spoolRecord{
+		Seq:      rec.Seq,
+		Done:     true,
+		TempId:   rec.TempId,
+		ServerId: serverId,
+	}
+	if opErr != nil {
+		done.Err = opErr.Error()
+		if s.err == nil {
+			s.err = fmt.Errorf("replay %s: %w", rec.Op, opErr)
+		}
+	}
+	if err := s.write(done); err != nil {
+		return err
+	}
+	if rec.TempId != nil && serverId != nil {
+		s.ids[*rec.TempId] = *serverId
+	}
+	if rec.Op == opInsertAttachment {
+		_ = os.Remove(s.blobPath(*rec.TempId))
+	}
+	s.pending = s.pending[1:]
+	s.notifyLocked()
+	return nil
+}
+
+func (s *Spool) resolve(id *Id) *Id {
+	if id == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if serverId, ok := s.ids[*id]; ok {
+		return &serverId
+	}
+	return id
+}
+
+func (s *Spool) exec(rec spoolRecord) (*Id, error) {
+	// the same key across replays keeps the server from inserting twice if the
+	// process stops after an insert but before its acknowledgement
+	ctx := WithIdempotencyKey(s.ctx, rec.Key)
+	var id Id
+	var err error
+	switch rec.Op {
+	case opInsertAttachment:
+		a := *rec.Attachment
+		a.SuiteId = s.resolve(a.SuiteId)
+		a.CaseId = s.resolve(a.CaseId)
+		var f *os.File
+		if f, err = os.Open(s.blobPath(*rec.TempId)); err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		id, err = s.c.InsertAttachment(ctx, a, f)
+	case opInsertSuite:
+		id, err = s.c.InsertSuite(ctx, *rec.Suite)
+	case opFinishSuite:
+		return nil, s.c.FinishSuite(ctx, *s.resolve(rec.Id), rec.SuiteResult,
+			*rec.At)
+	case opDisconnectSuite:
+		return nil, s.c.DisconnectSuite(ctx, *s.resolve(rec.Id), *rec.At)
+	case opInsertCase:
+		c := *rec.Case
+		c.SuiteId = s.resolve(c.SuiteId)
+		c.ParentId = s.resolve(c.ParentId)
+		id, err = s.c.InsertCase(ctx, c)
+	case opFinishCase:
+		return nil, s.c.FinishCase(ctx, *s.resolve(rec.Id), rec.CaseResult,
+			*rec.At)
+	case opRetryCase:
+		return nil, s.c.RetryCase(ctx, *s.resolve(rec.Id), *rec.At)
+	case opInsertLogLines:
+		lls := make([]LogLine, len(rec.LogLines))
+		for i, ll := range rec.LogLines {
+			ll.SuiteId = s.resolve(ll.SuiteId)
+			ll.CaseId = s.resolve(ll.CaseId)
+			lls[i] = ll
+		}
+		_, err = s.c.InsertLogLines(ctx, lls)
+		return nil, err
+	default:
+		return nil, errors.New("unknown op " + string(rec.Op))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &id, nil
+}
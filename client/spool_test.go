@@ -0,0 +1,165 @@
+package client_test
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/suiteserve/suiteserve/client"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeServer struct {
+	mu     sync.Mutex
+	down   bool
+	suites []client.Suite
+	cases  []client.Case
+	logs   []client.LogLine
+	// retries are the ids of the cases retried.
+	retries []string
+}
+
+func (s *fakeServer) setDown(down bool) {
+	s.mu.Lock()
+	s.down = down
+	s.mu.Unlock()
+}
+
+func (s *fakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.down {
+		http.Error(w, "down", http.StatusServiceUnavailable)
+		return
+	}
+	id := client.Id(primitive.NewObjectID())
+	switch r.URL.Path {
+	case "/v1/suites":
+		var suite client.Suite
+		_ = json.NewDecoder(r.Body).Decode(&suite)
+		suite.Id = &id
+		s.suites = append(s.suites, suite)
+	case "/v1/cases":
+		var c client.Case
+		_ = json.NewDecoder(r.Body).Decode(&c)
+		c.Id = &id
+		s.cases = append(s.cases, c)
+	case "/v1/logs":
+		var lls []client.LogLine
+		_ = json.NewDecoder(r.Body).Decode(&lls)
+		s.logs = append(s.logs, lls...)
+		_, _ = w.Write([]byte("[]"))
+		return
+	default:
+		caseId := strings.TrimPrefix(r.URL.Path, "/v1/cases/")
+		if r.Method != http.MethodPatch || caseId == r.URL.Path ||
+			r.URL.Query().Get("retry") != "true" {
+			http.NotFound(w, r)
+			return
+		}
+		s.retries = append(s.retries, caseId)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(id)
+}
+
+func TestSpool(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	srv := fakeServer{down: true}
+	c := newClient(t, &srv)
+
+	s, err := client.OpenSpool(c, dir)
+	require.Nil(t, err)
+	suiteId, err := s.InsertSuite(ctx, client.Suite{
+		Project: client.String("api"),
+	})
+	require.Nil(t, err)
+	require.Nil(t, s.Close())
+
+	s, err = client.OpenSpool(c, dir)
+	require.Nil(t, err)
+	defer s.Close()
+	caseId, err := s.InsertCase(ctx, client.Case{SuiteId: &suiteId})
+	require.Nil(t, err)
+	_, err = s.InsertLogLines(ctx, []client.LogLine{{CaseId: &caseId}})
+	require.Nil(t, err)
+
+	srv.setDown(false)
+	flushCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	require.Nil(t, s.Flush(flushCtx))
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	require.Len(t, srv.suites, 1)
+	require.Len(t, srv.cases, 1)
+	require.Len(t, srv.logs, 1)
+	serverSuiteId, ok := s.ServerId(suiteId)
+	require.True(t, ok)
+	assert.Equal(t, *srv.suites[0].Id, serverSuiteId)
+	assert.Equal(t, serverSuiteId, *srv.cases[0].SuiteId)
+	assert.Equal(t, *srv.cases[0].Id, *srv.logs[0].CaseId)
+}
+
+func TestSpool_ParentCase(t *testing.T) {
+	ctx := context.Background()
+	srv := fakeServer{down: true}
+	c := newClient(t, &srv)
+
+	s, err := client.OpenSpool(c, t.TempDir())
+	require.Nil(t, err)
+	defer s.Close()
+	suiteId, err := s.InsertSuite(ctx, client.Suite{})
+	require.Nil(t, err)
+	parentId, err := s.InsertCase(ctx, client.Case{SuiteId: &suiteId})
+	require.Nil(t, err)
+	_, err = s.InsertCase(ctx, client.Case{
+		SuiteId:  &suiteId,
+		ParentId: &parentId,
+	})
+	require.Nil(t, err)
+
+	srv.setDown(false)
+	flushCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	require.Nil(t, s.Flush(flushCtx))
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	require.Len(t, srv.cases, 2)
+	assert.Nil(t, srv.cases[0].ParentId)
+	require.NotNil(t, srv.cases[1].ParentId)
+	assert.Equal(t, *srv.cases[0].Id, *srv.cases[1].ParentId)
+}
+
+func TestSpool_RetryCase(t *testing.T) {
+	ctx := context.Background()
+	srv := fakeServer{down: true}
+	c := newClient(t, &srv)
+
+	s, err := client.OpenSpool(c, t.TempDir())
+	require.Nil(t, err)
+	defer s.Close()
+	suiteId, err := s.InsertSuite(ctx, client.Suite{})
+	require.Nil(t, err)
+	caseId, err := s.InsertCase(ctx, client.Case{SuiteId: &suiteId})
+	require.Nil(t, err)
+	require.Nil(t, s.RetryCase(ctx, caseId, client.MsTime(time.Now())))
+
+	srv.setDown(false)
+	flushCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	require.Nil(t, s.Flush(flushCtx))
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	require.Len(t, srv.cases, 1)
+	assert.Equal(t, []string{srv.cases[0].Id.String()}, srv.retries)
+}
@@ -0,0 +1,58 @@
+package client
+
+import "github.com/suiteserve/suiteserve/internal/repo"
+
+// These aliases expose the server's entity types to importers outside of this
+// module.
+type (
+	Id              = repo.Id
+	MsTime          = repo.MsTime
+	Entity          = repo.Entity
+	VersionedEntity = repo.VersionedEntity
+
+	Attachment = repo.Attachment
+
+	Suite       = repo.Suite
+	SuiteEnv    = repo.SuiteEnv
+	SuiteStatus = repo.SuiteStatus
+	SuiteResult = repo.SuiteResult
+	SuitePage   = repo.SuitePage
+
+	Case       = repo.Case
+	CaseStatus = repo.CaseStatus
+	CaseResult = repo.CaseResult
+	Attempt    = repo.Attempt
+
+	LogLine = repo.LogLine
+
+	QuarantinedTest = repo.QuarantinedTest
+)
+
+const (
+	SuiteStatusStarted      = repo.SuiteStatusStarted
+	SuiteStatusFinished     = repo.SuiteStatusFinished
+	SuiteStatusDisconnected = repo.SuiteStatusDisconnected
+
+	SuiteResultPassed = repo.SuiteResultPassed
+	SuiteResultFailed = repo.SuiteResultFailed
+
+	CaseStatusCreated  = repo.CaseStatusCreated
+	CaseStatusStarted  = repo.CaseStatusStarted
+	CaseStatusFinished = repo.CaseStatusFinished
+
+	CaseResultPassed  = repo.CaseResultPassed
+	CaseResultFailed  = repo.CaseResultFailed
+	CaseResultSkipped = repo.CaseResultSkipped
+	CaseResultAborted = repo.CaseResultAborted
+	CaseResultErrored = repo.CaseResultErrored
+
+	CaseResultPassedOnRetry = repo.CaseResultPassedOnRetry
+)
+
+var (
+	NewId     = repo.NewId
+	NewMsTime = repo.NewMsTime
+	Bool      = repo.Bool
+	Int64     = repo.Int64
+	String    = repo.String
+)
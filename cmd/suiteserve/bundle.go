@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/suiteserve/suiteserve/internal/bundle"
+	"github.com/suiteserve/suiteserve/internal/config"
+	"github.com/suiteserve/suiteserve/internal/repo"
+	"io"
+	"log"
+	"os"
+)
+
+func exportCmd(cfg *config.Config, args []string) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	out := fs.String("o", "-",
+		"The file to write the archive to, or - for standard output")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s export [-o file] <suite id>\n",
+			os.Args[0])
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	id, err := repo.NewId(fs.Arg(0))
+	if err != nil {
+		log.Fatalf("parse suite id: %v", err)
+	}
+
+	r := openRepo(cfg)
+	defer func() {
+		if err := r.Close(); err != nil {
+			log.Printf("close repo: %v", err)
+		}
+	}()
+	var w io.Writer = os.Stdout
+	if *out != "-" {
+		f, err := os.Create(*out)
+		if err != nil {
+			log.Fatalf("create archive: %v", err)
+		}
+		defer func() {
+			if err := f.Close(); err != nil {
+				log.Fatalf("close archive: %v", err)
+			}
+		}()
+		w = f
+	}
+	err = bundle.Export(context.Background(), w, r,
+		cfg.Storage.UserContent.Dir, id)
+	if err != nil {
+		log.Fatalf("export suite: %v", err)
+	}
+	log.Printf("Exported suite %s", id)
+}
+
+func importCmd(cfg *config.Config, args []string) {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s import <file>\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	var rd io.Reader = os.Stdin
+	if fs.Arg(0) != "-" {
+		f, err := os.Open(fs.Arg(0))
+		if err != nil {
+			log.Fatalf("open archive: %v", err)
+		}
+		defer f.Close()
+		rd = f
+	}
+	r := openRepo(cfg)
+	defer func() {
+		if err := r.Close(); err != nil {
+			log.Printf("close repo: %v", err)
+		}
+	}()
+	id, err := bundle.Import(context.Background(), rd, r,
+		cfg.Storage.UserContent.Dir,
+		int64(cfg.Storage.UserContent.MaxSizeMb)<<20)
+	if err != nil {
+		log.Fatalf("import suite: %v", err)
+	}
+	log.Printf("Imported suite as %s", id)
+	fmt.Println(id)
+}
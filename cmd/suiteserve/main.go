@@ -4,8 +4,12 @@ import (
 	"bytes"
 	"context"
 	"flag"
+	"fmt"
 	"github.com/suiteserve/suiteserve/internal/api"
+	"github.com/suiteserve/suiteserve/internal/baseline"
 	"github.com/suiteserve/suiteserve/internal/config"
+	"github.com/suiteserve/suiteserve/internal/oidc"
+	"github.com/suiteserve/suiteserve/internal/purge"
 	"github.com/suiteserve/suiteserve/internal/repo"
 	"io/ioutil"
 	"log"
@@ -13,8 +17,13 @@ import (
 	"os"
 	"os/signal"
 	"strconv"
+	"time"
 )
 
+// purgeInterval is how often deleted suites are checked for purging, and
+// retention rules are applied.
+const purgeInterval = time.Hour
+
 var (
 	configFlag = flag.String("config", "config/config.json",
 		"The path to the JSON configuration file")
@@ -25,18 +34,66 @@ var (
 )
 
 func main() {
+	flag.Usage = usage
 	flag.Parse()
 	if *debugFlag {
 		log.SetFlags(log.LstdFlags | log.Lmicroseconds | log.Lshortfile)
 		log.Print("Debug mode enabled")
 	}
 
+	cmd, args := "serve", flag.Args()
+	if len(args) > 0 {
+		cmd, args = args[0], args[1:]
+	}
+	switch cmd {
+	case "serve":
+		serve(loadConfig())
+	case "export":
+		exportCmd(loadConfig(), args)
+	case "import":
+		importCmd(loadConfig(), args)
+	case "token":
+		tokenCmd(loadConfig(), args)
+	case "role":
+		roleCmd(loadConfig(), args)
+	case "group":
+		groupCmd(loadConfig(), args)
+	case "run":
+		os.Exit(runCmd(args))
+	default:
+		fmt.Fprintf(flag.CommandLine.Output(), "unknown command %q\n", cmd)
+		flag.Usage()
+		os.Exit(2)
+	}
+}
+
+func loadConfig() *config.Config {
 	log.Printf("Using config at %q", *configFlag)
 	cfg, err := config.Load(*configFlag)
 	if err != nil {
 		log.Fatalf("load config: %v", err)
 	}
+	return cfg
+}
 
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s [flags] [command]\n\n", os.Args[0])
+	fmt.Fprint(out, `Commands:
+  serve    Serve the API and UI (default)
+  export   Export a suite to an archive
+  import   Import a suite from an archive
+  token    Create, list or revoke API tokens
+  role     Grant, list or revoke roles for projects
+  group    Manage the members of groups
+  run      Run a command and report it as a suite
+
+Flags:
+`)
+	flag.PrintDefaults()
+}
+
+func serve(cfg *config.Config) {
 	r := openRepo(cfg)
 	defer func() {
 		if err := r.Close(); err != nil {
@@ -52,17 +109,41 @@ func main() {
 	apiAddr := net.JoinHostPort(cfg.Http.Host,
 		strconv.FormatUint(uint64(cfg.Http.Port), 10))
 	opts := api.Options{
-		Addr: apiAddr,
+		Addr:            apiAddr,
 		TlsCertFile:     cfg.Http.TlsCertFile,
 		TlsKeyFile:      cfg.Http.TlsKeyFile,
 		PublicDir:       cfg.Http.PublicDir,
 		UserContentHost: cfg.Http.UserContentHost,
 		UserContentDir:  cfg.Storage.UserContent.Dir,
 		UserContentRepo: nil,
-		V1:              api.NewV1Handler(r),
+		V1: api.NewV1Handler(r, cfg.Storage.UserContent.Dir,
+			int64(cfg.Storage.UserContent.MaxSizeMb)<<20, baselineRules(cfg)),
+		AuthRepo:  r,
+		Anonymous: cfg.Auth.Anonymous,
+		Sso:       newSso(cfg, r),
 	}
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
+	p := purge.Purger{
+		Repo:           r,
+		UserContentDir: cfg.Storage.UserContent.Dir,
+		Grace:          time.Duration(cfg.Retention.DeletedGraceHours) * time.Hour,
+	}
+	go p.Run(ctx, purgeInterval)
+	pr := purge.Pruner{
+		Repo:           r,
+		UserContentDir: cfg.Storage.UserContent.Dir,
+		Rules:          retentionRules(cfg),
+		ProjectRules: func(ctx context.Context) ([]purge.Rule, error) {
+			ps, err := r.Projects(ctx, repo.ProjectFilter{})
+			if err != nil {
+				return nil, err
+			}
+			return purge.ProjectRules(ps), nil
+		},
+		BatchSize: cfg.Retention.BatchSize,
+	}
+	go pr.Run(ctx, purgeInterval)
 	go func() {
 		defer cancel()
 		ch := make(chan os.Signal, 1)
@@ -77,6 +158,79 @@ func main() {
 	}
 }
 
+func newSso(cfg *config.Config, r *repo.Repo) *api.Sso {
+	oc := cfg.Auth.Oidc
+	if oc.Issuer == "" {
+		return nil
+	}
+	var secret []byte
+	if oc.ClientSecretFile != "" {
+		var err error
+		secret, err = ioutil.ReadFile(oc.ClientSecretFile)
+		if err != nil {
+			log.Fatalf("read oidc client secret file: %v", err)
+		}
+	}
+	log.Printf("Using OpenID Connect provider at %q", oc.Issuer)
+	p, err := oidc.Discover(context.Background(), nil, oidc.Config{
+		Issuer:       oc.Issuer,
+		ClientId:     oc.ClientId,
+		ClientSecret: string(bytes.TrimSuffix(secret, []byte{'\n'})),
+		RedirectUrl:  oc.RedirectUrl,
+		Scopes:       oc.Scopes,
+	})
+	if err != nil {
+		log.Fatalf("discover oidc provider: %v", err)
+	}
+	return &api.Sso{
+		Provider: p,
+		Repo:     r,
+		Ttl:      time.Duration(oc.SessionHours) * time.Hour,
+	}
+}
+
+func retentionRules(cfg *config.Config) []purge.Rule {
+	var rules []purge.Rule
+	for i, rc := range cfg.Retention.Rules {
+		var r purge.Rule
+		if project := rc.Project; project != "" {
+			r.Project = &project
+		}
+		switch res := repo.SuiteResult(rc.Result); res {
+		case "":
+		case repo.SuiteResultPassed, repo.SuiteResultFailed:
+			r.Result = &res
+		default:
+			log.Fatalf("retention rule %d: bad result %q", i, rc.Result)
+		}
+		if rc.MaxAgeDays <= 0 && rc.KeepLast <= 0 {
+			log.Fatalf("retention rule %d: want max_age_days or keep_last", i)
+		}
+		r.MaxAge = time.Duration(rc.MaxAgeDays) * 24 * time.Hour
+		r.KeepLast = rc.KeepLast
+		log.Printf("Retention rule %d prunes %s", i, r)
+		rules = append(rules, r)
+	}
+	return rules
+}
+
+func baselineRules(cfg *config.Config) []baseline.Rule {
+	var rules []baseline.Rule
+	for i, bc := range cfg.Baselines {
+		r := baseline.Rule{
+			Labels:     bc.Labels,
+			SameLabels: bc.SameLabels,
+			SameTags:   bc.SameTags,
+		}
+		if project := bc.Project; project != "" {
+			r.Project = &project
+		}
+		log.Printf("Baseline rule %d selects %s", i, r)
+		rules = append(rules, r)
+	}
+	return rules
+}
+
 func openRepo(cfg *config.Config) *repo.Repo {
 	addr := net.JoinHostPort(cfg.Storage.MongoDb.Host,
 		strconv.FormatUint(uint64(cfg.Storage.MongoDb.Port), 10))
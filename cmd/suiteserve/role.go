@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/suiteserve/suiteserve/internal/config"
+	"github.com/suiteserve/suiteserve/internal/repo"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+func roleCmd(cfg *config.Config, args []string) {
+	usage := func() {
+		fmt.Fprintf(flag.CommandLine.Output(), `Usage:
+  %[1]s role grant -project <project|*> -role <viewer|reporter|admin> (-user <user> | -group <group>)
+  %[1]s role list
+  %[1]s role revoke <role binding id>
+`, os.Args[0])
+	}
+	if len(args) == 0 {
+		usage()
+		os.Exit(2)
+	}
+	ctx := context.Background()
+	switch args[0] {
+	case "grant":
+		fs := flag.NewFlagSet("role grant", flag.ExitOnError)
+		project := fs.String("project", "",
+			"The project to grant the role for, or * for every project")
+		role := fs.String("role", "", "The role: viewer, reporter or admin")
+		user := fs.String("user", "",
+			"The subject or email of the user to grant the role to")
+		group := fs.String("group", "", "The group to grant the role to")
+		_ = fs.Parse(args[1:])
+		if *project == "" || !repo.Role(*role).Includes(repo.RoleViewer) ||
+			(*user == "") == (*group == "") {
+			fs.Usage()
+			os.Exit(2)
+		}
+		b := repo.RoleBinding{
+			Project: project,
+			Role:    (*repo.Role)(role),
+		}
+		if *user != "" {
+			b.User = user
+		} else {
+			b.Group = group
+		}
+		r := openRepo(cfg)
+		defer r.Close()
+		id, err := r.InsertRoleBinding(ctx, b)
+		if err != nil {
+			log.Fatalf("grant role: %v", err)
+		}
+		log.Printf("Created role binding %s", id)
+	case "list":
+		r := openRepo(cfg)
+		defer r.Close()
+		bs, err := r.RoleBindings(ctx)
+		if err != nil {
+			log.Fatalf("list role bindings: %v", err)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tPROJECT\tROLE\tUSER\tGROUP")
+		for _, b := range bs {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", b.Id, strOr(b.Project),
+				strOr((*string)(b.Role)), strOr(b.User), strOr(b.Group))
+		}
+		if err := w.Flush(); err != nil {
+			log.Fatalf("list role bindings: %v", err)
+		}
+	case "revoke":
+		if len(args) != 2 {
+			usage()
+			os.Exit(2)
+		}
+		id, err := repo.NewId(args[1])
+		if err != nil {
+			log.Fatalf("parse role binding id: %v", err)
+		}
+		r := openRepo(cfg)
+		defer r.Close()
+		if err := r.DeleteRoleBinding(ctx, id); err != nil {
+			log.Fatalf("revoke role: %v", err)
+		}
+		log.Printf("Deleted role binding %s", id)
+	default:
+		usage()
+		os.Exit(2)
+	}
+}
+
+func groupCmd(cfg *config.Config, args []string) {
+	usage := func() {
+		fmt.Fprintf(flag.CommandLine.Output(), `Usage:
+  %[1]s group add <group> <user>
+  %[1]s group remove <group> <user>
+  %[1]s group list
+`, os.Args[0])
+	}
+	if len(args) == 0 {
+		usage()
+		os.Exit(2)
+	}
+	ctx := context.Background()
+	switch {
+	case (args[0] == "add" || args[0] == "remove") && len(args) == 3:
+		r := openRepo(cfg)
+		defer r.Close()
+		if args[0] == "add" {
+			if err := r.AddGroupMember(ctx, args[1], args[2]); err != nil {
+				log.Fatalf("add group member: %v", err)
+			}
+			log.Printf("Added %q to group %q", args[2], args[1])
+		} else {
+			if err := r.RemoveGroupMember(ctx, args[1], args[2]); err != nil {
+				log.Fatalf("remove group member: %v", err)
+			}
+			log.Printf("Removed %q from group %q", args[2], args[1])
+		}
+	case args[0] == "list" && len(args) == 1:
+		r := openRepo(cfg)
+		defer r.Close()
+		gs, err := r.Groups(ctx)
+		if err != nil {
+			log.Fatalf("list groups: %v", err)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "GROUP\tMEMBERS")
+		for _, g := range gs {
+			fmt.Fprintf(w, "%s\t%s\n", strOr(g.Name),
+				strings.Join(g.Members, ","))
+		}
+		if err := w.Flush(); err != nil {
+			log.Fatalf("list groups: %v", err)
+		}
+	default:
+		usage()
+		os.Exit(2)
+	}
+}
@@ -0,0 +1,435 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/suiteserve/suiteserve/client"
+	"github.com/suiteserve/suiteserve/internal/testfmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringsFlag) Set(s string) error {
+	*f = append(*f, s)
+	return nil
+}
+
+func envOr(k, def string) string {
+	if v, ok := os.LookupEnv(k); ok {
+		return v
+	}
+	return def
+}
+
+func runCmd(args []string) int {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	server := fs.String("server",
+		envOr("SUITESERVE_URL", "https://localhost:8080"),
+		"The URL of the SuiteServe instance")
+	ca := fs.String("ca", os.Getenv("SUITESERVE_CA"),
+		"The path to a PEM file of CA certificates to trust")
+	token := fs.String("token", os.Getenv("SUITESERVE_TOKEN"),
+		"An ingest token for the project")
+	project := fs.String("project", "", "The project of the suite")
+	var tags stringsFlag
+	fs.Var(&tags, "tag", "A tag of the suite, which may be repeated")
+	var labels stringsFlag
+	fs.Var(&labels, "label",
+		"A label of the suite as key=value, which may be repeated")
+	detectEnv := fs.Bool("env", true,
+		"Whether to report the build and machine, detected from CI variables")
+	format := fs.String("format", "auto",
+		"The format of the command's standard output: auto, gotest, tap or none")
+	spoolDir := fs.String("spool", "",
+		"The directory to journal results in while the server is unreachable")
+	flushTimeout := fs.Duration("flush-timeout", time.Minute,
+		"How long to wait for journaled results to be sent before exiting")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(),
+			"Usage: %s run [flags] -- <command> [args...]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	labelMap := map[string]string{}
+	for _, l := range labels {
+		i := strings.IndexByte(l, '=')
+		if i <= 0 {
+			fmt.Fprintf(fs.Output(), "bad label %q, want key=value\n", l)
+			fs.Usage()
+			return 2
+		}
+		labelMap[l[:i]] = l[i+1:]
+	}
+	rn := runner{
+		ctx:   context.Background(),
+		cases: map[string]*runCase{},
+	}
+	switch *format {
+	case "auto":
+		rn.detect = true
+	case "gotest":
+		rn.parser = &testfmt.GoTestJson{}
+	case "tap":
+		rn.parser = &testfmt.Tap{}
+	case "none":
+	default:
+		fs.Usage()
+		return 2
+	}
+
+	c, err := client.New(client.Options{
+		BaseUrl: *server,
+		CaFile:  *ca,
+		Token:   *token,
+	})
+	if err != nil {
+		log.Fatalf("create client: %v", err)
+	}
+	rn.rep = c
+	if *project != "" {
+		qs, err := c.Quarantines(rn.ctx, *project)
+		if err != nil {
+			// the tests run either way, and the server still applies them
+			log.Printf("get quarantines: %v", err)
+		}
+		rn.quarantined = map[string]bool{}
+		for _, q := range qs {
+			// cases are reported without params, so that the quarantines of
+			// tests with params don't apply to them here or on the server
+			if len(q.Params) == 0 {
+				rn.quarantined[q.Name] = true
+			}
+		}
+	}
+	if *spoolDir != "" {
+		s, err := client.OpenSpool(c, *spoolDir)
+		if err != nil {
+			log.Fatalf("open spool: %v", err)
+		}
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(),
+				*flushTimeout)
+			defer cancel()
+			if err := s.Flush(ctx); errors.Is(err, context.DeadlineExceeded) {
+				log.Printf("Unsent results remain in %q", *spoolDir)
+			} else if err != nil {
+				log.Printf("flush spool: %v", err)
+			}
+			if err := s.Close(); err != nil {
+				log.Printf("close spool: %v", err)
+			}
+		}()
+		rn.rep = s
+	}
+
+	s := client.Suite{
+		Tags:      tags,
+		Status:    statusPtr(client.SuiteStatusStarted),
+		StartedAt: now(),
+	}
+	if *project != "" {
+		s.Project = project
+	}
+	if len(labelMap) > 0 {
+		s.Labels = labelMap
+	}
+	if *detectEnv {
+		env := client.DetectEnv(os.Getenv)
+		if v := toolchainVersion(fs.Arg(0)); v != nil {
+			env.GoVersion = v
+		}
+		s.Env = &env
+	}
+	return rn.run(s, fs.Args())
+}
+
+// toolchainVersion returns the version of the Go toolchain that cmd is, as
+// the tests it runs are built with it rather than with this program's
+// version, or nil if cmd isn't go.
+func toolchainVersion(cmd string) *string {
+	if filepath.Base(cmd) != "go" {
+		return nil
+	}
+	// go version go1.15.2 linux/amd64
+	out, err := exec.Command(cmd, "version").Output()
+	if err != nil {
+		return nil
+	}
+	fields := strings.Fields(string(out))
+	if len(fields) < 3 {
+		return nil
+	}
+	return &fields[2]
+}
+
+type runner struct {
+	ctx    context.Context
+	rep    client.Reporter
+	detect bool
+	parser testfmt.Parser
+	// quarantined are the names of the quarantined tests of the project.
+	quarantined map[string]bool
+
+	suiteId client.Id
+	logs    *client.LogBatcher
+	cases   map[string]*runCase
+	nextIdx int64
+	// failed are the names of the tests that failed.
+	failed []string
+	err    error
+}
+
+type runCase struct {
+	id       client.Id
+	logs     *client.LogBatcher
+	finished bool
+}
+
+// run runs the command in args and reports it as the suite s, returning the
+// exit code of the command. The command runs even if the suite can't be
+// reported, so that an unreachable server doesn't keep tests from running.
+func (rn *runner) run(s client.Suite, args []string) int {
+	var err error
+	rn.suiteId, err = rn.rep.InsertSuite(rn.ctx, s)
+	// nothing else is reported without the suite
+	inserted := rn.check(err)
+	var stderr io.WriteCloser
+	if inserted {
+		log.Printf("Reporting suite %s", rn.suiteId)
+		rn.logs = rn.rep.NewSuiteLogBatcher(rn.suiteId, client.BatchOptions{})
+		stderr = rn.logs.Writer(true)
+	}
+
+	// let the command handle interrupts, then report how it exited
+	signal.Ignore(os.Interrupt)
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stderr = os.Stderr
+	if inserted {
+		cmd.Stderr = io.MultiWriter(os.Stderr, stderr)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		log.Fatalf("pipe stdout: %v", err)
+	}
+	code := 0
+	if err := cmd.Start(); err != nil {
+		log.Printf("start command: %v", err)
+		if inserted {
+			rn.check(rn.logs.Log(err.Error(), true))
+		}
+		code = 127
+	} else {
+		rn.readStdout(stdout)
+		var exitErr *exec.ExitError
+		if err := cmd.Wait(); errors.As(err, &exitErr) {
+			code = exitErr.ExitCode()
+		} else if err != nil {
+			log.Printf("wait for command: %v", err)
+			code = 1
+		}
+	}
+
+	for _, c := range rn.cases {
+		// a finished case does not accept logs
+		rn.check(c.logs.Close())
+		if !c.finished && rn.err == nil {
+			rn.check(rn.rep.FinishCase(rn.ctx, c.id, client.CaseResultAborted,
+				*now()))
+		}
+	}
+	if inserted {
+		rn.check(stderr.Close())
+		rn.check(rn.logs.Close())
+		rn.finishSuite(code)
+	}
+	if code != 0 && rn.onlyQuarantinedFailed() {
+		log.Printf("Only quarantined tests failed")
+		return 0
+	}
+	return code
+}
+
+// finishSuite finishes the suite with the exit code of the command, even after
+// a reporting error, as a suite that is left started is never pruned. If that
+// fails, the suite is disconnected instead.
+func (rn *runner) finishSuite(code int) {
+	res := client.SuiteResultPassed
+	if code != 0 {
+		res = client.SuiteResultFailed
+	}
+	err := rn.rep.FinishSuite(rn.ctx, rn.suiteId, res, *now())
+	if err == nil {
+		return
+	}
+	log.Printf("finish suite: %v", err)
+	err = rn.rep.DisconnectSuite(rn.ctx, rn.suiteId, *now())
+	if err != nil {
+		log.Printf("disconnect suite: %v", err)
+	}
+}
+
+// onlyQuarantinedFailed reports whether tests failed and all of them are
+// quarantined, which is when the server passes the suite. A Go test that
+// failed because a quarantined subtest did is not excused, as its own result
+// is a failure.
+func (rn *runner) onlyQuarantinedFailed() bool {
+	if len(rn.failed) == 0 {
+		return false
+	}
+	for _, name := range rn.failed {
+		if !rn.quarantined[name] {
+			return false
+		}
+	}
+	return true
+}
+
+func (rn *runner) readStdout(r io.Reader) {
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadString('\n')
+		if len(line) > 0 {
+			_, _ = os.Stdout.WriteString(line)
+			rn.handleLine(strings.TrimRight(line, "\r\n"))
+		}
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("read stdout: %v", err)
+			}
+			if rn.parser != nil && rn.err == nil {
+				for _, e := range rn.parser.Flush() {
+					rn.handleEvent(e)
+				}
+			}
+			return
+		}
+	}
+}
+
+func (rn *runner) handleLine(line string) {
+	if rn.err != nil {
+		return
+	}
+	if rn.detect && strings.TrimSpace(line) != "" {
+		rn.detect = false
+		rn.parser = testfmt.Detect(line)
+	}
+	if rn.parser == nil {
+		rn.check(rn.logs.Log(line, false))
+		return
+	}
+	for _, e := range rn.parser.Parse(line) {
+		rn.handleEvent(e)
+	}
+}
+
+func (rn *runner) handleEvent(e testfmt.Event) {
+	if e.Test == "" {
+		if e.Kind == testfmt.Output {
+			rn.check(rn.logs.Log(e.Line, false))
+		}
+		return
+	}
+	c := rn.caseFor(e)
+	if c == nil {
+		return
+	}
+	switch e.Kind {
+	case testfmt.Output:
+		if c.finished {
+			// a finished case does not accept logs
+			rn.check(rn.logs.Log(e.Line, false))
+		} else {
+			rn.check(c.logs.Log(e.Line, false))
+		}
+	case testfmt.End:
+		c.finished = true
+		if e.Result == client.CaseResultFailed ||
+			e.Result == client.CaseResultErrored {
+			rn.failed = append(rn.failed, e.Test)
+			if rn.quarantined[e.Test] {
+				log.Printf("Quarantined test %s failed", e.Test)
+			}
+		}
+		if rn.check(c.logs.Flush(rn.ctx)) {
+			rn.check(rn.rep.FinishCase(rn.ctx, c.id, e.Result,
+				client.MsTime(e.Time)))
+		}
+	}
+}
+
+func (rn *runner) caseFor(e testfmt.Event) *runCase {
+	k := e.Package + "\x00" + e.Test
+	if c, ok := rn.cases[k]; ok {
+		return c
+	}
+	at := client.MsTime(e.Time)
+	cs := client.Case{
+		SuiteId:   &rn.suiteId,
+		Name:      client.String(e.Test),
+		Idx:       client.Int64(rn.nextIdx),
+		Status:    caseStatusPtr(client.CaseStatusStarted),
+		CreatedAt: &at,
+		StartedAt: &at,
+	}
+	if e.Package != "" {
+		cs.Description = client.String(e.Package)
+	}
+	rn.nextIdx++
+	id, err := rn.rep.InsertCase(rn.ctx, cs)
+	if !rn.check(err) {
+		return nil
+	}
+	c := runCase{
+		id:   id,
+		logs: rn.rep.NewLogBatcher(id, client.BatchOptions{}),
+	}
+	rn.cases[k] = &c
+	return &c
+}
+
+// check logs the first reporting error, so that the command's output is not
+// flooded when the server is unreachable. It returns whether err is nil.
+func (rn *runner) check(err error) bool {
+	if err == nil {
+		return true
+	}
+	if rn.err == nil {
+		rn.err = err
+		log.Printf("report: %v", err)
+	}
+	return false
+}
+
+func now() *client.MsTime {
+	t := client.MsTime(time.Now())
+	return &t
+}
+
+func statusPtr(s client.SuiteStatus) *client.SuiteStatus {
+	return &s
+}
+
+func caseStatusPtr(s client.CaseStatus) *client.CaseStatus {
+	return &s
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/suiteserve/suiteserve/internal/config"
+	"github.com/suiteserve/suiteserve/internal/repo"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+func tokenCmd(cfg *config.Config, args []string) {
+	usage := func() {
+		fmt.Fprintf(flag.CommandLine.Output(), `Usage:
+  %[1]s token create -scope ingest -project <project> [-name name]
+  %[1]s token create -scope read [-project project] [-name name]
+  %[1]s token list
+  %[1]s token revoke <token id>
+`, os.Args[0])
+	}
+	if len(args) == 0 {
+		usage()
+		os.Exit(2)
+	}
+	ctx := context.Background()
+	switch args[0] {
+	case "create":
+		fs := flag.NewFlagSet("token create", flag.ExitOnError)
+		scope := fs.String("scope", "",
+			"The scope of the token: ingest, to report suites of a project, "+
+				"or read")
+		project := fs.String("project", "",
+			"The project the token is for, which read tokens may omit to "+
+				"read every project")
+		name := fs.String("name", "", "A name to recognize the token by")
+		_ = fs.Parse(args[1:])
+		t := repo.Token{
+			Scope: (*repo.TokenScope)(scope),
+		}
+		switch repo.TokenScope(*scope) {
+		case repo.TokenScopeIngest:
+			if *project == "" {
+				log.Fatal("an ingest token needs a project")
+			}
+			t.Project = project
+		case repo.TokenScopeRead:
+			if *project != "" {
+				t.Project = project
+			}
+		default:
+			fs.Usage()
+			os.Exit(2)
+		}
+		if *name != "" {
+			t.Name = name
+		}
+		r := openRepo(cfg)
+		defer r.Close()
+		id, secret, err := r.InsertToken(ctx, t)
+		if err != nil {
+			log.Fatalf("create token: %v", err)
+		}
+		log.Printf("Created token %s; its secret will not be shown again", id)
+		fmt.Println(secret)
+	case "list":
+		r := openRepo(cfg)
+		defer r.Close()
+		ts, err := r.Tokens(ctx)
+		if err != nil {
+			log.Fatalf("list tokens: %v", err)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tNAME\tSCOPE\tPROJECT\tCREATED\tREVOKED")
+		for _, t := range ts {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", t.Id, strOr(t.Name),
+				strOr((*string)(t.Scope)), strOr(t.Project),
+				timeOr(t.CreatedAt), timeOr(t.RevokedAt))
+		}
+		if err := w.Flush(); err != nil {
+			log.Fatalf("list tokens: %v", err)
+		}
+	case "revoke":
+		if len(args) != 2 {
+			usage()
+			os.Exit(2)
+		}
+		id, err := repo.NewId(args[1])
+		if err != nil {
+			log.Fatalf("parse token id: %v", err)
+		}
+		r := openRepo(cfg)
+		defer r.Close()
+		if err := r.RevokeToken(ctx, id, repo.MsTime(time.Now())); err != nil {
+			log.Fatalf("revoke token: %v", err)
+		}
+		log.Printf("Revoked token %s", id)
+	default:
+		usage()
+		os.Exit(2)
+	}
+}
+
+func strOr(s *string) string {
+	if s == nil {
+		return "-"
+	}
+	return *s
+}
+
+func timeOr(t *repo.MsTime) string {
+	if t == nil {
+		return "-"
+	}
+	return time.Time(*t).Format(time.RFC3339)
+}
@@ -0,0 +1,164 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/suiteserve/suiteserve/internal/repo"
+	"net/http"
+	"time"
+)
+
+// audit records that the request changed the document with the given id in
+// coll from before to after, either of which may be nil. As the change is
+// already made, an entry that can't be recorded is logged instead of failing
+// the request.
+func (v *v1) audit(r *http.Request, action string, coll repo.Coll, id repo.Id,
+	project *string, before, after interface{}) {
+	ctx := r.Context()
+	now := repo.MsTime(time.Now())
+	e := repo.AuditEntry{
+		Action:     &action,
+		Coll:       &coll,
+		TargetId:   &id,
+		Project:    project,
+		Actor:      actor(ctx),
+		RemoteAddr: &r.RemoteAddr,
+		Diff:       diff(before, after),
+		At:         &now,
+	}
+	if t, ok := tokenFrom(ctx); ok {
+		e.TokenId = t.Id
+	}
+	if _, err := v.repo.InsertAuditEntry(ctx, e); err != nil {
+		auditFailed(r, action, coll, id, err)
+	}
+}
+
+// auditFailed logs that the change of the document with the given id in coll
+// couldn't be recorded.
+func auditFailed(r *http.Request, action string, coll repo.Coll, id repo.Id,
+	err error) {
+	printLog(r, fmt.Errorf("audit %s of %s %s: %v", action, coll, id, err))
+}
+
+// auditSuite calls fn, which changes the suite with the given id, and records
+// the change.
+func (v *v1) auditSuite(r *http.Request, action string, id repo.Id,
+	fn func() error) error {
+	before, err := v.repo.Suite(r.Context(), id)
+	if err != nil {
+		return err
+	}
+	if err := fn(); err != nil {
+		return err
+	}
+	after, err := v.repo.Suite(r.Context(), id)
+	if err != nil {
+		auditFailed(r, action, repo.Suites, id, err)
+		return nil
+	}
+	v.audit(r, action, repo.Suites, id, before.Project, before, after)
+	return nil
+}
+
+// auditCase calls fn, which changes the case with the given id, and records
+// the change.
+func (v *v1) auditCase(r *http.Request, action string, id repo.Id,
+	fn func() error) error {
+	before, err := v.repo.Case(r.Context(), id)
+	if err != nil {
+		return err
+	}
+	project, err := v.newProjects().ofOwner(r.Context(), before.SuiteId, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(); err != nil {
+		return err
+	}
+	after, err := v.repo.Case(r.Context(), id)
+	if err != nil {
+		auditFailed(r, action, repo.Cases, id, err)
+		return nil
+	}
+	v.audit(r, action, repo.Cases, id, project, before, after)
+	return nil
+}
+
+// actor names who made a request, if it was authenticated.
+func actor(ctx context.Context) *string {
+	if sess, ok := ctx.Value(sessionKey{}).(repo.Session); ok {
+		if sess.Email != nil {
+			return sess.Email
+		}
+		return sess.Subject
+	}
+	if t, ok := tokenFrom(ctx); ok {
+		name := "token " + t.Id.String()
+		if t.Name != nil {
+			name = "token " + *t.Name
+		}
+		return &name
+	}
+	return nil
+}
+
+// diff returns the JSON fields that differ between before and after.
+func diff(before, after interface{}) map[string]repo.AuditChange {
+	b, a := jsonFields(before), jsonFields(after)
+	d := map[string]repo.AuditChange{}
+	for k, bv := range b {
+		if av, ok := a[k]; !ok || !bytes.Equal(av, bv) {
+			d[k] = repo.AuditChange{Before: bv, After: av}
+		}
+	}
+	for k, av := range a {
+		if _, ok := b[k]; !ok {
+			d[k] = repo.AuditChange{After: av}
+		}
+	}
+	return d
+}
+
+func jsonFields(v interface{}) map[string]json.RawMessage {
+	fields := map[string]json.RawMessage{}
+	if v == nil {
+		return fields
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	if err := json.Unmarshal(b, &fields); err != nil {
+		panic(err)
+	}
+	return fields
+}
+
+func (v *v1) auditPageHandler() errHandlerFunc {
+	return findHandler(func(r *http.Request) (interface{}, error) {
+		var f repo.AuditFilter
+		if acc, ok := accessFrom(r.Context()); ok {
+			f.Projects = acc.projects(repo.RoleAdmin)
+		}
+		q := r.URL.Query()
+		if s := q.Get("target"); s != "" {
+			id, err := repo.NewId(s)
+			if err != nil {
+				return nil, errHttp{code: http.StatusBadRequest, cause: err}
+			}
+			f.TargetId = &id
+		}
+		var from *repo.Id
+		if s := q.Get("from"); s != "" {
+			id, err := repo.NewId(s)
+			if err != nil {
+				return nil, errHttp{code: http.StatusBadRequest, cause: err}
+			}
+			from = &id
+		}
+		return v.repo.AuditPage(r.Context(), f, from)
+	})
+}
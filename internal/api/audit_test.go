@@ -0,0 +1,106 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/suiteserve/suiteserve/internal/repo"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	type entity struct {
+		Name   *string           `json:"name,omitempty"`
+		Tags   []string          `json:"tags,omitempty"`
+		Labels map[string]string `json:"labels,omitempty"`
+	}
+	raw := func(s string) json.RawMessage {
+		return json.RawMessage(s)
+	}
+	tests := []struct {
+		name          string
+		before, after interface{}
+		want          map[string]repo.AuditChange
+	}{
+		{
+			name:   "unchanged",
+			before: entity{Name: str("a"), Tags: []string{"x"}},
+			after:  entity{Name: str("a"), Tags: []string{"x"}},
+			want:   map[string]repo.AuditChange{},
+		},
+		{
+			name:   "added",
+			before: entity{Name: str("a")},
+			after:  entity{Name: str("a"), Tags: []string{"x"}},
+			want: map[string]repo.AuditChange{
+				"tags": {After: raw(`["x"]`)},
+			},
+		},
+		{
+			name:   "removed",
+			before: entity{Name: str("a"), Tags: []string{"x"}},
+			after:  entity{Tags: []string{"x"}},
+			want: map[string]repo.AuditChange{
+				"name": {Before: raw(`"a"`)},
+			},
+		},
+		{
+			name: "changed",
+			before: entity{
+				Name:   str("a"),
+				Labels: map[string]string{"k": "1", "l": "2"},
+			},
+			after: entity{
+				Name:   str("b"),
+				Labels: map[string]string{"l": "2", "k": "1"},
+			},
+			want: map[string]repo.AuditChange{
+				"name": {Before: raw(`"a"`), After: raw(`"b"`)},
+			},
+		},
+		{
+			name:   "changed nested",
+			before: entity{Labels: map[string]string{"k": "1"}},
+			after:  entity{Labels: map[string]string{"k": "2"}},
+			want: map[string]repo.AuditChange{
+				"labels": {Before: raw(`{"k":"1"}`), After: raw(`{"k":"2"}`)},
+			},
+		},
+		{
+			name:   "inserted",
+			before: nil,
+			after:  entity{Name: str("a")},
+			want: map[string]repo.AuditChange{
+				"name": {After: raw(`"a"`)},
+			},
+		},
+		{
+			name:   "deleted",
+			before: &entity{Name: str("a")},
+			after:  nil,
+			want: map[string]repo.AuditChange{
+				"name": {Before: raw(`"a"`)},
+			},
+		},
+		{
+			name:   "nothing",
+			before: nil,
+			after:  nil,
+			want:   map[string]repo.AuditChange{},
+		},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.want, diff(test.before, test.after), test.name)
+	}
+}
+
+func TestJsonFields(t *testing.T) {
+	s := repo.Suite{
+		Project: str("api"),
+		Tags:    []string{"x"},
+	}
+	assert.Equal(t, map[string]json.RawMessage{
+		"project": json.RawMessage(`"api"`),
+		"tags":    json.RawMessage(`["x"]`),
+	}, jsonFields(s))
+	assert.Equal(t, map[string]json.RawMessage{}, jsonFields(nil))
+}
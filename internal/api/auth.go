@@ -0,0 +1,400 @@
+package api
+
+import (
+	"context"
+	"github.com/suiteserve/suiteserve/internal/repo"
+	"github.com/suiteserve/suiteserve/internal/validate"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+type AuthRepo interface {
+	TokenBySecret(ctx context.Context, secret string) (repo.Token, error)
+	RoleBindingsOf(ctx context.Context, users, groups []string) ([]repo.RoleBinding, error)
+}
+
+type (
+	tokenKey   struct{}
+	sessionKey struct{}
+	accessKey  struct{}
+)
+
+type auth struct {
+	repo AuthRepo
+	sso  *Sso
+	// anonymous grants full access to requests without a token or session,
+	// unless single sign-on is enabled.
+	anonymous bool
+}
+
+// ingestReads are the paths that ingest tokens may make safe requests to, for
+// runners to read what they need about their project.
+var ingestReads = map[string]bool{
+	"/quarantines": true,
+}
+
+// mw authenticates requests with a bearer token or a session cookie, and
+// stores the access they are granted for handlers to authorize against. Read
+// tokens may only make safe requests, while ingest tokens may only make unsafe
+// ones and those in ingestReads. Requests without either are rejected unless anonymous is set and
+// single sign-on isn't enabled.
+func (a auth) mw(h http.Handler) errHandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		header := r.Header.Get("authorization")
+		if header == "" && a.sso != nil {
+			sess, ok, err := a.sso.session(r)
+			if err != nil {
+				return err
+			}
+			if ok {
+				if !sameOrigin(r) {
+					return errHttp{code: http.StatusForbidden}
+				}
+				acc, err := a.sessionAccess(r.Context(), sess)
+				if err != nil {
+					return err
+				}
+				ctx := context.WithValue(r.Context(), sessionKey{}, sess)
+				ctx = context.WithValue(ctx, accessKey{}, acc)
+				h.ServeHTTP(w, r.WithContext(ctx))
+				return nil
+			}
+		}
+		if header == "" && a.anonymous && a.sso == nil {
+			acc := access{repo.AllProjects: repo.RoleAdmin}
+			ctx := context.WithValue(r.Context(), accessKey{}, acc)
+			h.ServeHTTP(w, r.WithContext(ctx))
+			return nil
+		}
+		w.Header().Set("www-authenticate", `Bearer realm="suiteserve"`)
+		const prefix = "bearer "
+		if len(header) < len(prefix) ||
+			!strings.EqualFold(header[:len(prefix)], prefix) {
+			return errHttp{code: http.StatusUnauthorized}
+		}
+		t, err := a.repo.TokenBySecret(r.Context(), header[len(prefix):])
+		if isNotFound(err) {
+			return errHttp{code: http.StatusUnauthorized}
+		} else if err != nil {
+			return err
+		}
+		w.Header().Del("www-authenticate")
+		safe := r.Method == http.MethodGet || r.Method == http.MethodHead
+		if t.Scope == nil ||
+			*t.Scope == repo.TokenScopeRead && !safe ||
+			*t.Scope == repo.TokenScopeIngest && safe &&
+				!ingestReads[r.URL.Path] {
+			return errHttp{code: http.StatusForbidden}
+		}
+		ctx := context.WithValue(r.Context(), tokenKey{}, t)
+		ctx = context.WithValue(ctx, accessKey{}, tokenAccess(t))
+		h.ServeHTTP(w, r.WithContext(ctx))
+		return nil
+	}
+}
+
+func (a auth) sessionAccess(ctx context.Context,
+	sess repo.Session) (access, error) {
+	var users []string
+	for _, u := range []*string{sess.Subject, sess.Email} {
+		if u != nil {
+			users = append(users, *u)
+		}
+	}
+	bs, err := a.repo.RoleBindingsOf(ctx, users, sess.Groups)
+	if err != nil {
+		return nil, err
+	}
+	acc := access{}
+	for _, b := range bs {
+		if b.Project != nil && b.Role != nil {
+			acc.grant(*b.Project, *b.Role)
+		}
+	}
+	return acc, nil
+}
+
+// uiMw sends users without a session to log in, if single sign-on is
+// enabled.
+func (a auth) uiMw(h http.Handler) errHandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		if a.sso == nil {
+			h.ServeHTTP(w, r)
+			return nil
+		}
+		_, ok, err := a.sso.session(r)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			http.Redirect(w, r, "/auth/login?next="+
+				url.QueryEscape(r.URL.RequestURI()), http.StatusFound)
+			return nil
+		}
+		h.ServeHTTP(w, r)
+		return nil
+	}
+}
+
+// sameOrigin reports whether a request authenticated by a cookie may have
+// come from another site. Browsers send Origin with every cross-origin request
+// that is not safe.
+func sameOrigin(r *http.Request) bool {
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return true
+	}
+	origin := r.Header.Get("origin")
+	return origin == "" || origin == "https://"+r.Host
+}
+
+// access maps projects, or repo.AllProjects, to the role granted for them.
+type access map[string]repo.Role
+
+func tokenAccess(t repo.Token) access {
+	role := repo.RoleViewer
+	if *t.Scope == repo.TokenScopeIngest {
+		role = repo.RoleReporter
+	}
+	project := repo.AllProjects
+	if t.Project != nil {
+		project = *t.Project
+	}
+	return access{project: role}
+}
+
+func (a access) grant(project string, role repo.Role) {
+	if !a[project].Includes(role) {
+		a[project] = role
+	}
+}
+
+func (a access) allows(project *string, need repo.Role) bool {
+	if a[repo.AllProjects].Includes(need) {
+		return true
+	}
+	return project != nil && a[*project].Includes(need)
+}
+
+// projects returns the projects granted at least need, or nil for all.
+func (a access) projects(need repo.Role) []string {
+	if a[repo.AllProjects].Includes(need) {
+		return nil
+	}
+	ps := []string{}
+	for p, r := range a {
+		if r.Includes(need) {
+			ps = append(ps, p)
+		}
+	}
+	return ps
+}
+
+func tokenFrom(ctx context.Context) (repo.Token, bool) {
+	t, ok := ctx.Value(tokenKey{}).(repo.Token)
+	return t, ok
+}
+
+func accessFrom(ctx context.Context) (access, bool) {
+	a, ok := ctx.Value(accessKey{}).(access)
+	return a, ok
+}
+
+// authorize returns an error unless the request is granted need for project.
+// A request without access is granted nothing.
+func authorize(ctx context.Context, project *string, need repo.Role) error {
+	if a, _ := accessFrom(ctx); !a.allows(project, need) {
+		return errHttp{
+			error: "no " + string(need) + " role for this project",
+			code:  http.StatusForbidden,
+		}
+	}
+	return nil
+}
+
+// suiteFilter restricts suites to the projects the request may view, and
+// selects deleted suites if the request asks for them. The environment fields
+// and labels (label=key:value) in the query restrict them further.
+func suiteFilter(r *http.Request) (repo.SuiteFilter, error) {
+	q := r.URL.Query()
+	f := repo.SuiteFilter{
+		Deleted: q.Get("deleted") == "true",
+		Env: repo.SuiteEnv{
+			Commit:    stringQuery(q, "commit"),
+			Branch:    stringQuery(q, "branch"),
+			Ci:        stringQuery(q, "ci"),
+			BuildUrl:  stringQuery(q, "buildUrl"),
+			Host:      stringQuery(q, "host"),
+			Os:        stringQuery(q, "os"),
+			Arch:      stringQuery(q, "arch"),
+			GoVersion: stringQuery(q, "goVersion"),
+		},
+	}
+	if s := q.Get("pr"); s != "" {
+		pr, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || pr <= 0 {
+			return repo.SuiteFilter{}, errHttp{
+				error: "pr must be a positive integer",
+				code:  http.StatusBadRequest,
+				field: "pr",
+			}
+		}
+		f.Env.Pr = &pr
+	}
+	for _, l := range q["label"] {
+		i := strings.IndexByte(l, ':')
+		if i <= 0 {
+			return repo.SuiteFilter{}, errHttp{
+				error: "label must be key:value",
+				code:  http.StatusBadRequest,
+				field: "label",
+			}
+		}
+		if f.Labels == nil {
+			f.Labels = map[string]string{}
+		}
+		f.Labels[l[:i]] = l[i+1:]
+	}
+	if a, ok := accessFrom(r.Context()); ok {
+		f.Projects = a.projects(repo.RoleViewer)
+	}
+	return f, nil
+}
+
+func stringQuery(q url.Values, name string) *string {
+	if s := q.Get(name); s != "" {
+		return &s
+	}
+	return nil
+}
+
+// projectsQuery returns the project query parameter if the request may view
+// it, or else the projects the request may view, which are nil for all.
+func projectsQuery(r *http.Request) ([]string, error) {
+	if project := r.URL.Query().Get("project"); project != "" {
+		if err := authorize(r.Context(), &project, repo.RoleViewer); err != nil {
+			return nil, err
+		}
+		return []string{project}, nil
+	}
+	if a, ok := accessFrom(r.Context()); ok {
+		return a.projects(repo.RoleViewer), nil
+	}
+	return nil, nil
+}
+
+func (v *v1) authorizeSuite(ctx context.Context, id repo.Id,
+	need repo.Role) error {
+	return v.authorizeOwner(ctx, &id, nil, need)
+}
+
+func (v *v1) authorizeCase(ctx context.Context, id repo.Id,
+	need repo.Role) error {
+	return v.authorizeOwner(ctx, nil, &id, need)
+}
+
+// authorizeOwner authorizes a request for something that belongs to either a
+// suite or a case.
+func (v *v1) authorizeOwner(ctx context.Context, suiteId, caseId *repo.Id,
+	need repo.Role) error {
+	if a, _ := accessFrom(ctx); a.allows(nil, need) {
+		// granted for every project
+		return nil
+	}
+	project, err := v.newProjects().ofOwner(ctx, suiteId, caseId)
+	if err != nil {
+		return err
+	}
+	return authorize(ctx, project, need)
+}
+
+// projects resolves the projects that suites and cases belong to, caching
+// them for the rest of a request.
+type projects struct {
+	repo   Repo
+	suites map[repo.Id]*string
+	cases  map[repo.Id]repo.Id
+}
+
+func (v *v1) newProjects() *projects {
+	return &projects{
+		repo:   v.repo,
+		suites: map[repo.Id]*string{},
+		cases:  map[repo.Id]repo.Id{},
+	}
+}
+
+func (p *projects) ofSuite(ctx context.Context, id repo.Id) (*string, error) {
+	if project, ok := p.suites[id]; ok {
+		return project, nil
+	}
+	s, err := p.repo.Suite(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	p.suites[id] = s.Project
+	return s.Project, nil
+}
+
+func (p *projects) ofCase(ctx context.Context, id repo.Id) (*string, error) {
+	suiteId, ok := p.cases[id]
+	if !ok {
+		c, err := p.repo.Case(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if c.SuiteId == nil {
+			return nil, nil
+		}
+		suiteId = *c.SuiteId
+		p.cases[id] = suiteId
+	}
+	return p.ofSuite(ctx, suiteId)
+}
+
+func (p *projects) ofOwner(ctx context.Context, suiteId,
+	caseId *repo.Id) (*string, error) {
+	if suiteId != nil {
+		return p.ofSuite(ctx, *suiteId)
+	}
+	if caseId != nil {
+		return p.ofCase(ctx, *caseId)
+	}
+	return nil, nil
+}
+
+// ofChange returns the project of the document a change is for. The project
+// of a deleted suite is only known if an earlier change was for it.
+func (p *projects) ofChange(ctx context.Context,
+	c repo.Change) (*string, error) {
+	if c.Coll != repo.Suites {
+		return p.ofOwner(ctx, c.SuiteId, c.CaseId)
+	}
+	if c.Delete {
+		project := p.suites[c.Id]
+		delete(p.suites, c.Id)
+		return project, nil
+	}
+	p.suites[c.Id] = c.Project
+	return c.Project, nil
+}
+
+// authorizedRepo checks and authorizes the project of inserted suites, so that
+// imported archives are subject to the same rules as reported suites.
+type authorizedRepo struct {
+	Repo
+}
+
+func (r authorizedRepo) InsertSuite(ctx context.Context,
+	s repo.Suite) (repo.Id, error) {
+	errs := validate.Errors{}
+	validate.Fields(errs, "", s, []string{"project"})
+	if err := errs.Err(); err != nil {
+		return repo.Id{}, err
+	}
+	if err := authorize(ctx, s.Project, repo.RoleReporter); err != nil {
+		return repo.Id{}, err
+	}
+	return r.Repo.InsertSuite(ctx, s)
+}
@@ -0,0 +1,508 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/suiteserve/suiteserve/internal/repo"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type errNotFound struct{}
+
+func (errNotFound) Error() string {
+	return "not found"
+}
+
+func (errNotFound) NotFound() {}
+
+type fakeAuthRepo struct {
+	tokens   map[string]repo.Token
+	bindings []repo.RoleBinding
+}
+
+func (r fakeAuthRepo) TokenBySecret(_ context.Context,
+	secret string) (repo.Token, error) {
+	t, ok := r.tokens[secret]
+	if !ok {
+		return t, errNotFound{}
+	}
+	return t, nil
+}
+
+func (r fakeAuthRepo) RoleBindingsOf(_ context.Context, users,
+	groups []string) ([]repo.RoleBinding, error) {
+	var bs []repo.RoleBinding
+	for _, b := range r.bindings {
+		for _, u := range users {
+			if b.User != nil && *b.User == u {
+				bs = append(bs, b)
+			}
+		}
+		for _, g := range groups {
+			if b.Group != nil && *b.Group == g {
+				bs = append(bs, b)
+			}
+		}
+	}
+	return bs, nil
+}
+
+type fakeSessionRepo map[string]repo.Session
+
+func (r fakeSessionRepo) InsertSession(context.Context,
+	repo.Session) (repo.Id, string, error) {
+	return repo.Id{}, "", errors.New("not implemented")
+}
+
+func (r fakeSessionRepo) SessionBySecret(_ context.Context,
+	secret string) (repo.Session, error) {
+	s, ok := r[secret]
+	if !ok {
+		return s, errNotFound{}
+	}
+	return s, nil
+}
+
+func (r fakeSessionRepo) DeleteSession(context.Context, string) error {
+	return nil
+}
+
+// fakeRepo serves suites and cases from maps. Its other methods panic.
+type fakeRepo struct {
+	Repo
+	suites map[repo.Id]repo.Suite
+	cases  map[repo.Id]repo.Case
+	// lookups is the number of suites and cases looked up.
+	lookups int
+	audits  []repo.AuditEntry
+}
+
+func (r *fakeRepo) Suite(_ context.Context, id repo.Id) (repo.Suite, error) {
+	r.lookups++
+	s, ok := r.suites[id]
+	if !ok {
+		return s, errNotFound{}
+	}
+	return s, nil
+}
+
+func (r *fakeRepo) Case(_ context.Context, id repo.Id) (repo.Case, error) {
+	r.lookups++
+	c, ok := r.cases[id]
+	if !ok {
+		return c, errNotFound{}
+	}
+	return c, nil
+}
+
+func (r *fakeRepo) InsertAuditEntry(_ context.Context,
+	e repo.AuditEntry) (repo.Id, error) {
+	r.audits = append(r.audits, e)
+	return newId(), nil
+}
+
+func newId() repo.Id {
+	return repo.Id(primitive.NewObjectID())
+}
+
+func str(s string) *string {
+	return &s
+}
+
+func scope(s repo.TokenScope) *repo.TokenScope {
+	return &s
+}
+
+func role(r repo.Role) *repo.Role {
+	return &r
+}
+
+// serveMw serves r with a.mw, returning the response and the access the
+// request was granted, if it reached the handler.
+func serveMw(a auth, r *http.Request) (*httptest.ResponseRecorder, access,
+	bool) {
+	var acc access
+	var ok bool
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		acc, ok = accessFrom(r.Context())
+		w.WriteHeader(http.StatusNoContent)
+	})
+	w := httptest.NewRecorder()
+	a.mw(h).ServeHTTP(w, r)
+	return w, acc, ok
+}
+
+func TestAuth_Tokens(t *testing.T) {
+	a := auth{repo: fakeAuthRepo{tokens: map[string]repo.Token{
+		"read":      {Scope: scope(repo.TokenScopeRead)},
+		"readApi":   {Scope: scope(repo.TokenScopeRead), Project: str("api")},
+		"ingest":    {Scope: scope(repo.TokenScopeIngest)},
+		"ingestApi": {Scope: scope(repo.TokenScopeIngest), Project: str("api")},
+		"unscoped":  {},
+	}}}
+	tests := []struct {
+		token   string
+		method  string
+		path    string
+		code    int
+		project string
+		role    repo.Role
+	}{
+		{"read", http.MethodGet, "/suites", http.StatusNoContent,
+			repo.AllProjects, repo.RoleViewer},
+		{"read", http.MethodHead, "/suites", http.StatusNoContent,
+			repo.AllProjects, repo.RoleViewer},
+		{"read", http.MethodPost, "/suites", http.StatusForbidden, "", ""},
+		{"read", http.MethodPatch, "/quarantines", http.StatusForbidden, "", ""},
+		{"readApi", http.MethodGet, "/suites", http.StatusNoContent,
+			"api", repo.RoleViewer},
+		{"readApi", http.MethodDelete, "/suites", http.StatusForbidden, "", ""},
+		{"ingest", http.MethodPost, "/suites", http.StatusNoContent,
+			repo.AllProjects, repo.RoleReporter},
+		{"ingest", http.MethodGet, "/suites", http.StatusForbidden, "", ""},
+		{"ingestApi", http.MethodPost, "/cases", http.StatusNoContent,
+			"api", repo.RoleReporter},
+		{"ingestApi", http.MethodPatch, "/cases", http.StatusNoContent,
+			"api", repo.RoleReporter},
+		{"ingestApi", http.MethodGet, "/quarantines", http.StatusNoContent,
+			"api", repo.RoleReporter},
+		{"ingestApi", http.MethodGet, "/quarantines/x", http.StatusForbidden,
+			"", ""},
+		{"ingestApi", http.MethodGet, "/suites", http.StatusForbidden, "", ""},
+		{"unscoped", http.MethodGet, "/suites", http.StatusForbidden, "", ""},
+		{"unknown", http.MethodGet, "/suites", http.StatusUnauthorized, "", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.token+" "+test.method+" "+test.path, func(t *testing.T) {
+			r := httptest.NewRequest(test.method, test.path, nil)
+			r.Header.Set("authorization", "Bearer "+test.token)
+			w, acc, ok := serveMw(a, r)
+			require.Equal(t, test.code, w.Code)
+			if test.code != http.StatusNoContent {
+				assert.False(t, ok)
+				return
+			}
+			require.True(t, ok)
+			assert.Equal(t, access{test.project: test.role}, acc)
+		})
+	}
+}
+
+func TestAuth_BadHeader(t *testing.T) {
+	a := auth{repo: fakeAuthRepo{}}
+	for _, header := range []string{"Basic abc", "bearer", "Bearer nope"} {
+		r := httptest.NewRequest(http.MethodGet, "/suites", nil)
+		r.Header.Set("authorization", header)
+		w, _, ok := serveMw(a, r)
+		assert.Equal(t, http.StatusUnauthorized, w.Code, header)
+		assert.Equal(t, `Bearer realm="suiteserve"`,
+			w.Header().Get("www-authenticate"), header)
+		assert.False(t, ok, header)
+	}
+}
+
+func TestAuth_Order(t *testing.T) {
+	authRepo := fakeAuthRepo{
+		tokens: map[string]repo.Token{
+			"read": {Scope: scope(repo.TokenScopeRead), Project: str("web")},
+		},
+		bindings: []repo.RoleBinding{
+			{User: str("alice"), Project: str("api"), Role: role(repo.RoleAdmin)},
+			{Group: str("qa"), Project: str("web"), Role: role(repo.RoleViewer)},
+			{Group: str("qa"), Project: str("api"), Role: role(repo.RoleViewer)},
+		},
+	}
+	sso := &Sso{Repo: fakeSessionRepo{
+		"secret": {Subject: str("alice"), Groups: []string{"qa"}},
+	}}
+	tests := []struct {
+		name   string
+		auth   auth
+		cookie string
+		header string
+		code   int
+		acc    access
+	}{
+		{
+			name:   "session",
+			auth:   auth{authRepo, sso, false},
+			cookie: "secret",
+			code:   http.StatusNoContent,
+			acc:    access{"api": repo.RoleAdmin, "web": repo.RoleViewer},
+		},
+		{
+			name:   "bearer over session",
+			auth:   auth{authRepo, sso, false},
+			cookie: "secret",
+			header: "Bearer read",
+			code:   http.StatusNoContent,
+			acc:    access{"web": repo.RoleViewer},
+		},
+		{
+			name:   "expired session",
+			auth:   auth{authRepo, sso, false},
+			cookie: "expired",
+			code:   http.StatusUnauthorized,
+		},
+		{
+			name: "sso disables anonymous",
+			auth: auth{authRepo, sso, true},
+			code: http.StatusUnauthorized,
+		},
+		{
+			name: "anonymous",
+			auth: auth{authRepo, nil, true},
+			code: http.StatusNoContent,
+			acc:  access{repo.AllProjects: repo.RoleAdmin},
+		},
+		{
+			name:   "bearer over anonymous",
+			auth:   auth{authRepo, nil, true},
+			header: "Bearer nope",
+			code:   http.StatusUnauthorized,
+		},
+		{
+			name:   "session cookie without sso",
+			auth:   auth{authRepo, nil, false},
+			cookie: "secret",
+			code:   http.StatusUnauthorized,
+		},
+		{
+			name: "nothing",
+			auth: auth{authRepo, nil, false},
+			code: http.StatusUnauthorized,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/suites", nil)
+			if test.cookie != "" {
+				r.AddCookie(&http.Cookie{
+					Name:  sessionCookie,
+					Value: test.cookie,
+				})
+			}
+			if test.header != "" {
+				r.Header.Set("authorization", test.header)
+			}
+			w, acc, ok := serveMw(test.auth, r)
+			require.Equal(t, test.code, w.Code)
+			assert.Equal(t, test.acc != nil, ok)
+			assert.Equal(t, test.acc, acc)
+		})
+	}
+}
+
+func TestAuth_SessionOrigin(t *testing.T) {
+	a := auth{fakeAuthRepo{}, &Sso{Repo: fakeSessionRepo{
+		"secret": {Subject: str("alice")},
+	}}, false}
+	tests := []struct {
+		method string
+		origin string
+		code   int
+	}{
+		{http.MethodGet, "https://evil.example", http.StatusNoContent},
+		{http.MethodPost, "", http.StatusNoContent},
+		{http.MethodPost, "https://example.com", http.StatusNoContent},
+		{http.MethodPost, "https://evil.example", http.StatusForbidden},
+		{http.MethodDelete, "http://example.com", http.StatusForbidden},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(test.method, "https://example.com/suites",
+			nil)
+		r.AddCookie(&http.Cookie{Name: sessionCookie, Value: "secret"})
+		if test.origin != "" {
+			r.Header.Set("origin", test.origin)
+		}
+		w, _, _ := serveMw(a, r)
+		assert.Equal(t, test.code, w.Code, test.method+" "+test.origin)
+	}
+}
+
+func TestTokenAccess(t *testing.T) {
+	tests := []struct {
+		token repo.Token
+		want  access
+	}{
+		{repo.Token{Scope: scope(repo.TokenScopeRead)},
+			access{repo.AllProjects: repo.RoleViewer}},
+		{repo.Token{Scope: scope(repo.TokenScopeRead), Project: str("api")},
+			access{"api": repo.RoleViewer}},
+		{repo.Token{Scope: scope(repo.TokenScopeIngest)},
+			access{repo.AllProjects: repo.RoleReporter}},
+		{repo.Token{Scope: scope(repo.TokenScopeIngest), Project: str("api")},
+			access{"api": repo.RoleReporter}},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.want, tokenAccess(test.token))
+	}
+}
+
+func TestAccess_Allows(t *testing.T) {
+	tests := []struct {
+		acc     access
+		project *string
+		need    repo.Role
+		want    bool
+	}{
+		{nil, str("api"), repo.RoleViewer, false},
+		{access{}, nil, repo.RoleViewer, false},
+		{access{"api": repo.RoleViewer}, str("api"), repo.RoleViewer, true},
+		{access{"api": repo.RoleViewer}, str("api"), repo.RoleReporter, false},
+		{access{"api": repo.RoleAdmin}, str("api"), repo.RoleReporter, true},
+		{access{"api": repo.RoleAdmin}, str("web"), repo.RoleViewer, false},
+		{access{"api": repo.RoleAdmin}, nil, repo.RoleViewer, false},
+		{access{repo.AllProjects: repo.RoleViewer}, str("web"),
+			repo.RoleViewer, true},
+		{access{repo.AllProjects: repo.RoleViewer}, nil, repo.RoleViewer, true},
+		{access{repo.AllProjects: repo.RoleViewer}, str("web"),
+			repo.RoleAdmin, false},
+		{access{repo.AllProjects: repo.RoleViewer, "web": repo.RoleAdmin},
+			str("web"), repo.RoleAdmin, true},
+	}
+	for i, test := range tests {
+		assert.Equal(t, test.want, test.acc.allows(test.project, test.need),
+			"test %d", i)
+	}
+}
+
+func TestAccess_Grant(t *testing.T) {
+	acc := access{}
+	acc.grant("api", repo.RoleAdmin)
+	acc.grant("api", repo.RoleViewer)
+	acc.grant("web", repo.RoleViewer)
+	acc.grant("web", repo.RoleReporter)
+	assert.Equal(t, access{
+		"api": repo.RoleAdmin,
+		"web": repo.RoleReporter,
+	}, acc)
+}
+
+func TestAccess_Projects(t *testing.T) {
+	acc := access{"api": repo.RoleAdmin, "web": repo.RoleViewer}
+	assert.ElementsMatch(t, []string{"api", "web"},
+		acc.projects(repo.RoleViewer))
+	assert.Equal(t, []string{"api"}, acc.projects(repo.RoleReporter))
+	assert.Equal(t, []string{}, access{}.projects(repo.RoleViewer))
+	assert.Nil(t, access{repo.AllProjects: repo.RoleViewer}.
+		projects(repo.RoleViewer))
+}
+
+func TestAuthorize(t *testing.T) {
+	ctx := context.Background()
+	err := authorize(ctx, str("api"), repo.RoleViewer)
+	var herr errHttp
+	require.True(t, errors.As(err, &herr))
+	assert.Equal(t, http.StatusForbidden, herr.code)
+
+	ctx = context.WithValue(ctx, accessKey{},
+		access{"api": repo.RoleReporter})
+	assert.Nil(t, authorize(ctx, str("api"), repo.RoleViewer))
+	assert.Nil(t, authorize(ctx, str("api"), repo.RoleReporter))
+	assert.NotNil(t, authorize(ctx, str("api"), repo.RoleAdmin))
+	assert.NotNil(t, authorize(ctx, str("web"), repo.RoleViewer))
+	assert.NotNil(t, authorize(ctx, nil, repo.RoleViewer))
+}
+
+func TestV1_AuthorizeOwner(t *testing.T) {
+	apiSuite, webSuite, apiCase, orphan := newId(), newId(), newId(), newId()
+	r := fakeRepo{
+		suites: map[repo.Id]repo.Suite{
+			apiSuite: {Project: str("api")},
+			webSuite: {Project: str("web")},
+		},
+		cases: map[repo.Id]repo.Case{
+			apiCase: {SuiteId: &apiSuite},
+			orphan:  {},
+		},
+	}
+	v := v1{repo: &r}
+	ctx := context.WithValue(context.Background(), accessKey{},
+		access{"api": repo.RoleReporter})
+
+	assert.Nil(t, v.authorizeSuite(ctx, apiSuite, repo.RoleReporter))
+	assert.NotNil(t, v.authorizeSuite(ctx, apiSuite, repo.RoleAdmin))
+	assert.NotNil(t, v.authorizeSuite(ctx, webSuite, repo.RoleViewer))
+	assert.Nil(t, v.authorizeCase(ctx, apiCase, repo.RoleViewer))
+	assert.NotNil(t, v.authorizeCase(ctx, orphan, repo.RoleViewer))
+	assert.True(t, isNotFound(v.authorizeSuite(ctx, newId(),
+		repo.RoleViewer)))
+
+	r.lookups = 0
+	ctx = context.WithValue(context.Background(), accessKey{},
+		access{repo.AllProjects: repo.RoleViewer})
+	assert.Nil(t, v.authorizeSuite(ctx, webSuite, repo.RoleViewer))
+	assert.Nil(t, v.authorizeCase(ctx, newId(), repo.RoleViewer))
+	assert.Equal(t, 0, r.lookups)
+	assert.NotNil(t, v.authorizeSuite(ctx, webSuite, repo.RoleReporter))
+}
+
+func TestV1_FilterChanges(t *testing.T) {
+	apiSuite, webSuite, apiCase, webCase := newId(), newId(), newId(), newId()
+	r := fakeRepo{
+		suites: map[repo.Id]repo.Suite{
+			apiSuite: {Project: str("api")},
+			webSuite: {Project: str("web")},
+		},
+		cases: map[repo.Id]repo.Case{
+			apiCase: {SuiteId: &apiSuite},
+			webCase: {SuiteId: &webSuite},
+		},
+	}
+	v := v1{repo: &r}
+	changes := []repo.Change{
+		{Id: apiSuite, Coll: repo.Suites, Project: str("api")},
+		{Id: webSuite, Coll: repo.Suites, Project: str("web")},
+		{Id: apiCase, Coll: repo.Cases, SuiteId: &apiSuite},
+		{Id: webCase, Coll: repo.Cases, SuiteId: &webSuite},
+		{Id: newId(), Coll: repo.Logs, CaseId: &apiCase},
+		{Id: newId(), Coll: repo.Logs, CaseId: &webCase},
+		{Id: newId(), Coll: repo.Attachments, SuiteId: &webSuite},
+		{Id: newId(), Coll: repo.Cases, SuiteId: idPtr(newId())},
+		{Id: webSuite, Coll: repo.Suites, Delete: true},
+		{Id: apiSuite, Coll: repo.Suites, Delete: true},
+		{Id: newId(), Coll: repo.Suites, Delete: true},
+	}
+	ch := make(chan repo.Change, len(changes))
+	for _, c := range changes {
+		ch <- c
+	}
+	close(ch)
+
+	var got []repo.Change
+	acc := access{"api": repo.RoleViewer}
+	for c := range v.filterChanges(context.Background(), acc, ch) {
+		got = append(got, c)
+	}
+	assert.Equal(t, []repo.Change{
+		changes[0],
+		changes[2],
+		changes[4],
+		changes[9],
+	}, got)
+}
+
+func idPtr(id repo.Id) *repo.Id {
+	return &id
+}
+
+func TestAuthorizedRepo_InsertSuite(t *testing.T) {
+	r := authorizedRepo{&fakeRepo{}}
+	ctx := context.WithValue(context.Background(), accessKey{},
+		access{repo.AllProjects: repo.RoleAdmin})
+	_, err := r.InsertSuite(ctx, repo.Suite{Project: str(repo.AllProjects)})
+	assert.True(t, isBadInput(err))
+
+	ctx = context.WithValue(context.Background(), accessKey{},
+		access{"api": repo.RoleViewer})
+	_, err = r.InsertSuite(ctx, repo.Suite{Project: str("api")})
+	var herr errHttp
+	require.True(t, errors.As(err, &herr))
+	assert.Equal(t, http.StatusForbidden, herr.code)
+}
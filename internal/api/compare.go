@@ -0,0 +1,46 @@
+package api
+
+import (
+	"context"
+	"github.com/suiteserve/suiteserve/internal/compare"
+	"github.com/suiteserve/suiteserve/internal/repo"
+	"net/http"
+)
+
+// compareHandler compares the cases of the head suite in the path with those
+// of the base suite before it.
+func (v *v1) compareHandler() errHandlerFunc {
+	return findHandler(func(r *http.Request) (interface{}, error) {
+		baseId, err := getIdVar(r)
+		if err != nil {
+			return nil, err
+		}
+		headId, err := repo.NewId(getVar(r, "head"))
+		if err != nil {
+			return nil, errHttp{code: http.StatusBadRequest, cause: err}
+		}
+		return v.compareSuites(r.Context(), baseId, headId)
+	})
+}
+
+// compareSuites compares the cases of two suites, which must exist and be
+// viewable.
+func (v *v1) compareSuites(ctx context.Context, baseId,
+	headId repo.Id) (compare.Report, error) {
+	var cases [2][]repo.Case
+	for i, id := range []repo.Id{baseId, headId} {
+		s, err := v.repo.Suite(ctx, id)
+		if err != nil {
+			return compare.Report{}, err
+		}
+		if err := authorize(ctx, s.Project, repo.RoleViewer); err != nil {
+			return compare.Report{}, err
+		}
+		cs, err := v.repo.SuiteCases(ctx, id)
+		if err != nil {
+			return compare.Report{}, err
+		}
+		cases[i] = cs
+	}
+	return compare.Suites(baseId, cases[0], headId, cases[1]), nil
+}
@@ -1,15 +1,22 @@
 package api
 
 import (
+	"encoding/json"
 	"errors"
 	"log"
 	"net/http"
+	"strconv"
 )
 
 type errHttp struct {
 	error string
 	code  int
 	cause error
+	// kind is the machine-readable code of the error, which defaults to one
+	// for the status code.
+	kind string
+	// field is the request field at fault, if any.
+	field string
 }
 
 func (e errHttp) Error() string {
@@ -23,20 +30,126 @@ func (e errHttp) Unwrap() error {
 	return e.cause
 }
 
+// problem is an RFC 7807 problem details object. Errors maps each field at
+// fault to what is wrong with it.
+type problem struct {
+	Type      string              `json:"type"`
+	Title     string              `json:"title"`
+	Status    int                 `json:"status"`
+	Code      string              `json:"code"`
+	Detail    string              `json:"detail,omitempty"`
+	Field     string              `json:"field,omitempty"`
+	Errors    map[string][]string `json:"errors,omitempty"`
+	RequestId string              `json:"requestId,omitempty"`
+}
+
+var statusKinds = map[int]string{
+	http.StatusBadRequest:            "bad_request",
+	http.StatusUnauthorized:          "unauthorized",
+	http.StatusForbidden:             "forbidden",
+	http.StatusNotFound:              "not_found",
+	http.StatusMethodNotAllowed:      "method_not_allowed",
+	http.StatusConflict:              "conflict",
+	http.StatusPreconditionFailed:    "precondition_failed",
+	http.StatusRequestEntityTooLarge: "too_large",
+	http.StatusUnsupportedMediaType:  "unsupported_media_type",
+	http.StatusUnprocessableEntity:   "bad_input",
+	http.StatusInternalServerError:   "internal",
+	http.StatusServiceUnavailable:    "unavailable",
+}
+
+// retryAfter is the number of seconds after which a request that failed with
+// Service Unavailable may be retried.
+const retryAfter = "1"
+
 type errHandlerFunc func(w http.ResponseWriter, r *http.Request) error
 
 func (f errHandlerFunc) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if err := f(w, r); err != nil {
-		herr := errHttp{code: http.StatusInternalServerError, cause: err}
-		if !errors.As(err, &herr) && isNotFound(err) {
-			herr.code = http.StatusNotFound
+		writeErr(w, r, err)
+	}
+}
+
+// writeErr writes err as a problem. Errors that are not an errHttp are mapped
+// to a status code by their marker method, or else are internal errors.
+func writeErr(w http.ResponseWriter, r *http.Request, err error) {
+	herr := errHttp{code: http.StatusInternalServerError, cause: err}
+	if !errors.As(err, &herr) {
+		if isNotFound(err) {
+			herr = errHttp{code: http.StatusNotFound, cause: err}
+		} else if isBadFormat(err) {
+			herr = errHttp{code: http.StatusBadRequest, cause: err,
+				kind: "bad_format"}
+		} else if isBadInput(err) {
+			herr = errHttp{code: http.StatusUnprocessableEntity, cause: err}
+		} else if isConflict(err) {
+			herr = errHttp{code: http.StatusConflict, cause: err}
+		} else if isPreconditionFailed(err) {
+			herr = errHttp{code: http.StatusPreconditionFailed, cause: err}
+		} else if isInProgress(err) {
+			herr = errHttp{code: http.StatusServiceUnavailable, cause: err,
+				kind: "in_progress"}
+		}
+	} else if herr.kind == "" && isBadFormat(herr.cause) {
+		herr.kind = "bad_format"
+	}
+	text := herr.Error()
+	if herr.cause != nil {
+		text += ": " + herr.cause.Error()
+	}
+	log.Printf("<%s> [%s] %d %s", r.RemoteAddr, requestId(r.Context()),
+		herr.code, text)
+
+	p := problem{
+		Type:      "about:blank",
+		Title:     http.StatusText(herr.code),
+		Status:    herr.code,
+		Code:      herr.kind,
+		Field:     herr.field,
+		RequestId: requestId(r.Context()),
+	}
+	if p.Code == "" {
+		p.Code = statusKinds[herr.code]
+	}
+	if p.Code == "" {
+		p.Code = "error"
+	}
+	if herr.code < http.StatusInternalServerError {
+		p.Detail = text
+	}
+	if p.Field == "" {
+		var errField interface {
+			Field() string
+		}
+		if errors.As(err, &errField) {
+			p.Field = errField.Field()
 		}
-		text := herr.Error()
-		if herr.cause != nil {
-			text += ": " + herr.cause.Error()
+	}
+	var errFields interface {
+		FieldErrors() map[string][]string
+	}
+	if errors.As(err, &errFields) {
+		p.Errors = errFields.FieldErrors()
+		if p.Field == "" && len(p.Errors) == 1 {
+			for f := range p.Errors {
+				p.Field = f
+			}
 		}
-		log.Printf("<%s> %d %s", r.RemoteAddr, herr.code, text)
-		http.Error(w, herr.Error(), herr.code)
+	}
+	b, jerr := json.Marshal(p)
+	if jerr != nil {
+		panic(jerr)
+	}
+	b = append(b, '\n')
+	w.Header().Set("content-length", strconv.Itoa(len(b)))
+	w.Header().Set("content-type", "application/problem+json")
+	w.Header().Set("x-content-type-options", "nosniff")
+	if herr.code == http.StatusServiceUnavailable {
+		w.Header().Set("retry-after", retryAfter)
+	}
+	w.WriteHeader(herr.code)
+	if r.Method != http.MethodHead {
+		_, _ = w.Write(b)
 	}
 }
 
@@ -47,6 +160,36 @@ func isNotFound(err error) bool {
 	return errors.As(err, &errNotFound)
 }
 
+func isConflict(err error) bool {
+	var errConflict interface {
+		Conflict()
+	}
+	return errors.As(err, &errConflict)
+}
+
+func isPreconditionFailed(err error) bool {
+	var errPrecondition interface {
+		PreconditionFailed()
+	}
+	return errors.As(err, &errPrecondition)
+}
+
+// isInProgress reports whether err means that the request is still being
+// handled, as another request, and may be retried.
+func isInProgress(err error) bool {
+	var errInProgress interface {
+		InProgress()
+	}
+	return errors.As(err, &errInProgress)
+}
+
+func isBadFormat(err error) bool {
+	var errBadFormat interface {
+		BadFormat()
+	}
+	return errors.As(err, &errBadFormat)
+}
+
 func isBadInput(err error) bool {
 	var errBadInput interface {
 		BadInput()
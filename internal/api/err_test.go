@@ -0,0 +1,220 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/suiteserve/suiteserve/internal/validate"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+type errConflict struct{}
+
+func (errConflict) Error() string {
+	return "taken"
+}
+
+func (errConflict) Conflict() {}
+
+type errPrecondition struct{}
+
+func (errPrecondition) Error() string {
+	return "version mismatch"
+}
+
+func (errPrecondition) PreconditionFailed() {}
+
+type errBadFormat struct{}
+
+func (errBadFormat) Error() string {
+	return "bad id"
+}
+
+func (errBadFormat) BadFormat() {}
+
+type errInProgress struct{}
+
+func (errInProgress) Error() string {
+	return "in progress"
+}
+
+func (errInProgress) InProgress() {}
+
+func TestWriteErr(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want problem
+	}{
+		{
+			name: "not found",
+			err:  fmt.Errorf("suite: %w", errNotFound{}),
+			want: problem{
+				Status: http.StatusNotFound,
+				Code:   "not_found",
+				Detail: "Not Found: suite: not found",
+			},
+		},
+		{
+			name: "bad format",
+			err:  errBadFormat{},
+			want: problem{
+				Status: http.StatusBadRequest,
+				Code:   "bad_format",
+				Detail: "Bad Request: bad id",
+			},
+		},
+		{
+			name: "bad format cause",
+			err: errHttp{
+				error: "bad suite id",
+				code:  http.StatusBadRequest,
+				cause: errBadFormat{},
+				field: "suiteId",
+			},
+			want: problem{
+				Status: http.StatusBadRequest,
+				Code:   "bad_format",
+				Detail: "bad suite id: bad id",
+				Field:  "suiteId",
+			},
+		},
+		{
+			name: "bad input",
+			err:  validate.Errors{"name": {"is required"}},
+			want: problem{
+				Status: http.StatusUnprocessableEntity,
+				Code:   "bad_input",
+				Detail: "Unprocessable Entity: name: is required",
+				Field:  "name",
+				Errors: map[string][]string{"name": {"is required"}},
+			},
+		},
+		{
+			name: "bad inputs",
+			err: validate.Errors{
+				"name":   {"is required"},
+				"status": {"must be one of a, b"},
+			},
+			want: problem{
+				Status: http.StatusUnprocessableEntity,
+				Code:   "bad_input",
+				Detail: "Unprocessable Entity: name: is required; " +
+					"status: must be one of a, b",
+				Errors: map[string][]string{
+					"name":   {"is required"},
+					"status": {"must be one of a, b"},
+				},
+			},
+		},
+		{
+			name: "conflict",
+			err:  errConflict{},
+			want: problem{
+				Status: http.StatusConflict,
+				Code:   "conflict",
+				Detail: "Conflict: taken",
+			},
+		},
+		{
+			name: "precondition failed",
+			err:  errPrecondition{},
+			want: problem{
+				Status: http.StatusPreconditionFailed,
+				Code:   "precondition_failed",
+				Detail: "Precondition Failed: version mismatch",
+			},
+		},
+		{
+			name: "http",
+			err:  errHttp{error: "no suite", code: http.StatusForbidden},
+			want: problem{
+				Status: http.StatusForbidden,
+				Code:   "forbidden",
+				Detail: "no suite",
+			},
+		},
+		{
+			name: "kind",
+			err: errHttp{
+				code: http.StatusConflict,
+				kind: "finished",
+			},
+			want: problem{
+				Status: http.StatusConflict,
+				Code:   "finished",
+				Detail: "Conflict",
+			},
+		},
+		{
+			name: "unknown status",
+			err:  errHttp{code: http.StatusTeapot},
+			want: problem{
+				Status: http.StatusTeapot,
+				Code:   "error",
+				Detail: "I'm a teapot",
+			},
+		},
+		{
+			name: "internal",
+			err:  errors.New("secret"),
+			want: problem{
+				Status: http.StatusInternalServerError,
+				Code:   "internal",
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/suites", nil)
+			r = r.WithContext(context.WithValue(r.Context(), requestIdKey{},
+				"abc"))
+			w := httptest.NewRecorder()
+			writeErr(w, r, test.err)
+
+			require.Equal(t, test.want.Status, w.Code)
+			assert.Equal(t, "application/problem+json",
+				w.Header().Get("content-type"))
+			assert.Equal(t, "nosniff", w.Header().Get("x-content-type-options"))
+			assert.Equal(t, strconv.Itoa(w.Body.Len()),
+				w.Header().Get("content-length"))
+			assert.Empty(t, w.Header().Get("retry-after"))
+			var got problem
+			require.Nil(t, json.Unmarshal(w.Body.Bytes(), &got))
+			test.want.Type = "about:blank"
+			test.want.Title = http.StatusText(test.want.Status)
+			test.want.RequestId = "abc"
+			assert.Equal(t, test.want, got)
+		})
+	}
+}
+
+func TestWriteErr_InProgress(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/suites", nil)
+	w := httptest.NewRecorder()
+	writeErr(w, r, fmt.Errorf("idempotency key: %w", errInProgress{}))
+
+	require.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, retryAfter, w.Header().Get("retry-after"))
+	var got problem
+	require.Nil(t, json.Unmarshal(w.Body.Bytes(), &got))
+	assert.Equal(t, "in_progress", got.Code)
+	assert.Empty(t, got.Detail)
+	assert.Empty(t, got.RequestId)
+}
+
+func TestWriteErr_Head(t *testing.T) {
+	r := httptest.NewRequest(http.MethodHead, "/suites", nil)
+	w := httptest.NewRecorder()
+	writeErr(w, r, errNotFound{})
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.NotEmpty(t, w.Header().Get("content-length"))
+	assert.Empty(t, w.Body.Bytes())
+}
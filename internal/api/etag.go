@@ -0,0 +1,67 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// versioned is implemented by entities whose version is exposed as an ETag.
+type versioned interface {
+	CurrentVersion() int64
+}
+
+func etag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// etags splits the entity tags of an If-Match or If-None-Match header.
+func etags(header string) []string {
+	var tags []string
+	for _, t := range strings.Split(header, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			tags = append(tags, t)
+		}
+	}
+	return tags
+}
+
+// ifMatch returns the versions the If-Match header of r allows, or nil if it
+// allows any. Weak tags never match, as updates need a strong comparison.
+func ifMatch(r *http.Request) ([]int64, error) {
+	header := r.Header.Get("if-match")
+	if header == "" {
+		return nil, nil
+	}
+	versions := []int64{}
+	for _, t := range etags(header) {
+		if t == "*" {
+			return nil, nil
+		}
+		if len(t) < 2 || t[0] != '"' || t[len(t)-1] != '"' {
+			continue
+		}
+		v, err := strconv.ParseInt(t[1:len(t)-1], 10, 64)
+		if err == nil {
+			versions = append(versions, v)
+		}
+	}
+	if len(versions) == 0 {
+		return nil, errHttp{
+			error: "no version matches If-Match",
+			code:  http.StatusPreconditionFailed,
+		}
+	}
+	return versions, nil
+}
+
+// noneMatch reports whether the If-None-Match header of r matches tag, using
+// the weak comparison.
+func noneMatch(r *http.Request, tag string) bool {
+	for _, t := range etags(r.Header.Get("if-none-match")) {
+		if t == "*" || strings.TrimPrefix(t, "W/") == tag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,76 @@
+package api
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIfMatch(t *testing.T) {
+	tests := []struct {
+		header   string
+		versions []int64
+		// failed is whether the header matches no version.
+		failed bool
+	}{
+		{"", nil, false},
+		{"*", nil, false},
+		{`"3"`, []int64{3}, false},
+		{`"3", "4"`, []int64{3, 4}, false},
+		{`"3",,"4" ,`, []int64{3, 4}, false},
+		{`"3", *`, nil, false},
+		{`W/"3", "4"`, []int64{4}, false},
+		{`W/"3"`, nil, true},
+		{`W/"3", W/"4"`, nil, true},
+		{`3`, nil, true},
+		{`"x", "3`, nil, true},
+		{`""`, nil, true},
+		{`"`, nil, true},
+		{`,`, nil, true},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodPatch, "/suites/x", nil)
+		if test.header != "" {
+			r.Header.Set("if-match", test.header)
+		}
+		versions, err := ifMatch(r)
+		if test.failed {
+			var herr errHttp
+			require.True(t, errors.As(err, &herr), test.header)
+			assert.Equal(t, http.StatusPreconditionFailed, herr.code,
+				test.header)
+			continue
+		}
+		require.Nil(t, err, test.header)
+		assert.Equal(t, test.versions, versions, test.header)
+	}
+}
+
+func TestNoneMatch(t *testing.T) {
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{"", false},
+		{"*", true},
+		{`"3"`, true},
+		{`W/"3"`, true},
+		{`"4"`, false},
+		{`W/"4"`, false},
+		{`"4", "3"`, true},
+		{`"4", W/"3"`, true},
+		{`"4",*`, true},
+		{`3`, false},
+		{`w/"3"`, false},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/suites/x", nil)
+		if test.header != "" {
+			r.Header.Set("if-none-match", test.header)
+		}
+		assert.Equal(t, test.want, noneMatch(r, etag(3)), test.header)
+	}
+}
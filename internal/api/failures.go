@@ -0,0 +1,42 @@
+package api
+
+import (
+	"github.com/suiteserve/suiteserve/internal/repo"
+	"net/http"
+)
+
+const (
+	defaultClusterLimit = 50
+	maxClusterLimit     = 500
+)
+
+// failureClustersHandler returns the largest clusters of failures of the
+// suite query parameter, or else of the project query parameter, or of every
+// project the request may view without either.
+func (v *v1) failureClustersHandler() errHandlerFunc {
+	return findHandler(func(r *http.Request) (interface{}, error) {
+		limit, err := intQuery(r, "limit", defaultClusterLimit,
+			maxClusterLimit)
+		if err != nil {
+			return nil, err
+		}
+		var f repo.FailureFilter
+		if s := r.URL.Query().Get("suite"); s != "" {
+			id, err := repo.NewId(s)
+			if err != nil {
+				return nil, errHttp{
+					code:  http.StatusBadRequest,
+					cause: err,
+					field: "suite",
+				}
+			}
+			if err := v.authorizeSuite(r.Context(), id, repo.RoleViewer); err != nil {
+				return nil, err
+			}
+			f.SuiteId = &id
+		} else if f.Projects, err = projectsQuery(r); err != nil {
+			return nil, err
+		}
+		return v.repo.FailureClusters(r.Context(), f, limit)
+	})
+}
@@ -1,6 +1,9 @@
 package api
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"github.com/gorilla/mux"
 	"github.com/suiteserve/suiteserve/internal/repo"
@@ -8,6 +11,7 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
 )
 
@@ -16,7 +20,36 @@ func printLog(r *http.Request, err error) {
 	if err != nil {
 		errMsg = ": " + err.Error()
 	}
-	log.Printf("<%s> %s %s%s", r.RemoteAddr, r.Method, r.URL, errMsg)
+	log.Printf("<%s> [%s] %s %s%s", r.RemoteAddr, requestId(r.Context()),
+		r.Method, r.URL, errMsg)
+}
+
+type requestIdKey struct{}
+
+var requestIdPattern = regexp.MustCompile(`^[\w.-]{1,64}$`)
+
+// requestIdMw gives each request an ID, which is sent back in the
+// X-Request-Id header and included in logs and errors. A well-formed ID sent
+// by the client, such as one from a proxy, is kept.
+func requestIdMw(h http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("x-request-id")
+		if !requestIdPattern.MatchString(id) {
+			b := make([]byte, 12)
+			if _, err := rand.Read(b); err != nil {
+				panic(err)
+			}
+			id = hex.EncodeToString(b)
+		}
+		w.Header().Set("x-request-id", id)
+		ctx := context.WithValue(r.Context(), requestIdKey{}, id)
+		h.ServeHTTP(w, r.WithContext(ctx))
+	}
+}
+
+func requestId(ctx context.Context) string {
+	id, _ := ctx.Value(requestIdKey{}).(string)
+	return id
 }
 
 func logMw(h http.Handler) http.HandlerFunc {
@@ -101,14 +134,12 @@ func getIdVar(r *http.Request) (repo.Id, error) {
 
 func methodNotAllowed() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		http.Error(w, http.StatusText(http.StatusMethodNotAllowed),
-			http.StatusMethodNotAllowed)
+		writeErr(w, r, errHttp{code: http.StatusMethodNotAllowed})
 	}
 }
 
 func notFound() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		http.Error(w, http.StatusText(http.StatusNotFound),
-			http.StatusNotFound)
+		writeErr(w, r, errHttp{code: http.StatusNotFound})
 	}
 }
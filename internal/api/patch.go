@@ -0,0 +1,272 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/suiteserve/suiteserve/internal/repo"
+	"github.com/suiteserve/suiteserve/internal/validate"
+	"io/ioutil"
+	"net/http"
+	"sort"
+)
+
+// maxPatchAttempts is how many times a patch without If-Match is retried when
+// it races with another update.
+const maxPatchAttempts = 3
+
+// patcher changes the user-editable fields of one kind of entity with JSON
+// merge patches (RFC 7386).
+type patcher struct {
+	coll repo.Coll
+	// id, if set, returns the id of the entity that the request is for instead
+	// of the id in the path.
+	id func(r *http.Request) (repo.Id, error)
+	// role, if set, is what changing the entity needs instead of reporter.
+	role repo.Role
+	// patchable has the JSON names of the fields users may change.
+	patchable map[string]bool
+	// get returns the entity with the given id and the project it belongs to.
+	get func(ctx context.Context, id repo.Id) (versioned, *string, error)
+	// decode unmarshals a patched entity and returns the project it belongs
+	// to after the patch.
+	decode func(b []byte) (interface{}, *string, error)
+	// update saves the given fields of a decoded entity.
+	update func(ctx context.Context, id repo.Id, versions []int64,
+		v interface{}, fields []string) error
+}
+
+func (v *v1) suitePatcher() patcher {
+	r := v.repo
+	return patcher{
+		coll: repo.Suites,
+		patchable: map[string]bool{
+			"project":     true,
+			"description": true,
+			"tags":        true,
+			"labels":      true,
+		},
+		get: func(ctx context.Context, id repo.Id) (versioned, *string, error) {
+			s, err := r.Suite(ctx, id)
+			return s, s.Project, err
+		},
+		decode: func(b []byte) (interface{}, *string, error) {
+			var s repo.Suite
+			err := json.Unmarshal(b, &s)
+			return s, s.Project, err
+		},
+		update: func(ctx context.Context, id repo.Id, versions []int64,
+			v interface{}, fields []string) error {
+			return r.UpdateSuite(ctx, id, versions, v.(repo.Suite), fields)
+		},
+	}
+}
+
+func (v *v1) casePatcher() patcher {
+	r := v.repo
+	projects := v.newProjects()
+	var project *string
+	return patcher{
+		coll: repo.Cases,
+		patchable: map[string]bool{
+			"description": true,
+			"tags":        true,
+			"labels":      true,
+		},
+		get: func(ctx context.Context, id repo.Id) (versioned, *string, error) {
+			c, err := r.Case(ctx, id)
+			if err != nil {
+				return nil, nil, err
+			}
+			project, err = projects.ofOwner(ctx, c.SuiteId, nil)
+			return c, project, err
+		},
+		decode: func(b []byte) (interface{}, *string, error) {
+			var c repo.Case
+			err := json.Unmarshal(b, &c)
+			return c, project, err
+		},
+		update: func(ctx context.Context, id repo.Id, versions []int64,
+			v interface{}, fields []string) error {
+			return r.UpdateCase(ctx, id, versions, v.(repo.Case), fields)
+		},
+	}
+}
+
+// mergePatchHandler returns a handler that applies a merge patch to the
+// entity with the id in the path and writes the result. Concurrent updates
+// fail the request if it has If-Match, or are otherwise retried.
+func (v *v1) mergePatchHandler(newPatcher func() patcher) errHandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		p := newPatcher()
+		getId, role := p.id, p.role
+		if getId == nil {
+			getId = getIdVar
+		}
+		if role == "" {
+			role = repo.RoleReporter
+		}
+		id, err := getId(r)
+		if err != nil {
+			return err
+		}
+		versions, err := ifMatch(r)
+		if err != nil {
+			return err
+		}
+		patch, err := readMergePatch(r)
+		if err != nil {
+			return err
+		}
+		fields := make([]string, 0, len(patch))
+		errs := validate.Errors{}
+		for f := range patch {
+			if !p.patchable[f] {
+				errs.Add(f, "cannot be changed")
+			}
+			fields = append(fields, f)
+		}
+		sort.Strings(fields)
+		if err := errs.Err(); err != nil {
+			return err
+		}
+
+		ctx := r.Context()
+		for attempt := 1; ; attempt++ {
+			before, project, err := p.get(ctx, id)
+			if err != nil {
+				return err
+			}
+			if err := authorize(ctx, project, role); err != nil {
+				return err
+			}
+			version := before.CurrentVersion()
+			if versions != nil && !hasVersion(versions, version) {
+				return errHttp{
+					error: "no version matches If-Match",
+					code:  http.StatusPreconditionFailed,
+				}
+			}
+			b, err := applyMergePatch(before, patch)
+			if err != nil {
+				return err
+			}
+			after, newProject, err := p.decode(b)
+			if err != nil {
+				return errBadJson(err)
+			}
+			errs := validate.Errors{}
+			validate.Fields(errs, "", after, fields)
+			if err := errs.Err(); err != nil {
+				return err
+			}
+			if _, ok := patch["project"]; ok {
+				err := authorize(ctx, newProject, repo.RoleReporter)
+				if err != nil {
+					return err
+				}
+			}
+			err = p.update(ctx, id, []int64{version}, after, fields)
+			if isPreconditionFailed(err) && versions == nil {
+				if attempt < maxPatchAttempts {
+					continue
+				}
+				return errHttp{
+					error: "updated concurrently",
+					code:  http.StatusConflict,
+					cause: err,
+				}
+			} else if err != nil {
+				return err
+			}
+			saved, _, err := p.get(ctx, id)
+			if err != nil {
+				return err
+			}
+			v.audit(r, "update", p.coll, id, project, before, saved)
+			w.Header().Set("etag", etag(saved.CurrentVersion()))
+			return writeJson(w, r, saved)
+		}
+	}
+}
+
+func hasVersion(versions []int64, version int64) bool {
+	for _, v := range versions {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
+
+// readMergePatch reads the members of a merge patch object, keeping those
+// set to null.
+func readMergePatch(r *http.Request) (map[string]interface{}, error) {
+	if r.Header.Get("content-type") != "application/merge-patch+json" {
+		return nil, errHttp{code: http.StatusUnsupportedMediaType}
+	}
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	var patch map[string]interface{}
+	if err := decodeJson(b, &patch); err != nil {
+		return nil, errBadJson(err)
+	}
+	if patch == nil {
+		return nil, errHttp{
+			error: "merge patch is not an object",
+			code:  http.StatusBadRequest,
+		}
+	}
+	return patch, nil
+}
+
+// applyMergePatch returns the JSON of v with patch applied to it.
+func applyMergePatch(v interface{}, patch map[string]interface{}) ([]byte,
+	error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var doc map[string]interface{}
+	if err := decodeJson(b, &doc); err != nil {
+		return nil, err
+	}
+	return json.Marshal(mergePatch(doc, patch))
+}
+
+// mergePatch merges patch into target as RFC 7386 describes: null members
+// remove what they name, objects are merged, and anything else replaces.
+func mergePatch(target interface{}, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = map[string]interface{}{}
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+		} else {
+			t[k] = mergePatch(t[k], v)
+		}
+	}
+	return t
+}
+
+// decodeJson unmarshals b, keeping numbers exact.
+func decodeJson(b []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if dec.More() {
+		return errors.New("trailing data after JSON")
+	}
+	return nil
+}
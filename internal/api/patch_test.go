@@ -0,0 +1,309 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/suiteserve/suiteserve/internal/repo"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMergePatch(t *testing.T) {
+	// the examples of RFC 7386, appendix A
+	tests := []struct {
+		target, patch, want string
+	}{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`{"a":"foo"}`, `null`, `null`},
+		{`{"a":"foo"}`, `"bar"`, `"bar"`},
+		{`{"e":null}`, `{"a":1}`, `{"a":1,"e":null}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	}
+	for _, test := range tests {
+		var target, patch interface{}
+		require.Nil(t, decodeJson([]byte(test.target), &target))
+		require.Nil(t, decodeJson([]byte(test.patch), &patch))
+		b, err := json.Marshal(mergePatch(target, patch))
+		require.Nil(t, err)
+		assert.JSONEq(t, test.want, string(b), test.patch)
+	}
+}
+
+func TestReadMergePatch(t *testing.T) {
+	tests := []struct {
+		contentType string
+		body        string
+		code        int
+		want        map[string]interface{}
+	}{
+		{"application/merge-patch+json", `{"a":null,"b":{"c":1}}`, 0,
+			map[string]interface{}{
+				"a": nil,
+				"b": map[string]interface{}{"c": json.Number("1")},
+			}},
+		{"application/json", `{}`, http.StatusUnsupportedMediaType, nil},
+		{"application/merge-patch+json", `null`, http.StatusBadRequest, nil},
+		{"application/merge-patch+json", `[]`, http.StatusBadRequest, nil},
+		{"application/merge-patch+json", `{} {}`, http.StatusBadRequest, nil},
+		{"application/merge-patch+json", `{`, http.StatusBadRequest, nil},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodPatch, "/suites/x",
+			strings.NewReader(test.body))
+		r.Header.Set("content-type", test.contentType)
+		patch, err := readMergePatch(r)
+		if test.code == 0 {
+			require.Nil(t, err, test.body)
+			assert.Equal(t, test.want, patch, test.body)
+			continue
+		}
+		herr, ok := err.(errHttp)
+		require.True(t, ok, test.body)
+		assert.Equal(t, test.code, herr.code, test.body)
+	}
+}
+
+func TestApplyMergePatch(t *testing.T) {
+	s := repo.Suite{
+		VersionedEntity: repo.VersionedEntity{Version: int64Ptr(3)},
+		Project:         str("api"),
+		Labels:          map[string]string{"a": "1", "b": "2"},
+	}
+	b, err := applyMergePatch(s, map[string]interface{}{
+		"project": nil,
+		"labels":  map[string]interface{}{"a": nil, "c": "3"},
+	})
+	require.Nil(t, err)
+	var got repo.Suite
+	require.Nil(t, json.Unmarshal(b, &got))
+	assert.Nil(t, got.Project)
+	assert.Equal(t, map[string]string{"b": "2", "c": "3"}, got.Labels)
+	assert.Equal(t, int64(3), got.CurrentVersion())
+}
+
+func int64Ptr(i int64) *int64 {
+	return &i
+}
+
+// patched is an entity to patch.
+type patched struct {
+	Version *int64            `json:"version,omitempty"`
+	Name    *string           `json:"name,omitempty" validate:"max=4"`
+	Owner   *string           `json:"owner,omitempty" validate:"readonly"`
+	Labels  map[string]string `json:"labels,omitempty"`
+}
+
+func (p patched) CurrentVersion() int64 {
+	return *p.Version
+}
+
+// patchStore keeps one patched entity. Its update fails while it has races
+// left, as if another update came first.
+type patchStore struct {
+	entity  patched
+	project string
+	races   int
+	updates int
+}
+
+func (s *patchStore) patcher() patcher {
+	return patcher{
+		coll: repo.Suites,
+		patchable: map[string]bool{
+			"name":   true,
+			"owner":  true,
+			"labels": true,
+		},
+		get: func(context.Context, repo.Id) (versioned, *string, error) {
+			return s.entity, &s.project, nil
+		},
+		decode: func(b []byte) (interface{}, *string, error) {
+			var p patched
+			err := json.Unmarshal(b, &p)
+			return p, &s.project, err
+		},
+		update: func(_ context.Context, _ repo.Id, versions []int64,
+			v interface{}, _ []string) error {
+			s.updates++
+			if s.races > 0 {
+				s.races--
+				*s.entity.Version++
+			}
+			if !hasVersion(versions, *s.entity.Version) {
+				return errPrecondition{}
+			}
+			s.entity = v.(patched)
+			*s.entity.Version++
+			return nil
+		},
+	}
+}
+
+func TestV1_MergePatchHandler(t *testing.T) {
+	tests := []struct {
+		name    string
+		patch   string
+		ifMatch string
+		races   int
+		role    repo.Role
+		code    int
+		// updates is how many times the update is tried.
+		updates int
+		want    patched
+		errors  map[string][]string
+	}{
+		{
+			name:    "ok",
+			patch:   `{"name":"b","labels":{"x":null,"z":"3"}}`,
+			code:    http.StatusOK,
+			updates: 1,
+			want: patched{
+				Version: int64Ptr(2),
+				Name:    str("b"),
+				Labels:  map[string]string{"y": "2", "z": "3"},
+			},
+		},
+		{
+			name:    "remove",
+			patch:   `{"name":null,"labels":null}`,
+			ifMatch: `"1"`,
+			code:    http.StatusOK,
+			updates: 1,
+			want:    patched{Version: int64Ptr(2)},
+		},
+		{
+			name:  "unpatchable",
+			patch: `{"name":"b","version":7,"id":"x"}`,
+			code:  http.StatusUnprocessableEntity,
+			errors: map[string][]string{
+				"version": {"cannot be changed"},
+				"id":      {"cannot be changed"},
+			},
+		},
+		{
+			name:   "readonly",
+			patch:  `{"owner":"x"}`,
+			code:   http.StatusUnprocessableEntity,
+			errors: map[string][]string{"owner": {"is set by the server"}},
+		},
+		{
+			name:   "readonly null",
+			patch:  `{"owner":null}`,
+			code:   http.StatusUnprocessableEntity,
+			errors: map[string][]string{"owner": {"is set by the server"}},
+		},
+		{
+			name:   "invalid",
+			patch:  `{"name":"toolong"}`,
+			code:   http.StatusUnprocessableEntity,
+			errors: map[string][]string{"name": {"is longer than 4 bytes"}},
+		},
+		{
+			name:  "bad type",
+			patch: `{"name":1}`,
+			code:  http.StatusBadRequest,
+		},
+		{
+			name:  "forbidden",
+			patch: `{"name":"b"}`,
+			role:  repo.RoleViewer,
+			code:  http.StatusForbidden,
+		},
+		{
+			name:    "if-match mismatch",
+			patch:   `{"name":"b"}`,
+			ifMatch: `"2", W/"1"`,
+			code:    http.StatusPreconditionFailed,
+		},
+		{
+			name:    "if-match raced",
+			patch:   `{"name":"b"}`,
+			ifMatch: `"1"`,
+			races:   1,
+			code:    http.StatusPreconditionFailed,
+			updates: 1,
+		},
+		{
+			name:    "retried",
+			patch:   `{"name":"b"}`,
+			races:   maxPatchAttempts - 1,
+			code:    http.StatusOK,
+			updates: maxPatchAttempts,
+			want: patched{
+				Version: int64Ptr(maxPatchAttempts + 1),
+				Name:    str("b"),
+				Labels:  map[string]string{"x": "1", "y": "2"},
+			},
+		},
+		{
+			name:    "conflict",
+			patch:   `{"name":"b"}`,
+			races:   maxPatchAttempts,
+			code:    http.StatusConflict,
+			updates: maxPatchAttempts,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := patchStore{
+				entity: patched{
+					Version: int64Ptr(1),
+					Name:    str("a"),
+					Labels:  map[string]string{"x": "1", "y": "2"},
+				},
+				project: "api",
+				races:   test.races,
+			}
+			role := test.role
+			if role == "" {
+				role = repo.RoleReporter
+			}
+			r := fakeRepo{}
+			v := v1{repo: &r}
+			h := v.mergePatchHandler(s.patcher)
+
+			id := newId()
+			req := httptest.NewRequest(http.MethodPatch, "/suites/"+id.String(),
+				strings.NewReader(test.patch))
+			req.Header.Set("content-type", "application/merge-patch+json")
+			if test.ifMatch != "" {
+				req.Header.Set("if-match", test.ifMatch)
+			}
+			req = mux.SetURLVars(req, map[string]string{"id": id.String()})
+			req = req.WithContext(context.WithValue(req.Context(),
+				accessKey{}, access{"api": role}))
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+
+			require.Equal(t, test.code, w.Code, w.Body.String())
+			assert.Equal(t, test.updates, s.updates)
+			if test.code != http.StatusOK {
+				var p problem
+				require.Nil(t, json.Unmarshal(w.Body.Bytes(), &p))
+				assert.Equal(t, test.errors, p.Errors)
+				assert.Empty(t, r.audits)
+				return
+			}
+			var got patched
+			require.Nil(t, json.Unmarshal(w.Body.Bytes(), &got))
+			assert.Equal(t, test.want, got)
+			assert.Equal(t, etag(*test.want.Version), w.Header().Get("etag"))
+			assert.Len(t, r.audits, 1)
+		})
+	}
+}
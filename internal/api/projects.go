@@ -0,0 +1,83 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/suiteserve/suiteserve/internal/repo"
+	"net/http"
+)
+
+// projectsHandler returns the projects the request may view.
+func (v *v1) projectsHandler() errHandlerFunc {
+	return findHandler(func(r *http.Request) (interface{}, error) {
+		var f repo.ProjectFilter
+		if a, ok := accessFrom(r.Context()); ok {
+			f.Names = a.projects(repo.RoleViewer)
+		}
+		return v.repo.Projects(r.Context(), f)
+	})
+}
+
+func (v *v1) projectHandler() errHandlerFunc {
+	return findHandler(func(r *http.Request) (interface{}, error) {
+		name := getVar(r, "name")
+		if err := authorize(r.Context(), &name, repo.RoleViewer); err != nil {
+			return nil, err
+		}
+		return v.repo.ProjectByName(r.Context(), name)
+	})
+}
+
+// projectSummaryHandler summarizes the suites of the project in the path, with
+// the pass rate of its latest finished suites, 20 or the last query parameter
+// up to 500.
+func (v *v1) projectSummaryHandler() errHandlerFunc {
+	return findHandler(func(r *http.Request) (interface{}, error) {
+		name := getVar(r, "name")
+		last, err := intQuery(r, "last", 20, 500)
+		if err != nil {
+			return nil, err
+		}
+		if err := authorize(r.Context(), &name, repo.RoleViewer); err != nil {
+			return nil, err
+		}
+		return v.repo.ProjectSummary(r.Context(), name, last)
+	})
+}
+
+// projectPatcher changes the settings of the project named in the path, which
+// only admins of the project may do.
+func (v *v1) projectPatcher() patcher {
+	r := v.repo
+	return patcher{
+		coll: repo.Projects,
+		id: func(req *http.Request) (repo.Id, error) {
+			p, err := r.ProjectByName(req.Context(), getVar(req, "name"))
+			if err != nil {
+				return repo.Id{}, err
+			}
+			return *p.Id, nil
+		},
+		role: repo.RoleAdmin,
+		patchable: map[string]bool{
+			"displayName":   true,
+			"defaultBranch": true,
+			"retention":     true,
+			"baseline":      true,
+			"owners":        true,
+		},
+		get: func(ctx context.Context, id repo.Id) (versioned, *string, error) {
+			p, err := r.Project(ctx, id)
+			return p, p.Name, err
+		},
+		decode: func(b []byte) (interface{}, *string, error) {
+			var p repo.Project
+			err := json.Unmarshal(b, &p)
+			return p, p.Name, err
+		},
+		update: func(ctx context.Context, id repo.Id, versions []int64,
+			v interface{}, fields []string) error {
+			return r.UpdateProject(ctx, id, versions, v.(repo.Project), fields)
+		},
+	}
+}
@@ -0,0 +1,127 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/suiteserve/suiteserve/internal/repo"
+	"github.com/suiteserve/suiteserve/internal/validate"
+	"net/http"
+	"time"
+)
+
+// quarantinesHandler returns the quarantines of the project query parameter,
+// or of every project the request may view without it. With active=true, only
+// those that have not expired are returned, and in the short form for runners
+// with format=runner.
+func (v *v1) quarantinesHandler() errHandlerFunc {
+	return findHandler(func(r *http.Request) (interface{}, error) {
+		projects, err := projectsQuery(r)
+		if err != nil {
+			return nil, err
+		}
+		f := repo.QuarantineFilter{Projects: projects}
+		q := r.URL.Query()
+		if q.Get("active") == "true" || q.Get("format") == "runner" {
+			now := repo.MsTime(time.Now())
+			f.ActiveAt = &now
+		}
+		qs, err := v.repo.Quarantines(r.Context(), f)
+		if err != nil || q.Get("format") != "runner" {
+			return qs, err
+		}
+		tests := make([]repo.QuarantinedTest, len(qs))
+		for i, q := range qs {
+			tests[i] = repo.QuarantinedTest{
+				Fingerprint: *q.Fingerprint,
+				Name:        *q.Name,
+				Params:      q.Params,
+				ExpiresAt:   *q.ExpiresAt,
+			}
+		}
+		return tests, nil
+	})
+}
+
+func (v *v1) quarantine(ctx context.Context, id repo.Id) (interface{},
+	error) {
+	q, err := v.repo.Quarantine(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return q, authorize(ctx, q.Project, repo.RoleViewer)
+}
+
+func (v *v1) insertQuarantineHandler() errHandlerFunc {
+	return v.insertHandler(func(r *http.Request) (interface{}, error) {
+		var q repo.Quarantine
+		if err := readJson(r, &q); err != nil {
+			return nil, err
+		}
+		errs := validate.Errors{}
+		validate.Insert(errs, "", q)
+		if err := errs.Err(); err != nil {
+			return nil, err
+		}
+		if err := authorize(r.Context(), q.Project, repo.RoleReporter); err != nil {
+			return nil, err
+		}
+		id, err := v.repo.InsertQuarantine(r.Context(), q)
+		if err != nil {
+			return nil, err
+		}
+		q, err = v.repo.Quarantine(r.Context(), id)
+		if err != nil {
+			auditFailed(r, "insert", repo.Quarantines, id, err)
+			return id, nil
+		}
+		v.audit(r, "insert", repo.Quarantines, id, q.Project, nil, q)
+		return id, nil
+	})
+}
+
+func (v *v1) quarantinePatcher() patcher {
+	r := v.repo
+	return patcher{
+		coll: repo.Quarantines,
+		patchable: map[string]bool{
+			"owner":     true,
+			"reason":    true,
+			"expiresAt": true,
+		},
+		get: func(ctx context.Context, id repo.Id) (versioned, *string, error) {
+			q, err := r.Quarantine(ctx, id)
+			return q, q.Project, err
+		},
+		decode: func(b []byte) (interface{}, *string, error) {
+			var q repo.Quarantine
+			err := json.Unmarshal(b, &q)
+			return q, q.Project, err
+		},
+		update: func(ctx context.Context, id repo.Id, versions []int64,
+			v interface{}, fields []string) error {
+			return r.UpdateQuarantine(ctx, id, versions, v.(repo.Quarantine),
+				fields)
+		},
+	}
+}
+
+func (v *v1) deleteQuarantineHandler() errHandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		id, err := getIdVar(r)
+		if err != nil {
+			return err
+		}
+		q, err := v.repo.Quarantine(r.Context(), id)
+		if err != nil {
+			return err
+		}
+		if err := authorize(r.Context(), q.Project, repo.RoleReporter); err != nil {
+			return err
+		}
+		if err := v.repo.DeleteQuarantine(r.Context(), id); err != nil {
+			return err
+		}
+		v.audit(r, "delete", repo.Quarantines, id, q.Project, q, nil)
+		return nil
+	}
+}
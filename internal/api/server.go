@@ -3,6 +3,7 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"golang.org/x/sync/errgroup"
 	"io/ioutil"
 	"log"
@@ -25,17 +26,30 @@ type Options struct {
 	UserContentRepo FileMetaRepo
 
 	V1 http.Handler
+	// AuthRepo authenticates requests to V1 with bearer tokens, and grants
+	// roles to users who have logged in.
+	AuthRepo AuthRepo
+	// Anonymous grants full access to requests to V1 that have no token or
+	// session, which are otherwise rejected. It is meant for local development
+	// and has no effect with Sso.
+	Anonymous bool
+	// Sso, if set, requires users of the UI and the API to log in.
+	Sso *Sso
 }
 
 func (o Options) newHandler() http.Handler {
+	a := auth{o.AuthRepo, o.Sso, o.Anonymous}
 	var m http.ServeMux
 	m.Handle("/v1/",
-		http.StripPrefix("/v1", o.V1))
+		http.StripPrefix("/v1", a.mw(o.V1)))
+	if o.Sso != nil {
+		m.Handle("/auth/", o.Sso.handler())
+	}
 	m.Handle(o.UserContentHost+"/",
 		userContentHandler(o.UserContentRepo, o.UserContentDir))
 	m.Handle("/",
-		uiHandler(o.PublicDir))
-	return logMw(secMw(&m))
+		a.uiMw(uiHandler(o.PublicDir)))
+	return requestIdMw(logMw(secMw(&m)))
 }
 
 func Serve(ctx context.Context, opts Options) error {
@@ -77,22 +91,38 @@ func readJson(r *http.Request, dst interface{}) error {
 		return err
 	}
 	if err := json.Unmarshal(b, dst); err != nil {
-		return errHttp{
-			code:  http.StatusBadRequest,
-			cause: err,
-		}
+		return errBadJson(err)
 	}
 	return nil
 }
 
+// errBadJson returns a Bad Request error for JSON that could not be
+// unmarshalled, naming the field at fault if known.
+func errBadJson(err error) error {
+	herr := errHttp{
+		code:  http.StatusBadRequest,
+		cause: err,
+	}
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		herr.field = typeErr.Field
+	}
+	return herr
+}
+
 func writeJson(w http.ResponseWriter, r *http.Request, v interface{}) error {
 	b, err := json.Marshal(v)
 	if err != nil {
 		panic(err)
 	}
+	return writeJsonBytes(w, r, b)
+}
+
+func writeJsonBytes(w http.ResponseWriter, r *http.Request, b []byte) error {
 	b = append(b, '\n')
 	w.Header().Set("content-length", strconv.Itoa(len(b)))
 	w.Header().Set("content-type", "application/json")
+	var err error
 	if r.Method != http.MethodHead {
 		_, err = w.Write(b)
 	}
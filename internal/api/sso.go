@@ -0,0 +1,198 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/suiteserve/suiteserve/internal/oidc"
+	"github.com/suiteserve/suiteserve/internal/repo"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	loginCookie   = "suiteserve_login"
+	sessionCookie = "suiteserve_session"
+	loginTtl      = 10 * time.Minute
+
+	defaultSessionTtl = 12 * time.Hour
+)
+
+type SessionRepo interface {
+	InsertSession(ctx context.Context, s repo.Session) (id repo.Id, secret string, err error)
+	SessionBySecret(ctx context.Context, secret string) (repo.Session, error)
+	DeleteSession(ctx context.Context, secret string) error
+}
+
+// Sso logs users in through an OpenID Connect provider, keeping them logged in
+// with a session cookie.
+type Sso struct {
+	Provider *oidc.Provider
+	Repo     SessionRepo
+	// Ttl is how long a session lasts. Zero means 12 hours.
+	Ttl time.Duration
+}
+
+type login struct {
+	oidc.Login
+	Next string
+}
+
+func (s *Sso) handler() http.Handler {
+	var m http.ServeMux
+	m.Handle("/auth/login", s.loginHandler())
+	m.Handle("/auth/callback", s.callbackHandler())
+	m.Handle("/auth/logout", s.logoutHandler())
+	m.Handle("/auth/me", s.meHandler())
+	return &m
+}
+
+func (s *Sso) loginHandler() errHandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		l, err := oidc.NewLogin()
+		if err != nil {
+			return err
+		}
+		b, err := json.Marshal(login{l, safeNext(r.URL.Query().Get("next"))})
+		if err != nil {
+			panic(err)
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     loginCookie,
+			Value:    base64.RawURLEncoding.EncodeToString(b),
+			Path:     "/auth/",
+			MaxAge:   int(loginTtl / time.Second),
+			Secure:   true,
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+		http.Redirect(w, r, s.Provider.AuthCodeUrl(l), http.StatusFound)
+		return nil
+	}
+}
+
+func (s *Sso) callbackHandler() errHandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		var l login
+		c, err := r.Cookie(loginCookie)
+		if err != nil {
+			return errHttp{error: "no login in progress", code: http.StatusBadRequest}
+		}
+		b, err := base64.RawURLEncoding.DecodeString(c.Value)
+		if err == nil {
+			err = json.Unmarshal(b, &l)
+		}
+		if err != nil {
+			return errHttp{code: http.StatusBadRequest, cause: err}
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:   loginCookie,
+			Path:   "/auth/",
+			MaxAge: -1,
+		})
+		q := r.URL.Query()
+		if q.Get("error") != "" {
+			return errHttp{
+				error: "login failed: " + q.Get("error"),
+				code:  http.StatusUnauthorized,
+			}
+		}
+		if subtle.ConstantTimeCompare([]byte(q.Get("state")),
+			[]byte(l.State)) != 1 {
+			return errHttp{error: "bad state", code: http.StatusBadRequest}
+		}
+		claims, err := s.Provider.Exchange(r.Context(), l.Login, q.Get("code"))
+		if err != nil {
+			return errHttp{code: http.StatusUnauthorized, cause: err}
+		}
+		ttl := s.Ttl
+		if ttl <= 0 {
+			ttl = defaultSessionTtl
+		}
+		expiresAt := time.Now().Add(ttl)
+		sess := repo.Session{
+			Subject:   &claims.Subject,
+			Groups:    claims.Groups,
+			ExpiresAt: (*repo.MsTime)(&expiresAt),
+		}
+		if claims.Email != "" {
+			sess.Email = &claims.Email
+		}
+		if claims.Name != "" {
+			sess.Name = &claims.Name
+		}
+		_, secret, err := s.Repo.InsertSession(r.Context(), sess)
+		if err != nil {
+			return err
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     sessionCookie,
+			Value:    secret,
+			Path:     "/",
+			Expires:  expiresAt,
+			Secure:   true,
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+		http.Redirect(w, r, l.Next, http.StatusFound)
+		return nil
+	}
+}
+
+func (s *Sso) logoutHandler() errHandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		if r.Method != http.MethodPost {
+			return errHttp{code: http.StatusMethodNotAllowed}
+		}
+		if c, err := r.Cookie(sessionCookie); err == nil {
+			if err := s.Repo.DeleteSession(r.Context(), c.Value); err != nil {
+				return err
+			}
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:   sessionCookie,
+			Path:   "/",
+			MaxAge: -1,
+		})
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+}
+
+func (s *Sso) meHandler() errHandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		sess, ok, err := s.session(r)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errHttp{code: http.StatusUnauthorized}
+		}
+		return writeJson(w, r, sess)
+	}
+}
+
+// session returns the session of the request's cookie, if it has a valid one.
+func (s *Sso) session(r *http.Request) (repo.Session, bool, error) {
+	c, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return repo.Session{}, false, nil
+	}
+	sess, err := s.Repo.SessionBySecret(r.Context(), c.Value)
+	if isNotFound(err) {
+		return sess, false, nil
+	}
+	return sess, err == nil, err
+}
+
+// safeNext returns next if it is a path on this host, so that logging in
+// cannot redirect elsewhere.
+func safeNext(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") ||
+		strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	return next
+}
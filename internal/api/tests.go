@@ -0,0 +1,113 @@
+package api
+
+import (
+	"github.com/suiteserve/suiteserve/internal/repo"
+	"net/http"
+	"strconv"
+)
+
+const (
+	defaultHistoryLimit = 50
+	maxHistoryLimit     = 500
+	defaultFlakyLimit   = 100
+	maxFlakyLimit       = 1000
+)
+
+type testHistory struct {
+	Fingerprint string    `json:"fingerprint"`
+	Runs        []testRun `json:"runs"`
+}
+
+type testRun struct {
+	repo.TestRun
+	Links runLinks `json:"links"`
+}
+
+// runLinks are where a run can be found in the API and in the UI.
+type runLinks struct {
+	Suite string `json:"suite,omitempty"`
+	Case  string `json:"case"`
+	Ui    string `json:"ui,omitempty"`
+}
+
+// testHistoryHandler returns the latest runs of a test that the request may
+// view, up to the limit query parameter.
+func (v *v1) testHistoryHandler() errHandlerFunc {
+	return findHandler(func(r *http.Request) (interface{}, error) {
+		fingerprint := getVar(r, "fingerprint")
+		limit, err := intQuery(r, "limit", defaultHistoryLimit, maxHistoryLimit)
+		if err != nil {
+			return nil, err
+		}
+		runs, err := v.repo.TestRuns(r.Context(), fingerprint, limit)
+		if err != nil {
+			return nil, err
+		}
+		acc, restricted := accessFrom(r.Context())
+		ps := v.newProjects()
+		h := testHistory{
+			Fingerprint: fingerprint,
+			Runs:        []testRun{},
+		}
+		for _, run := range runs {
+			if restricted {
+				project, err := ps.ofOwner(r.Context(), run.SuiteId, nil)
+				if isNotFound(err) {
+					continue
+				} else if err != nil {
+					return nil, err
+				}
+				if !acc.allows(project, repo.RoleViewer) {
+					continue
+				}
+			}
+			links := runLinks{Case: "/v1/cases/" + run.CaseId.String()}
+			if run.SuiteId != nil {
+				links.Suite = "/v1/suites/" + run.SuiteId.String()
+				links.Ui = "/suites/" + run.SuiteId.String() + "/cases/" +
+					run.CaseId.String()
+			}
+			h.Runs = append(h.Runs, testRun{run, links})
+		}
+		return h, nil
+	})
+}
+
+// flakyHandler returns the flakiest tests of the project query parameter, or
+// of every project the request may view without it.
+func (v *v1) flakyHandler() errHandlerFunc {
+	return findHandler(func(r *http.Request) (interface{}, error) {
+		limit, err := intQuery(r, "limit", defaultFlakyLimit, maxFlakyLimit)
+		if err != nil {
+			return nil, err
+		}
+		projects, err := projectsQuery(r)
+		if err != nil {
+			return nil, err
+		}
+		return v.repo.FlakyTests(r.Context(), repo.TestFilter{
+			Projects: projects,
+		}, limit)
+	})
+}
+
+// intQuery returns the positive integer query parameter with the given name,
+// or def if it is missing, capped at max.
+func intQuery(r *http.Request, name string, def, max int) (int, error) {
+	s := r.URL.Query().Get(name)
+	if s == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n <= 0 {
+		return 0, errHttp{
+			error: name + " must be a positive integer",
+			code:  http.StatusBadRequest,
+			field: name,
+		}
+	}
+	if n > max {
+		n = max
+	}
+	return n, nil
+}
@@ -0,0 +1,39 @@
+package api
+
+import (
+	"context"
+	"github.com/suiteserve/suiteserve/internal/repo"
+)
+
+// caseTree is a case with its children, by index.
+type caseTree struct {
+	repo.Case
+	Children []*caseTree `json:"children"`
+}
+
+// caseSubtree returns the case with the given id with its descendants as a
+// tree.
+func (v *v1) caseSubtree(ctx context.Context, id repo.Id) (interface{},
+	error) {
+	if err := v.authorizeCase(ctx, id, repo.RoleViewer); err != nil {
+		return nil, err
+	}
+	cs, err := v.repo.CaseSubtree(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	trees := make(map[repo.Id]*caseTree, len(cs))
+	for _, c := range cs {
+		trees[*c.Id] = &caseTree{Case: c, Children: []*caseTree{}}
+	}
+	// cases are by index, which children keep
+	for _, c := range cs {
+		if *c.Id == id || c.ParentId == nil {
+			continue
+		}
+		if parent, ok := trees[*c.ParentId]; ok {
+			parent.Children = append(parent.Children, trees[*c.Id])
+		}
+	}
+	return trees[id], nil
+}
@@ -2,10 +2,22 @@ package api
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"github.com/gorilla/mux"
+	"github.com/suiteserve/suiteserve/internal/baseline"
+	"github.com/suiteserve/suiteserve/internal/bundle"
 	"github.com/suiteserve/suiteserve/internal/repo"
+	"github.com/suiteserve/suiteserve/internal/validate"
 	"github.com/suiteserve/suiteserve/sse"
+	"io"
+	"io/ioutil"
+	"math"
+	"mime/multipart"
 	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
 	"time"
 )
 
@@ -15,32 +27,84 @@ type Repo interface {
 	AllAttachments(ctx context.Context) ([]repo.Attachment, error)
 	SuiteAttachments(ctx context.Context, suiteId repo.Id) ([]repo.Attachment, error)
 	CaseAttachments(ctx context.Context, caseId repo.Id) ([]repo.Attachment, error)
+	CaseAttemptAttachments(ctx context.Context, caseId repo.Id, attempt int64) ([]repo.Attachment, error)
 
 	InsertSuite(ctx context.Context, s repo.Suite) (id repo.Id, err error)
 	Suite(ctx context.Context, id repo.Id) (repo.Suite, error)
-	SuitePage(ctx context.Context) (repo.SuitePage, error)
-	SuitePageAfter(ctx context.Context, cursor repo.SuitePageCursor) (repo.SuitePage, error)
-	FinishSuite(ctx context.Context, id repo.Id, result repo.SuiteResult, at repo.MsTime) error
-	DisconnectSuite(ctx context.Context, id repo.Id, at repo.MsTime) error
+	SuitePage(ctx context.Context, f repo.SuiteFilter) (repo.SuitePage, error)
+	SuitePageAfter(ctx context.Context, f repo.SuiteFilter, cursor repo.SuitePageCursor) (repo.SuitePage, error)
+	UpdateSuite(ctx context.Context, id repo.Id, versions []int64, s repo.Suite, fields []string) error
+	DeleteSuite(ctx context.Context, id repo.Id, versions []int64, at repo.MsTime) error
+	RestoreSuite(ctx context.Context, id repo.Id, versions []int64) error
+	PurgeSuite(ctx context.Context, id repo.Id) error
+	FinishSuite(ctx context.Context, id repo.Id, versions []int64, result repo.SuiteResult, at repo.MsTime) error
+	DisconnectSuite(ctx context.Context, id repo.Id, versions []int64, at repo.MsTime) error
+	LatestBaseline(ctx context.Context, sel repo.BaselineSelector) (repo.Suite, error)
+	SetSuiteBaseline(ctx context.Context, id, baselineId repo.Id) error
+	InsertRegressionSummary(ctx context.Context, s repo.RegressionSummary) (id repo.Id, err error)
 
 	InsertCase(ctx context.Context, c repo.Case) (id repo.Id, err error)
 	Case(ctx context.Context, id repo.Id) (repo.Case, error)
 	SuiteCases(ctx context.Context, suiteId repo.Id) ([]repo.Case, error)
-	FinishCase(ctx context.Context, id repo.Id, result repo.CaseResult, at repo.MsTime) error
+	CaseSubtree(ctx context.Context, id repo.Id) ([]repo.Case, error)
+	UpdateCase(ctx context.Context, id repo.Id, versions []int64, c repo.Case, fields []string) error
+	FinishCase(ctx context.Context, id repo.Id, versions []int64, result repo.CaseResult, at repo.MsTime) error
+	RetryCase(ctx context.Context, id repo.Id, versions []int64, at repo.MsTime) error
+
+	TestRuns(ctx context.Context, fingerprint string, limit int) ([]repo.TestRun, error)
+	FlakyTests(ctx context.Context, f repo.TestFilter, limit int) ([]repo.Test, error)
+	FailureClusters(ctx context.Context, f repo.FailureFilter, limit int) ([]repo.FailureCluster, error)
+
+	Project(ctx context.Context, id repo.Id) (repo.Project, error)
+	ProjectByName(ctx context.Context, name string) (repo.Project, error)
+	Projects(ctx context.Context, f repo.ProjectFilter) ([]repo.Project, error)
+	UpdateProject(ctx context.Context, id repo.Id, versions []int64, p repo.Project, fields []string) error
+	ProjectSummary(ctx context.Context, name string, last int) (repo.ProjectSummary, error)
+
+	InsertQuarantine(ctx context.Context, q repo.Quarantine) (id repo.Id, err error)
+	Quarantine(ctx context.Context, id repo.Id) (repo.Quarantine, error)
+	Quarantines(ctx context.Context, f repo.QuarantineFilter) ([]repo.Quarantine, error)
+	UpdateQuarantine(ctx context.Context, id repo.Id, versions []int64, q repo.Quarantine, fields []string) error
+	DeleteQuarantine(ctx context.Context, id repo.Id) error
 
 	InsertLogLine(ctx context.Context, ll repo.LogLine) (id repo.Id, err error)
+	InsertLogLines(ctx context.Context, lls []repo.LogLine) (ids []repo.Id, err error)
 	LogLine(ctx context.Context, id repo.Id) (repo.LogLine, error)
+	SuiteLogLines(ctx context.Context, suiteId repo.Id) ([]repo.LogLine, error)
 	CaseLogLines(ctx context.Context, llId repo.Id) ([]repo.LogLine, error)
+	CaseAttemptLogLines(ctx context.Context, caseId repo.Id, attempt int64) ([]repo.LogLine, error)
 
 	Watch(ctx context.Context) (<-chan repo.Change, <-chan error)
+
+	Idempotent(ctx context.Context, scope, key string, fn func() ([]byte, error)) ([]byte, error)
+
+	InsertAuditEntry(ctx context.Context, e repo.AuditEntry) (id repo.Id, err error)
+	AuditPage(ctx context.Context, f repo.AuditFilter, from *repo.Id) (repo.AuditPage, error)
 }
 
 type v1 struct {
-	repo Repo
+	repo               Repo
+	userContentDir     string
+	maxUserContentSize int64
+	baselines          baseline.Resolver
 }
 
-func NewV1Handler(r Repo) http.Handler {
-	return v1{r}.newRouter()
+func NewV1Handler(r Repo, userContentDir string, maxUserContentSize int64,
+	baselineRules []baseline.Rule) http.Handler {
+	return v1{r, userContentDir, maxUserContentSize, baseline.Resolver{
+		Repo:  r,
+		Rules: baselineRules,
+		ProjectRules: func(ctx context.Context,
+			project string) ([]baseline.Rule, error) {
+			p, err := r.ProjectByName(ctx, project)
+			if isNotFound(err) {
+				return nil, nil
+			} else if err != nil {
+				return nil, err
+			}
+			return baseline.ProjectRules(p), nil
+		},
+	}}.newRouter()
 }
 
 func (v v1) newRouter() http.Handler {
@@ -48,36 +112,88 @@ func (v v1) newRouter() http.Handler {
 	r.NotFoundHandler = notFound()
 	r.MethodNotAllowedHandler = methodNotAllowed()
 
+	// audit
+	r.Handle("/audit", v.auditPageHandler()).
+		Methods(http.MethodGet, http.MethodHead)
+
 	// attachments
 	r.Handle("/attachments", findByIdHandler(func(ctx context.Context, id repo.Id) (interface{}, error) {
+		if err := v.authorizeSuite(ctx, id, repo.RoleViewer); err != nil {
+			return nil, err
+		}
 		return v.repo.SuiteAttachments(ctx, id)
 	})).
 		Queries("suite", "{id}").
 		Methods(http.MethodGet, http.MethodHead)
+	r.Handle("/attachments", findByAttemptHandler(func(ctx context.Context, id repo.Id, attempt int64) (interface{}, error) {
+		if err := v.authorizeCase(ctx, id, repo.RoleViewer); err != nil {
+			return nil, err
+		}
+		return v.repo.CaseAttemptAttachments(ctx, id, attempt)
+	})).
+		Queries("case", "{id}", "attempt", "{attempt}").
+		Methods(http.MethodGet, http.MethodHead)
 	r.Handle("/attachments", findByIdHandler(func(ctx context.Context, id repo.Id) (interface{}, error) {
+		if err := v.authorizeCase(ctx, id, repo.RoleViewer); err != nil {
+			return nil, err
+		}
 		return v.repo.CaseAttachments(ctx, id)
 	})).
 		Queries("case", "{id}").
 		Methods(http.MethodGet, http.MethodHead)
-	r.Handle("/attachments", findAllHandler(func(ctx context.Context) (interface{}, error) {
-		return v.repo.AllAttachments(ctx)
-	})).
+	r.Handle("/attachments", findAllHandler(v.allAttachments)).
 		Methods(http.MethodGet, http.MethodHead)
+	r.Handle("/attachments", v.insertAttachmentHandler()).
+		Methods(http.MethodPost)
 	r.Handle("/attachments/{id}", findByIdHandler(func(ctx context.Context, id repo.Id) (interface{}, error) {
-		return v.repo.Attachment(ctx, id)
+		a, err := v.repo.Attachment(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		return a, v.authorizeOwner(ctx, a.SuiteId, a.CaseId, repo.RoleViewer)
 	})).
 		Methods(http.MethodGet, http.MethodHead)
 
 	// suites
+	r.Handle("/suites/import", v.importSuiteHandler()).
+		Methods(http.MethodPost)
+	r.Handle("/suites/{id}/export", v.exportSuiteHandler()).
+		Methods(http.MethodGet, http.MethodHead)
+	r.Handle("/suites/{id}/compare/{head}", v.compareHandler()).
+		Methods(http.MethodGet, http.MethodHead)
+	r.Handle("/suites/{id}/logs", findByIdHandler(func(ctx context.Context, id repo.Id) (interface{}, error) {
+		if err := v.authorizeSuite(ctx, id, repo.RoleViewer); err != nil {
+			return nil, err
+		}
+		return v.repo.SuiteLogLines(ctx, id)
+	})).
+		Methods(http.MethodGet, http.MethodHead)
 	r.Handle("/suites/{id}/cases", findByIdHandler(func(ctx context.Context, id repo.Id) (interface{}, error) {
+		if err := v.authorizeSuite(ctx, id, repo.RoleViewer); err != nil {
+			return nil, err
+		}
 		return v.repo.SuiteCases(ctx, id)
 	})).
 		Methods(http.MethodGet, http.MethodHead)
 	r.Handle("/suites/{id}", v.finishSuiteHandler()).
 		Queries("finish", "true").
 		Methods(http.MethodPatch)
+	r.Handle("/suites/{id}", v.disconnectSuiteHandler()).
+		Queries("disconnect", "true").
+		Methods(http.MethodPatch)
+	r.Handle("/suites/{id}", v.restoreSuiteHandler()).
+		Queries("restore", "true").
+		Methods(http.MethodPatch)
+	r.Handle("/suites/{id}", v.mergePatchHandler(v.suitePatcher)).
+		Methods(http.MethodPatch)
+	r.Handle("/suites/{id}", v.deleteSuiteHandler()).
+		Methods(http.MethodDelete)
 	r.Handle("/suites/{id}", findByIdHandler(func(ctx context.Context, id repo.Id) (interface{}, error) {
-		return v.repo.Suite(ctx, id)
+		s, err := v.repo.Suite(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		return s, authorize(ctx, s.Project, repo.RoleViewer)
 	})).
 		Methods(http.MethodGet, http.MethodHead)
 	r.Handle("/suites", findHandler(func(r *http.Request) (interface{}, error) {
@@ -86,66 +202,326 @@ func (v v1) newRouter() http.Handler {
 		if err != nil {
 			return nil, err
 		}
-		return v.repo.SuitePageAfter(r.Context(), cursor)
+		f, err := suiteFilter(r)
+		if err != nil {
+			return nil, err
+		}
+		return v.repo.SuitePageAfter(r.Context(), f, cursor)
 	})).
 		Queries("from", "{cursor}").
 		Methods(http.MethodGet, http.MethodHead)
 	r.Handle("/suites", sse.NewMiddleware(v.watchHandler())).
 		Queries("watch", "true").
 		Methods(http.MethodGet, http.MethodHead)
-	r.Handle("/suites", findAllHandler(func(ctx context.Context) (interface{}, error) {
-		return v.repo.SuitePage(ctx)
+	r.Handle("/suites", findHandler(func(r *http.Request) (interface{}, error) {
+		f, err := suiteFilter(r)
+		if err != nil {
+			return nil, err
+		}
+		return v.repo.SuitePage(r.Context(), f)
 	})).
 		Methods(http.MethodGet, http.MethodHead)
 	r.Handle("/suites", v.insertSuiteHandler()).
 		Methods(http.MethodPost)
 
+	// tests
+	r.Handle("/tests/{fingerprint:[0-9a-f]{32}}/history", v.testHistoryHandler()).
+		Methods(http.MethodGet, http.MethodHead)
+	r.Handle("/flaky", v.flakyHandler()).
+		Methods(http.MethodGet, http.MethodHead)
+	r.Handle("/failures/clusters", v.failureClustersHandler()).
+		Methods(http.MethodGet, http.MethodHead)
+
+	// projects
+	r.Handle("/projects/{name}/summary", v.projectSummaryHandler()).
+		Methods(http.MethodGet, http.MethodHead)
+	r.Handle("/projects/{name}", v.mergePatchHandler(v.projectPatcher)).
+		Methods(http.MethodPatch)
+	r.Handle("/projects/{name}", v.projectHandler()).
+		Methods(http.MethodGet, http.MethodHead)
+	r.Handle("/projects", v.projectsHandler()).
+		Methods(http.MethodGet, http.MethodHead)
+
+	// quarantines
+	r.Handle("/quarantines/{id}", v.mergePatchHandler(v.quarantinePatcher)).
+		Methods(http.MethodPatch)
+	r.Handle("/quarantines/{id}", v.deleteQuarantineHandler()).
+		Methods(http.MethodDelete)
+	r.Handle("/quarantines/{id}", findByIdHandler(v.quarantine)).
+		Methods(http.MethodGet, http.MethodHead)
+	r.Handle("/quarantines", v.quarantinesHandler()).
+		Methods(http.MethodGet, http.MethodHead)
+	r.Handle("/quarantines", v.insertQuarantineHandler()).
+		Methods(http.MethodPost)
+
 	// cases
+	r.Handle("/cases/{id}/logs", findByAttemptHandler(func(ctx context.Context, id repo.Id, attempt int64) (interface{}, error) {
+		if err := v.authorizeCase(ctx, id, repo.RoleViewer); err != nil {
+			return nil, err
+		}
+		return v.repo.CaseAttemptLogLines(ctx, id, attempt)
+	})).
+		Queries("attempt", "{attempt}").
+		Methods(http.MethodGet, http.MethodHead)
 	r.Handle("/cases/{id}/logs", findByIdHandler(func(ctx context.Context, id repo.Id) (interface{}, error) {
+		if err := v.authorizeCase(ctx, id, repo.RoleViewer); err != nil {
+			return nil, err
+		}
 		return v.repo.CaseLogLines(ctx, id)
 	})).
 		Methods(http.MethodGet, http.MethodHead)
+	r.Handle("/cases/{id}/tree", findByIdHandler(v.caseSubtree)).
+		Methods(http.MethodGet, http.MethodHead)
 	r.Handle("/cases/{id}", v.finishCaseHandler()).
 		Queries("finish", "true").
 		Methods(http.MethodPatch)
+	r.Handle("/cases/{id}", v.retryCaseHandler()).
+		Queries("retry", "true").
+		Methods(http.MethodPatch)
+	r.Handle("/cases/{id}", v.mergePatchHandler(v.casePatcher)).
+		Methods(http.MethodPatch)
 	r.Handle("/cases/{id}", findByIdHandler(func(ctx context.Context, id repo.Id) (interface{}, error) {
-		return v.repo.Case(ctx, id)
+		c, err := v.repo.Case(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		return c, v.authorizeOwner(ctx, c.SuiteId, nil, repo.RoleViewer)
 	})).
 		Methods(http.MethodGet, http.MethodHead)
 	r.Handle("/cases", v.insertCaseHandler()).
 		Methods(http.MethodPost)
 
 	// logs
+	r.Handle("/logs", v.insertLogLinesHandler()).
+		Queries("batch", "true").
+		Methods(http.MethodPost)
 	r.Handle("/logs", v.insertLogLineHandler()).
 		Methods(http.MethodPost)
 	r.Handle("/logs/{id}", findByIdHandler(func(ctx context.Context, id repo.Id) (interface{}, error) {
-		return v.repo.LogLine(ctx, id)
+		ll, err := v.repo.LogLine(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		return ll, v.authorizeOwner(ctx, ll.SuiteId, ll.CaseId, repo.RoleViewer)
 	})).
 		Methods(http.MethodGet, http.MethodHead)
 
 	return r
 }
 
+func (v *v1) insertAttachmentHandler() errHandlerFunc {
+	return v.insertHandler(func(r *http.Request) (interface{}, error) {
+		mr, err := r.MultipartReader()
+		if err != nil {
+			return nil, errHttp{code: http.StatusUnsupportedMediaType, cause: err}
+		}
+		part, err := nextPart(mr, "meta")
+		if err != nil {
+			return nil, err
+		}
+		var a repo.Attachment
+		if err := json.NewDecoder(part).Decode(&a); err != nil {
+			return nil, errHttp{code: http.StatusBadRequest, cause: err}
+		}
+		errs := validate.Errors{}
+		validate.Insert(errs, "", a)
+		rs := v.newRefs()
+		err = rs.owner(r.Context(), errs, "", a.SuiteId, a.CaseId, true)
+		if err != nil {
+			return nil, err
+		}
+		a.Attempt = rs.attempt(errs, "attempt", a.CaseId, a.Attempt)
+		if err := errs.Err(); err != nil {
+			return nil, err
+		}
+		err = v.authorizeOwner(r.Context(), a.SuiteId, a.CaseId,
+			repo.RoleReporter)
+		if err != nil {
+			return nil, err
+		}
+		part, err = nextPart(mr, "file")
+		if err != nil {
+			return nil, err
+		}
+		f, err := ioutil.TempFile(v.userContentDir, ".upload-")
+		if err != nil {
+			return nil, err
+		}
+		defer os.Remove(f.Name())
+		n, err := io.Copy(f, io.LimitReader(part, v.maxUserContentSize+1))
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return nil, err
+		}
+		if n > v.maxUserContentSize {
+			return nil, errHttp{code: http.StatusRequestEntityTooLarge}
+		}
+		a.Size = &n
+		if a.Filename == nil {
+			a.Filename = repo.String(part.FileName())
+		}
+		if a.ContentType == nil {
+			a.ContentType = repo.String(part.Header.Get("content-type"))
+		}
+		id, err := v.repo.InsertAttachment(r.Context(), a)
+		if err != nil {
+			return nil, err
+		}
+		err = os.Rename(f.Name(), filepath.Join(v.userContentDir, id.String()))
+		if err != nil {
+			return nil, err
+		}
+		project, err := v.newProjects().ofOwner(r.Context(), a.SuiteId, a.CaseId)
+		if err != nil {
+			auditFailed(r, "insert", repo.Attachments, id, err)
+			return id, nil
+		}
+		a.Id = &id
+		v.audit(r, "insert", repo.Attachments, id, project, nil, a)
+		return id, nil
+	})
+}
+
+func (v *v1) allAttachments(ctx context.Context) (interface{}, error) {
+	as, err := v.repo.AllAttachments(ctx)
+	acc, ok := accessFrom(ctx)
+	if err != nil || !ok {
+		return as, err
+	}
+	ps := v.newProjects()
+	visible := []repo.Attachment{}
+	for _, a := range as {
+		project, err := ps.ofOwner(ctx, a.SuiteId, a.CaseId)
+		if isNotFound(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if acc.allows(project, repo.RoleViewer) {
+			visible = append(visible, a)
+		}
+	}
+	return visible, nil
+}
+
+func nextPart(mr *multipart.Reader, name string) (*multipart.Part, error) {
+	part, err := mr.NextPart()
+	if err != nil {
+		return nil, errHttp{code: http.StatusBadRequest, cause: err}
+	}
+	if part.FormName() != name {
+		return nil, errHttp{
+			error: fmt.Sprintf("want part %q, got %q", name, part.FormName()),
+			code:  http.StatusBadRequest,
+		}
+	}
+	return part, nil
+}
+
 func (v *v1) insertSuiteHandler() errHandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) error {
+	return v.insertHandler(func(r *http.Request) (interface{}, error) {
 		var s repo.Suite
 		if err := readJson(r, &s); err != nil {
-			return err
+			return nil, err
+		}
+		errs := validate.Errors{}
+		validate.Insert(errs, "", s)
+		if err := errs.Err(); err != nil {
+			return nil, err
+		}
+		if err := authorize(r.Context(), s.Project, repo.RoleReporter); err != nil {
+			return nil, err
 		}
 		id, err := v.repo.InsertSuite(r.Context(), s)
+		if err != nil {
+			return nil, err
+		}
+		s.Id = &id
+		v.audit(r, "insert", repo.Suites, id, s.Project, nil, s)
+		return id, nil
+	})
+}
+
+func (v *v1) exportSuiteHandler() errHandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		id, err := getIdVar(r)
+		if err != nil {
+			return err
+		}
+		if err := v.authorizeSuite(r.Context(), id, repo.RoleViewer); err != nil {
+			return err
+		}
+		setHeaders := func() {
+			w.Header().Set("content-disposition", fmt.Sprintf(
+				"attachment; filename=%q", "suite-"+id.String()+".tar.gz"))
+			w.Header().Set("content-type", "application/gzip")
+		}
+		if r.Method == http.MethodHead {
+			setHeaders()
+			return nil
+		}
+		// the archive is exported to a file first, so that an error can
+		// still be written instead of it
+		f, err := ioutil.TempFile("", "suiteserve-export-")
 		if err != nil {
 			return err
 		}
-		return writeJson(w, r, id)
+		defer os.Remove(f.Name())
+		defer f.Close()
+		err = bundle.Export(r.Context(), f, v.repo, v.userContentDir, id)
+		if err != nil {
+			return err
+		}
+		size, err := f.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		setHeaders()
+		w.Header().Set("content-length", strconv.FormatInt(size, 10))
+		if _, err := io.Copy(w, f); err != nil {
+			printLog(r, err)
+		}
+		return nil
 	}
 }
 
+func (v *v1) importSuiteHandler() errHandlerFunc {
+	return v.insertHandler(func(r *http.Request) (interface{}, error) {
+		if r.Header.Get("content-type") != "application/gzip" {
+			return nil, errHttp{code: http.StatusUnsupportedMediaType}
+		}
+		id, err := bundle.Import(r.Context(), r.Body, authorizedRepo{v.repo},
+			v.userContentDir, v.maxUserContentSize)
+		if isBadFormat(err) {
+			return nil, errHttp{code: http.StatusBadRequest, cause: err}
+		} else if err != nil {
+			return nil, err
+		}
+		s, err := v.repo.Suite(r.Context(), id)
+		if err != nil {
+			auditFailed(r, "import", repo.Suites, id, err)
+			return id, nil
+		}
+		v.audit(r, "import", repo.Suites, id, s.Project, nil, s)
+		return id, nil
+	})
+}
+
 func (v *v1) finishSuiteHandler() errHandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) error {
 		id, err := getIdVar(r)
 		if err != nil {
 			return err
 		}
+		versions, err := ifMatch(r)
+		if err != nil {
+			return err
+		}
 		var in struct {
 			Result repo.SuiteResult `json:"result"`
 			At     repo.MsTime      `json:"at"`
@@ -153,7 +529,84 @@ func (v *v1) finishSuiteHandler() errHandlerFunc {
 		if err := readJson(r, &in); err != nil {
 			return err
 		}
-		return v.repo.FinishSuite(r.Context(), id, in.Result, in.At)
+		if err := v.authorizeSuite(r.Context(), id, repo.RoleReporter); err != nil {
+			return err
+		}
+		err = v.auditSuite(r, "finish", id, func() error {
+			return v.repo.FinishSuite(r.Context(), id, versions, in.Result, in.At)
+		})
+		if err != nil {
+			return err
+		}
+		// the suite is finished whether or not its baseline resolves
+		if _, err := v.baselines.Resolve(r.Context(), id, in.At); err != nil {
+			printLog(r, fmt.Errorf("resolve baseline: %v", err))
+		}
+		return nil
+	}
+}
+
+func (v *v1) disconnectSuiteHandler() errHandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		id, err := getIdVar(r)
+		if err != nil {
+			return err
+		}
+		versions, err := ifMatch(r)
+		if err != nil {
+			return err
+		}
+		var in struct {
+			At repo.MsTime `json:"at"`
+		}
+		if err := readJson(r, &in); err != nil {
+			return err
+		}
+		if err := v.authorizeSuite(r.Context(), id, repo.RoleReporter); err != nil {
+			return err
+		}
+		return v.auditSuite(r, "disconnect", id, func() error {
+			return v.repo.DisconnectSuite(r.Context(), id, versions, in.At)
+		})
+	}
+}
+
+func (v *v1) deleteSuiteHandler() errHandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		id, err := getIdVar(r)
+		if err != nil {
+			return err
+		}
+		versions, err := ifMatch(r)
+		if err != nil {
+			return err
+		}
+		if err := v.authorizeSuite(r.Context(), id, repo.RoleAdmin); err != nil {
+			return err
+		}
+		return v.auditSuite(r, "delete", id, func() error {
+			return v.repo.DeleteSuite(r.Context(), id, versions,
+				repo.MsTime(time.Now()))
+		})
+	}
+}
+
+func (v *v1) restoreSuiteHandler() errHandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		id, err := getIdVar(r)
+		if err != nil {
+			return err
+		}
+		versions, err := ifMatch(r)
+		if err != nil {
+			return err
+		}
+		if err := v.authorizeSuite(r.Context(), id, repo.RoleAdmin); err != nil {
+			return err
+		}
+		return v.auditSuite(r, "restore", id, func() error {
+			return v.repo.RestoreSuite(r.Context(), id, versions)
+		})
 	}
 }
 
@@ -163,6 +616,10 @@ func (v *v1) finishCaseHandler() errHandlerFunc {
 		if err != nil {
 			return err
 		}
+		versions, err := ifMatch(r)
+		if err != nil {
+			return err
+		}
 		var in struct {
 			Result repo.CaseResult `json:"result"`
 			At     repo.MsTime     `json:"at"`
@@ -170,41 +627,152 @@ func (v *v1) finishCaseHandler() errHandlerFunc {
 		if err := readJson(r, &in); err != nil {
 			return err
 		}
-		return v.repo.FinishCase(r.Context(), id, in.Result, in.At)
+		if err := v.authorizeCase(r.Context(), id, repo.RoleReporter); err != nil {
+			return err
+		}
+		return v.auditCase(r, "finish", id, func() error {
+			return v.repo.FinishCase(r.Context(), id, versions, in.Result, in.At)
+		})
 	}
 }
 
-func (v *v1) insertCaseHandler() errHandlerFunc {
+func (v *v1) retryCaseHandler() errHandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) error {
-		var c repo.Case
-		if err := readJson(r, &c); err != nil {
+		id, err := getIdVar(r)
+		if err != nil {
 			return err
 		}
-		id, err := v.repo.InsertCase(r.Context(), c)
+		versions, err := ifMatch(r)
 		if err != nil {
 			return err
 		}
-		return writeJson(w, r, id)
+		var in struct {
+			At repo.MsTime `json:"at"`
+		}
+		if err := readJson(r, &in); err != nil {
+			return err
+		}
+		if err := v.authorizeCase(r.Context(), id, repo.RoleReporter); err != nil {
+			return err
+		}
+		return v.auditCase(r, "retry", id, func() error {
+			return v.repo.RetryCase(r.Context(), id, versions, in.At)
+		})
 	}
 }
 
+func (v *v1) insertCaseHandler() errHandlerFunc {
+	return v.insertHandler(func(r *http.Request) (interface{}, error) {
+		var c repo.Case
+		if err := readJson(r, &c); err != nil {
+			return nil, err
+		}
+		errs := validate.Errors{}
+		validate.Insert(errs, "", c)
+		rs := v.newRefs()
+		if c.SuiteId != nil {
+			err := rs.check(r.Context(), errs, "suiteId", repo.Suites,
+				*c.SuiteId, false)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if c.ParentId != nil {
+			err := rs.check(r.Context(), errs, "parentId", repo.Cases,
+				*c.ParentId, true)
+			if err != nil {
+				return nil, err
+			}
+			parent, err := v.repo.Case(r.Context(), *c.ParentId)
+			if err != nil && !isNotFound(err) {
+				return nil, err
+			}
+			if err == nil && (c.SuiteId == nil || parent.SuiteId == nil ||
+				*parent.SuiteId != *c.SuiteId) {
+				errs.Add("parentId", "refers to a case of another suite")
+			}
+		}
+		if err := errs.Err(); err != nil {
+			return nil, err
+		}
+		err := v.authorizeOwner(r.Context(), c.SuiteId, nil, repo.RoleReporter)
+		if err != nil {
+			return nil, err
+		}
+		return v.repo.InsertCase(r.Context(), c)
+	})
+}
+
 func (v *v1) insertLogLineHandler() errHandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) error {
+	return v.insertHandler(func(r *http.Request) (interface{}, error) {
 		var ll repo.LogLine
 		if err := readJson(r, &ll); err != nil {
-			return err
+			return nil, err
 		}
-		id, err := v.repo.InsertLogLine(r.Context(), ll)
+		errs := validate.Errors{}
+		validate.Insert(errs, "", ll)
+		rs := v.newRefs()
+		err := rs.owner(r.Context(), errs, "", ll.SuiteId, ll.CaseId, false)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		return writeJson(w, r, id)
-	}
+		ll.Attempt = rs.attempt(errs, "attempt", ll.CaseId, ll.Attempt)
+		if err := errs.Err(); err != nil {
+			return nil, err
+		}
+		err = v.authorizeOwner(r.Context(), ll.SuiteId, ll.CaseId,
+			repo.RoleReporter)
+		if err != nil {
+			return nil, err
+		}
+		return v.repo.InsertLogLine(r.Context(), ll)
+	})
+}
+
+func (v *v1) insertLogLinesHandler() errHandlerFunc {
+	return v.insertHandler(func(r *http.Request) (interface{}, error) {
+		var lls []repo.LogLine
+		if err := readJson(r, &lls); err != nil {
+			return nil, err
+		}
+		errs := validate.Errors{}
+		rs := v.newRefs()
+		for i, ll := range lls {
+			prefix := "[" + strconv.Itoa(i) + "]."
+			validate.Insert(errs, prefix, ll)
+			err := rs.owner(r.Context(), errs, prefix, ll.SuiteId, ll.CaseId, false)
+			if err != nil {
+				return nil, err
+			}
+			lls[i].Attempt = rs.attempt(errs, prefix+"attempt", ll.CaseId,
+				ll.Attempt)
+		}
+		if err := errs.Err(); err != nil {
+			return nil, err
+		}
+		if _, ok := accessFrom(r.Context()); ok {
+			ps := v.newProjects()
+			for _, ll := range lls {
+				project, err := ps.ofOwner(r.Context(), ll.SuiteId, ll.CaseId)
+				if err != nil {
+					return nil, err
+				}
+				err = authorize(r.Context(), project, repo.RoleReporter)
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+		return v.repo.InsertLogLines(r.Context(), lls)
+	})
 }
 
 func (v *v1) watchHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		changeCh, errCh := v.repo.Watch(r.Context())
+		if acc, ok := accessFrom(r.Context()); ok {
+			changeCh = v.filterChanges(r.Context(), acc, changeCh)
+		}
 		for evts := range changesToSSE(changeCh) {
 			if _, err := sse.Send(w, evts...); err != nil {
 				printLog(r, err)
@@ -218,6 +786,24 @@ func (v *v1) watchHandler() http.HandlerFunc {
 	}
 }
 
+// filterChanges passes on the changes to what acc may view.
+func (v *v1) filterChanges(ctx context.Context, acc access,
+	ch <-chan repo.Change) <-chan repo.Change {
+	out := make(chan repo.Change)
+	go func() {
+		defer close(out)
+		ps := v.newProjects()
+		for c := range ch {
+			project, err := ps.ofChange(ctx, c)
+			if err != nil || !acc.allows(project, repo.RoleViewer) {
+				continue
+			}
+			out <- c
+		}
+	}()
+	return out
+}
+
 func changesToSSE(ch <-chan repo.Change) <-chan []sse.Event {
 	out := make(chan []sse.Event)
 	go func() {
@@ -246,12 +832,54 @@ func changesToSSE(ch <-chan repo.Change) <-chan []sse.Event {
 	return out
 }
 
+// insertHandler returns a handler that writes the result of fn. If the request
+// has an Idempotency-Key header, fn is called at most once for the key and the
+// path, and the first response is written again for retried requests.
+func (v *v1) insertHandler(fn func(r *http.Request) (interface{}, error)) errHandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		key := r.Header.Get("idempotency-key")
+		if key == "" {
+			res, err := fn(r)
+			if err != nil {
+				return err
+			}
+			return writeJson(w, r, res)
+		}
+		scope := r.Method + " " + r.URL.RequestURI()
+		if t, ok := tokenFrom(r.Context()); ok {
+			scope = t.Id.String() + " " + scope
+		}
+		b, err := v.repo.Idempotent(r.Context(), scope, key, func() ([]byte, error) {
+			res, err := fn(r)
+			if err != nil {
+				return nil, err
+			}
+			return json.Marshal(res)
+		})
+		if err != nil {
+			return err
+		}
+		return writeJsonBytes(w, r, b)
+	}
+}
+
+// findHandler returns a handler that writes the result of fn. If the result is
+// versioned, its version is sent as an ETag, and Not Modified is sent instead
+// if the request already has it.
 func findHandler(fn func(r *http.Request) (interface{}, error)) errHandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) error {
 		v, err := fn(r)
 		if err != nil {
 			return err
 		}
+		if ver, ok := v.(versioned); ok {
+			tag := etag(ver.CurrentVersion())
+			w.Header().Set("etag", tag)
+			if noneMatch(r, tag) {
+				w.WriteHeader(http.StatusNotModified)
+				return nil
+			}
+		}
 		return writeJson(w, r, v)
 	}
 }
@@ -262,6 +890,22 @@ func findAllHandler(fn func(ctx context.Context) (interface{}, error)) errHandle
 	})
 }
 
+// findByAttemptHandler is like findByIdHandler for an attempt of a case in
+// the attempt query parameter.
+func findByAttemptHandler(fn func(ctx context.Context, id repo.Id, attempt int64) (interface{}, error)) errHandlerFunc {
+	return findHandler(func(r *http.Request) (interface{}, error) {
+		id, err := getIdVar(r)
+		if err != nil {
+			return nil, err
+		}
+		attempt, err := intQuery(r, "attempt", 1, math.MaxInt32)
+		if err != nil {
+			return nil, err
+		}
+		return fn(r.Context(), id, int64(attempt))
+	})
+}
+
 func findByIdHandler(fn func(ctx context.Context, id repo.Id) (interface{}, error)) errHandlerFunc {
 	return findHandler(func(r *http.Request) (interface{}, error) {
 		id, err := getIdVar(r)
@@ -0,0 +1,95 @@
+package api
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/suiteserve/suiteserve/internal/repo"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func (r *fakeRepo) SuiteCases(context.Context, repo.Id) ([]repo.Case, error) {
+	return nil, nil
+}
+
+func (r *fakeRepo) SuiteLogLines(context.Context,
+	repo.Id) ([]repo.LogLine, error) {
+	return nil, nil
+}
+
+// SuiteAttachments returns one attachment, whose blob is at the id of the
+// suite.
+func (r *fakeRepo) SuiteAttachments(_ context.Context,
+	suiteId repo.Id) ([]repo.Attachment, error) {
+	return []repo.Attachment{{
+		Entity:  repo.Entity{Id: &suiteId},
+		SuiteId: &suiteId,
+	}}, nil
+}
+
+func serveExport(t *testing.T, dir string, id repo.Id,
+	r *fakeRepo) *httptest.ResponseRecorder {
+	v := v1{repo: r, userContentDir: dir}
+	req := httptest.NewRequest(http.MethodGet,
+		"/suites/"+id.String()+"/export", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": id.String()})
+	req = req.WithContext(context.WithValue(req.Context(), accessKey{},
+		access{"api": repo.RoleViewer}))
+	w := httptest.NewRecorder()
+	v.exportSuiteHandler().ServeHTTP(w, req)
+	return w
+}
+
+func TestV1_ExportSuiteHandler(t *testing.T) {
+	dir := t.TempDir()
+	id := newId()
+	r := fakeRepo{suites: map[repo.Id]repo.Suite{
+		id: {Entity: repo.Entity{Id: &id}, Project: str("api")},
+	}}
+	require.Nil(t, ioutil.WriteFile(filepath.Join(dir, id.String()),
+		[]byte("blob"), 0644))
+
+	w := serveExport(t, dir, id, &r)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/gzip", w.Header().Get("content-type"))
+	assert.Contains(t, w.Header().Get("content-disposition"), id.String())
+	gr, err := gzip.NewReader(w.Body)
+	require.Nil(t, err)
+	tr := tar.NewReader(gr)
+	var names []string
+	for {
+		h, err := tr.Next()
+		if err != nil {
+			break
+		}
+		names = append(names, h.Name)
+	}
+	assert.Contains(t, names, "blobs/"+id.String())
+}
+
+func TestV1_ExportSuiteHandler_Failed(t *testing.T) {
+	dir := t.TempDir()
+	id := newId()
+	r := fakeRepo{suites: map[repo.Id]repo.Suite{
+		id: {Entity: repo.Entity{Id: &id}, Project: str("api")},
+	}}
+	// a blob that can't be read fails the export after the entries before it
+	require.Nil(t, os.Mkdir(filepath.Join(dir, id.String()), 0755))
+
+	w := serveExport(t, dir, id, &r)
+	require.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, "application/problem+json", w.Header().Get("content-type"))
+	assert.Empty(t, w.Header().Get("content-disposition"))
+	var p problem
+	require.Nil(t, json.Unmarshal(w.Body.Bytes(), &p))
+	assert.Equal(t, "internal", p.Code)
+}
@@ -0,0 +1,131 @@
+package api
+
+import (
+	"context"
+	"github.com/suiteserve/suiteserve/internal/repo"
+	"github.com/suiteserve/suiteserve/internal/validate"
+)
+
+type refState int
+
+const (
+	refOk refState = iota
+	refMissing
+	refFinished
+	refDeleted
+)
+
+// refs checks that the suites and cases that entities refer to exist and are
+// neither finished nor deleted, remembering what it finds for batches of
+// entities.
+type refs struct {
+	repo   Repo
+	states map[repo.Id]refState
+	// attempts has the latest attempt of each case found.
+	attempts map[repo.Id]int64
+}
+
+func (v *v1) newRefs() *refs {
+	return &refs{
+		repo:     v.repo,
+		states:   map[repo.Id]refState{},
+		attempts: map[repo.Id]int64{},
+	}
+}
+
+func (rs *refs) state(ctx context.Context, coll repo.Coll,
+	id repo.Id) (refState, error) {
+	if st, ok := rs.states[id]; ok {
+		return st, nil
+	}
+	var finished, deleted bool
+	var err error
+	switch coll {
+	case repo.Suites:
+		var s repo.Suite
+		s, err = rs.repo.Suite(ctx, id)
+		finished = s.Status != nil && *s.Status == repo.SuiteStatusFinished
+		deleted = s.DeletedAt != nil
+	case repo.Cases:
+		var c repo.Case
+		c, err = rs.repo.Case(ctx, id)
+		finished = c.Status != nil && *c.Status == repo.CaseStatusFinished
+		rs.attempts[id] = c.CurrentAttempt()
+	}
+	st := refOk
+	if isNotFound(err) {
+		st = refMissing
+	} else if err != nil {
+		return refOk, err
+	} else if deleted {
+		st = refDeleted
+	} else if finished {
+		st = refFinished
+	}
+	rs.states[id] = st
+	return st, nil
+}
+
+// check adds an error for field if the suite or case with the given id does
+// not exist, is deleted, or is finished and allowFinished is false.
+func (rs *refs) check(ctx context.Context, errs validate.Errors, field string,
+	coll repo.Coll, id repo.Id, allowFinished bool) error {
+	st, err := rs.state(ctx, coll, id)
+	if err != nil {
+		return err
+	}
+	name := "suite"
+	if coll == repo.Cases {
+		name = "case"
+	}
+	switch {
+	case st == refMissing:
+		errs.Add(field, "refers to a %s that does not exist", name)
+	case st == refDeleted:
+		errs.Add(field, "refers to a %s that is deleted", name)
+	case st == refFinished && !allowFinished:
+		errs.Add(field, "refers to a %s that is finished", name)
+	}
+	return nil
+}
+
+// owner checks that exactly one of the suiteId and caseId fields is set, and
+// what it refers to.
+func (rs *refs) owner(ctx context.Context, errs validate.Errors, prefix string,
+	suiteId, caseId *repo.Id, allowFinished bool) error {
+	switch {
+	case suiteId != nil && caseId != nil:
+		errs.Add(prefix+"caseId", "cannot be set with suiteId")
+	case suiteId != nil:
+		return rs.check(ctx, errs, prefix+"suiteId", repo.Suites, *suiteId,
+			allowFinished)
+	case caseId != nil:
+		return rs.check(ctx, errs, prefix+"caseId", repo.Cases, *caseId,
+			allowFinished)
+	default:
+		errs.Add(prefix+"caseId", "is required without suiteId")
+	}
+	return nil
+}
+
+// attempt checks the attempt of the case with the given id that an entity
+// refers to, and returns it, or the latest attempt if there is none. The case
+// must have been checked before.
+func (rs *refs) attempt(errs validate.Errors, field string, caseId *repo.Id,
+	attempt *int64) *int64 {
+	if caseId == nil {
+		if attempt != nil {
+			errs.Add(field, "cannot be set without caseId")
+		}
+		return attempt
+	}
+	latest, ok := rs.attempts[*caseId]
+	switch {
+	case !ok:
+	case attempt == nil:
+		return &latest
+	case *attempt < 1 || *attempt > latest:
+		errs.Add(field, "refers to an attempt that has not started")
+	}
+	return attempt
+}
@@ -0,0 +1,215 @@
+// Package baseline resolves the baseline of a finished suite, the earlier suite
+// it is automatically compared with, by the rules of its project.
+package baseline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/suiteserve/suiteserve/internal/compare"
+	"github.com/suiteserve/suiteserve/internal/repo"
+	"sort"
+	"strings"
+)
+
+// MaxRegressions is how many names of newly failing tests a regression
+// summary has.
+const MaxRegressions = 20
+
+// Rule selects the baseline of the suites of a project, or of any project if
+// Project is nil: the latest finished suite of the same project that started
+// before the suite, has the Labels, has the same values as the suite of the
+// SameLabels and, if SameTags, has the same tags as the suite.
+type Rule struct {
+	Project    *string
+	Labels     map[string]string
+	SameLabels []string
+	SameTags   bool
+}
+
+func (r Rule) String() string {
+	s := "the latest finished suite"
+	if len(r.Labels) > 0 {
+		var ls []string
+		for _, k := range sortedKeys(r.Labels) {
+			ls = append(ls, fmt.Sprintf("%s=%q", k, r.Labels[k]))
+		}
+		s += " with " + strings.Join(ls, ", ")
+	}
+	var same []string
+	if len(r.SameLabels) > 0 {
+		same = append(same, "labels "+strings.Join(r.SameLabels, ", "))
+	}
+	if r.SameTags {
+		same = append(same, "tags")
+	}
+	if len(same) > 0 {
+		s += " with the same " + strings.Join(same, " and ")
+	}
+	if r.Project != nil {
+		s += fmt.Sprintf(" of project %q", *r.Project)
+	}
+	return s
+}
+
+// selector returns the selector of the baseline of s, and false if the rule
+// doesn't apply to s.
+func (r Rule) selector(s repo.Suite) (repo.BaselineSelector, bool) {
+	if r.Project != nil && (s.Project == nil || *s.Project != *r.Project) {
+		return repo.BaselineSelector{}, false
+	}
+	if s.Id == nil || s.StartedAt == nil {
+		return repo.BaselineSelector{}, false
+	}
+	sel := repo.BaselineSelector{
+		Project: s.Project,
+		Labels:  map[string]string{},
+		Before:  *s.StartedAt,
+		Exclude: *s.Id,
+	}
+	for k, v := range r.Labels {
+		sel.Labels[k] = v
+	}
+	for _, k := range r.SameLabels {
+		v, ok := s.Labels[k]
+		if !ok {
+			return repo.BaselineSelector{}, false
+		}
+		sel.Labels[k] = v
+	}
+	if r.SameTags {
+		sel.Tags = append([]string{}, s.Tags...)
+	}
+	return sel, true
+}
+
+// ProjectRules returns the rule of the project, if it overrides the baseline
+// rules for its suites.
+func ProjectRules(p repo.Project) []Rule {
+	b := p.Baseline
+	if p.Name == nil || b == nil {
+		return nil
+	}
+	project := *p.Name
+	return []Rule{{
+		Project:    &project,
+		Labels:     b.Labels,
+		SameLabels: b.SameLabels,
+		SameTags:   b.SameTags != nil && *b.SameTags,
+	}}
+}
+
+type Repo interface {
+	Suite(ctx context.Context, id repo.Id) (repo.Suite, error)
+	LatestBaseline(ctx context.Context, sel repo.BaselineSelector) (repo.Suite, error)
+	SuiteCases(ctx context.Context, suiteId repo.Id) ([]repo.Case, error)
+	SetSuiteBaseline(ctx context.Context, id, baselineId repo.Id) error
+	InsertRegressionSummary(ctx context.Context, s repo.RegressionSummary) (repo.Id, error)
+}
+
+// Resolver resolves baselines by the first of its rules that applies to a
+// suite.
+type Resolver struct {
+	Repo  Repo
+	Rules []Rule
+	// ProjectRules, if set, returns the rules that apply before Rules to the
+	// suites of a project, such as the rule of the project that overrides
+	// them.
+	ProjectRules func(ctx context.Context, project string) ([]Rule, error)
+}
+
+// Resolve resolves the baseline of the finished suite with the given id,
+// stores it on the suite and records how the suite compares with it. It
+// returns nil if no rule applies to the suite or there is no baseline.
+func (r *Resolver) Resolve(ctx context.Context, id repo.Id,
+	at repo.MsTime) (*repo.RegressionSummary, error) {
+	if len(r.Rules) == 0 && r.ProjectRules == nil {
+		return nil, nil
+	}
+	s, err := r.Repo.Suite(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	rules := r.Rules
+	if r.ProjectRules != nil && s.Project != nil {
+		projectRules, err := r.ProjectRules(ctx, *s.Project)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(projectRules, rules...)
+	}
+	var sel repo.BaselineSelector
+	ok := false
+	for _, rule := range rules {
+		if sel, ok = rule.selector(s); ok {
+			break
+		}
+	}
+	if !ok {
+		return nil, nil
+	}
+	b, err := r.Repo.LatestBaseline(ctx, sel)
+	if isNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := r.Repo.SetSuiteBaseline(ctx, id, *b.Id); err != nil {
+		return nil, err
+	}
+	base, err := r.Repo.SuiteCases(ctx, *b.Id)
+	if err != nil {
+		return nil, err
+	}
+	head, err := r.Repo.SuiteCases(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	sum := summarize(compare.Suites(*b.Id, base, id, head))
+	sum.Project = s.Project
+	sum.At = at
+	sumId, err := r.Repo.InsertRegressionSummary(ctx, sum)
+	if err != nil {
+		return nil, err
+	}
+	sum.Id = &sumId
+	return &sum, nil
+}
+
+func summarize(rep compare.Report) repo.RegressionSummary {
+	sum := repo.RegressionSummary{
+		SuiteId:      rep.HeadId,
+		BaselineId:   rep.BaseId,
+		NewlyFailing: len(rep.NewlyFailing),
+		NewlyPassing: len(rep.NewlyPassing),
+		StillFailing: len(rep.StillFailing),
+		Added:        len(rep.Added),
+		Removed:      len(rep.Removed),
+		Slower:       len(rep.Slower),
+	}
+	for _, e := range rep.NewlyFailing {
+		if len(sum.Regressions) == MaxRegressions {
+			break
+		}
+		if e.Name != nil {
+			sum.Regressions = append(sum.Regressions, *e.Name)
+		}
+	}
+	return sum
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func isNotFound(err error) bool {
+	var errNotFound interface {
+		NotFound()
+	}
+	return errors.As(err, &errNotFound)
+}
@@ -0,0 +1,283 @@
+package baseline_test
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/suiteserve/suiteserve/internal/baseline"
+	"github.com/suiteserve/suiteserve/internal/repo"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"sort"
+	"testing"
+	"time"
+)
+
+type errNotFound struct{}
+
+func (errNotFound) Error() string {
+	return "not found"
+}
+
+func (errNotFound) NotFound() {}
+
+type memRepo struct {
+	suites    []repo.Suite
+	cases     map[repo.Id][]repo.Case
+	baselines map[repo.Id]repo.Id
+	summaries []repo.RegressionSummary
+}
+
+func newMemRepo() *memRepo {
+	return &memRepo{
+		cases:     map[repo.Id][]repo.Case{},
+		baselines: map[repo.Id]repo.Id{},
+	}
+}
+
+func (m *memRepo) Suite(_ context.Context, id repo.Id) (repo.Suite, error) {
+	for _, s := range m.suites {
+		if *s.Id == id {
+			return s, nil
+		}
+	}
+	return repo.Suite{}, errNotFound{}
+}
+
+func (m *memRepo) LatestBaseline(_ context.Context, sel repo.BaselineSelector) (repo.Suite, error) {
+	var ss []repo.Suite
+	for _, s := range m.suites {
+		if *s.Id == sel.Exclude || *s.Status != repo.SuiteStatusFinished ||
+			!time.Time(*s.StartedAt).Before(time.Time(sel.Before)) ||
+			(sel.Project == nil) != (s.Project == nil) ||
+			sel.Project != nil && *sel.Project != *s.Project {
+			continue
+		}
+		ok := true
+		for k, v := range sel.Labels {
+			ok = ok && s.Labels[k] == v
+		}
+		if sel.Tags != nil {
+			ok = ok && assert.ObjectsAreEqual(sorted(sel.Tags), sorted(s.Tags))
+		}
+		if ok {
+			ss = append(ss, s)
+		}
+	}
+	if len(ss) == 0 {
+		return repo.Suite{}, errNotFound{}
+	}
+	sort.Slice(ss, func(i, j int) bool {
+		return time.Time(*ss[i].StartedAt).After(time.Time(*ss[j].StartedAt))
+	})
+	return ss[0], nil
+}
+
+func (m *memRepo) SuiteCases(_ context.Context, suiteId repo.Id) ([]repo.Case, error) {
+	return m.cases[suiteId], nil
+}
+
+func (m *memRepo) SetSuiteBaseline(_ context.Context, id, baselineId repo.Id) error {
+	m.baselines[id] = baselineId
+	return nil
+}
+
+func (m *memRepo) InsertRegressionSummary(_ context.Context, s repo.RegressionSummary) (repo.Id, error) {
+	m.summaries = append(m.summaries, s)
+	return newId(), nil
+}
+
+func sorted(ss []string) []string {
+	out := append([]string{}, ss...)
+	sort.Strings(out)
+	if out == nil {
+		out = []string{}
+	}
+	return out
+}
+
+func newId() repo.Id {
+	return repo.Id(primitive.NewObjectID())
+}
+
+func (m *memRepo) addSuite(project string, startedMin int, labels map[string]string,
+	tags []string, results map[string]repo.CaseResult) repo.Id {
+	id := newId()
+	status := repo.SuiteStatusFinished
+	started := repo.MsTime(time.Unix(1600000000, 0).Add(time.Duration(startedMin) * time.Minute))
+	m.suites = append(m.suites, repo.Suite{
+		Entity:    repo.Entity{Id: &id},
+		Project:   &project,
+		Labels:    labels,
+		Tags:      tags,
+		Status:    &status,
+		StartedAt: &started,
+	})
+	for name, res := range results {
+		name, res := name, res
+		caseId := newId()
+		fp := repo.Fingerprint(&project, &name, nil)
+		m.cases[id] = append(m.cases[id], repo.Case{
+			Entity:      repo.Entity{Id: &caseId},
+			SuiteId:     &id,
+			Name:        &name,
+			Fingerprint: &fp,
+			Result:      &res,
+		})
+	}
+	return id
+}
+
+func TestResolve(t *testing.T) {
+	const (
+		passed = repo.CaseResultPassed
+		failed = repo.CaseResultFailed
+	)
+	m := newMemRepo()
+	main := map[string]string{"branch": "main"}
+	old := m.addSuite("api", 0, main, []string{"linux"}, nil)
+	base := m.addSuite("api", 1, main, []string{"linux"}, map[string]repo.CaseResult{
+		"a": passed,
+		"b": failed,
+		"c": passed,
+	})
+	m.addSuite("api", 2, main, []string{"windows"}, nil)
+	m.addSuite("api", 3, map[string]string{"branch": "dev"}, []string{"linux"}, nil)
+	m.addSuite("web", 4, main, []string{"linux"}, nil)
+	head := m.addSuite("api", 5, map[string]string{"branch": "fix"},
+		[]string{"linux"}, map[string]repo.CaseResult{
+			"a": failed,
+			"b": passed,
+			"c": passed,
+			"d": passed,
+		})
+	m.addSuite("api", 6, main, []string{"linux"}, nil)
+
+	r := baseline.Resolver{
+		Repo: m,
+		Rules: []baseline.Rule{{
+			Labels:   main,
+			SameTags: true,
+		}},
+	}
+	at := repo.MsTime(time.Unix(1700000000, 0))
+	sum, err := r.Resolve(context.Background(), head, at)
+	require.Nil(t, err)
+	require.NotNil(t, sum)
+	assert.Equal(t, base, m.baselines[head])
+	assert.NotEqual(t, old, sum.BaselineId)
+	assert.Equal(t, base, sum.BaselineId)
+	assert.Equal(t, head, sum.SuiteId)
+	assert.Equal(t, "api", *sum.Project)
+	assert.Equal(t, 1, sum.NewlyFailing)
+	assert.Equal(t, 1, sum.NewlyPassing)
+	assert.Equal(t, 1, sum.Added)
+	assert.Equal(t, []string{"a"}, sum.Regressions)
+	assert.Equal(t, at, sum.At)
+	require.Len(t, m.summaries, 1)
+}
+
+func TestResolve_SameLabels(t *testing.T) {
+	m := newMemRepo()
+	base := m.addSuite("api", 0, map[string]string{"os": "linux"}, nil, nil)
+	m.addSuite("api", 1, map[string]string{"os": "mac"}, nil, nil)
+	head := m.addSuite("api", 2, map[string]string{"os": "linux"}, nil, nil)
+	unlabeled := m.addSuite("api", 3, nil, nil, nil)
+
+	web := "web"
+	r := baseline.Resolver{
+		Repo: m,
+		Rules: []baseline.Rule{
+			{Project: &web},
+			{SameLabels: []string{"os"}},
+		},
+	}
+	sum, err := r.Resolve(context.Background(), head, repo.MsTime{})
+	require.Nil(t, err)
+	require.NotNil(t, sum)
+	assert.Equal(t, base, sum.BaselineId)
+
+	sum, err = r.Resolve(context.Background(), unlabeled, repo.MsTime{})
+	require.Nil(t, err)
+	assert.Nil(t, sum)
+	assert.NotContains(t, m.baselines, unlabeled)
+}
+
+func TestResolve_NoBaseline(t *testing.T) {
+	m := newMemRepo()
+	head := m.addSuite("api", 0, nil, nil, nil)
+	r := baseline.Resolver{Repo: m, Rules: []baseline.Rule{{}}}
+	sum, err := r.Resolve(context.Background(), head, repo.MsTime{})
+	require.Nil(t, err)
+	assert.Nil(t, sum)
+	assert.Empty(t, m.summaries)
+}
+
+func TestRule_String(t *testing.T) {
+	api := "api"
+	r := baseline.Rule{
+		Project:    &api,
+		Labels:     map[string]string{"branch": "main"},
+		SameLabels: []string{"os"},
+		SameTags:   true,
+	}
+	assert.Equal(t, `the latest finished suite with branch="main" with the `+
+		`same labels os and tags of project "api"`, r.String())
+}
+
+func TestResolve_ProjectRules(t *testing.T) {
+	m := newMemRepo()
+	main := map[string]string{"branch": "main"}
+	tagged := m.addSuite("api", 0, main, []string{"linux"}, nil)
+	latest := m.addSuite("api", 1, main, nil, nil)
+	apiHead := m.addSuite("api", 2, nil, []string{"linux"}, nil)
+	webBase := m.addSuite("web", 3, main, nil, nil)
+	webHead := m.addSuite("web", 4, nil, []string{"linux"}, nil)
+
+	var projects []string
+	r := baseline.Resolver{
+		Repo:  m,
+		Rules: []baseline.Rule{{Labels: main}},
+		ProjectRules: func(_ context.Context,
+			project string) ([]baseline.Rule, error) {
+			projects = append(projects, project)
+			name := "api"
+			sameTags := true
+			return baseline.ProjectRules(repo.Project{
+				Name: &name,
+				Baseline: &repo.ProjectBaseline{
+					Labels:   main,
+					SameTags: &sameTags,
+				},
+			}), nil
+		},
+	}
+	sum, err := r.Resolve(context.Background(), apiHead, repo.MsTime{})
+	require.Nil(t, err)
+	require.NotNil(t, sum)
+	assert.Equal(t, tagged, sum.BaselineId)
+	assert.NotEqual(t, latest, sum.BaselineId)
+
+	// the rule of api doesn't apply to web
+	sum, err = r.Resolve(context.Background(), webHead, repo.MsTime{})
+	require.Nil(t, err)
+	require.NotNil(t, sum)
+	assert.Equal(t, webBase, sum.BaselineId)
+	assert.Equal(t, []string{"api", "web"}, projects)
+}
+
+func TestProjectRules(t *testing.T) {
+	name := "api"
+	assert.Empty(t, baseline.ProjectRules(repo.Project{Name: &name}))
+	assert.Empty(t, baseline.ProjectRules(repo.Project{
+		Baseline: &repo.ProjectBaseline{},
+	}))
+	rules := baseline.ProjectRules(repo.Project{
+		Name: &name,
+		Baseline: &repo.ProjectBaseline{
+			SameLabels: []string{"os"},
+		},
+	})
+	require.Len(t, rules, 1)
+	assert.Equal(t, `the latest finished suite with the same labels os `+
+		`of project "api"`, rules[0].String())
+}
@@ -0,0 +1,525 @@
+// Package bundle reads and writes portable suite archives. An archive is a
+// gzip-compressed tar stream holding a manifest, the suite, its cases, log lines
+// and attachments as newline-delimited JSON, and the attachment blobs, in that
+// order:
+//
+//	manifest.json
+//	suite.json
+//	cases.ndjson
+//	logs.ndjson
+//	attachments.ndjson
+//	blobs/<attachment id>
+//
+// Ids in an archive are those of the exporting instance. Import assigns new ids
+// and rewrites every reference, while preserving indexes and timestamps. Cases
+// come after their parents. An imported suite is hidden as deleted until all of
+// it is imported, and purged if that fails.
+package bundle
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/suiteserve/suiteserve/internal/repo"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Version is the archive format version written by Export. Import rejects
+// archives of any other version.
+const Version = 1
+
+const (
+	manifestName    = "manifest.json"
+	suiteName       = "suite.json"
+	casesName       = "cases.ndjson"
+	logsName        = "logs.ndjson"
+	attachmentsName = "attachments.ndjson"
+	blobsDir        = "blobs/"
+)
+
+// cleanUpTimeout is how long a failed import has to remove what it imported.
+const cleanUpTimeout = time.Minute
+
+type Manifest struct {
+	Version    int         `json:"version"`
+	SuiteId    repo.Id     `json:"suiteId"`
+	ExportedAt repo.MsTime `json:"exportedAt"`
+}
+
+type Repo interface {
+	InsertAttachment(ctx context.Context, a repo.Attachment) (id repo.Id, err error)
+	SuiteAttachments(ctx context.Context, suiteId repo.Id) ([]repo.Attachment, error)
+	CaseAttachments(ctx context.Context, caseId repo.Id) ([]repo.Attachment, error)
+
+	InsertSuite(ctx context.Context, s repo.Suite) (id repo.Id, err error)
+	Suite(ctx context.Context, id repo.Id) (repo.Suite, error)
+	RestoreSuite(ctx context.Context, id repo.Id, versions []int64) error
+	PurgeSuite(ctx context.Context, id repo.Id) error
+
+	InsertCase(ctx context.Context, c repo.Case) (id repo.Id, err error)
+	SuiteCases(ctx context.Context, suiteId repo.Id) ([]repo.Case, error)
+
+	InsertLogLine(ctx context.Context, ll repo.LogLine) (id repo.Id, err error)
+	SuiteLogLines(ctx context.Context, suiteId repo.Id) ([]repo.LogLine, error)
+	CaseLogLines(ctx context.Context, caseId repo.Id) ([]repo.LogLine, error)
+}
+
+type errBadArchive struct {
+	error
+}
+
+func (e errBadArchive) Error() string {
+	return fmt.Sprintf("bad archive: %v", e.error)
+}
+
+func (e errBadArchive) Unwrap() error {
+	return e.error
+}
+
+func (e errBadArchive) BadFormat() {}
+
+// Export writes the suite with the given id, along with its cases, log lines,
+// attachments and the attachment blobs found in blobDir, to w. If it fails
+// after writing to w, what it wrote is truncated, which Import rejects.
+func Export(ctx context.Context, w io.Writer, r Repo, blobDir string,
+	suiteId repo.Id) error {
+	s, err := r.Suite(ctx, suiteId)
+	if err != nil {
+		return err
+	}
+	cs, err := r.SuiteCases(ctx, suiteId)
+	if err != nil {
+		return err
+	}
+	// parents are imported before their children
+	sort.SliceStable(cs, func(i, j int) bool {
+		return len(cs[i].Ancestors) < len(cs[j].Ancestors)
+	})
+	lls, err := r.SuiteLogLines(ctx, suiteId)
+	if err != nil {
+		return err
+	}
+	as, err := r.SuiteAttachments(ctx, suiteId)
+	if err != nil {
+		return err
+	}
+	for _, c := range cs {
+		caseLls, err := r.CaseLogLines(ctx, *c.Id)
+		if err != nil {
+			return err
+		}
+		lls = append(lls, caseLls...)
+		caseAs, err := r.CaseAttachments(ctx, *c.Id)
+		if err != nil {
+			return err
+		}
+		as = append(as, caseAs...)
+	}
+
+	// the archive is only closed if all of it is written, so that a failed
+	// export is truncated rather than a valid archive that misses entries
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	m := Manifest{
+		Version:    Version,
+		SuiteId:    suiteId,
+		ExportedAt: repo.MsTime(time.Now()),
+	}
+	if err := writeNdjson(tw, manifestName, m); err != nil {
+		return err
+	}
+	if err := writeNdjson(tw, suiteName, s); err != nil {
+		return err
+	}
+	if err := writeNdjson(tw, casesName, toValues(cs)...); err != nil {
+		return err
+	}
+	if err := writeNdjson(tw, logsName, toValues(lls)...); err != nil {
+		return err
+	}
+	if err := writeNdjson(tw, attachmentsName, toValues(as)...); err != nil {
+		return err
+	}
+	for _, a := range as {
+		if err := writeBlob(tw, blobDir, *a.Id); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+func toValues(v interface{}) []interface{} {
+	var vs []interface{}
+	switch v := v.(type) {
+	case []repo.Case:
+		for _, c := range v {
+			vs = append(vs, c)
+		}
+	case []repo.LogLine:
+		for _, ll := range v {
+			vs = append(vs, ll)
+		}
+	case []repo.Attachment:
+		for _, a := range v {
+			vs = append(vs, a)
+		}
+	default:
+		panic(fmt.Sprintf("bad type %T", v))
+	}
+	return vs
+}
+
+// writeNdjson writes vs to the entry with the given name. They are spooled to
+// a temporary file first, as the size of an entry precedes it.
+func writeNdjson(tw *tar.Writer, name string, vs ...interface{}) error {
+	f, err := ioutil.TempFile("", "suiteserve-export-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	bw := bufio.NewWriter(f)
+	enc := json.NewEncoder(bw)
+	for _, v := range vs {
+		if err := enc.Encode(v); err != nil {
+			return err
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	size, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return writeEntry(tw, name, size, bufio.NewReader(f))
+}
+
+func writeBlob(tw *tar.Writer, blobDir string, id repo.Id) error {
+	f, err := os.Open(filepath.Join(blobDir, id.String()))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return writeEntry(tw, blobsDir+id.String(), fi.Size(), f)
+}
+
+func writeEntry(tw *tar.Writer, name string, size int64, r io.Reader) error {
+	err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     size,
+		Mode:     0644,
+		ModTime:  time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, r)
+	return err
+}
+
+type importer struct {
+	ctx         context.Context
+	repo        Repo
+	blobDir     string
+	maxBlobSize int64
+
+	manifest *Manifest
+	suiteId  *repo.Id
+	caseIds  map[repo.Id]repo.Id
+	attIds   map[repo.Id]repo.Id
+	// attSizes are the sizes of the imported attachments by their new id.
+	attSizes map[repo.Id]int64
+	// blobs are the paths of the blobs written so far.
+	blobs []string
+}
+
+// Import reads an archive written by Export from rd and inserts its contents,
+// writing attachment blobs of up to maxBlobSize bytes into blobDir. Every
+// entity gets a new id. The id of the new suite is returned. If the import
+// fails, what was imported is removed.
+func Import(ctx context.Context, rd io.Reader, r Repo, blobDir string,
+	maxBlobSize int64) (repo.Id, error) {
+	im := importer{
+		ctx:         ctx,
+		repo:        r,
+		blobDir:     blobDir,
+		maxBlobSize: maxBlobSize,
+		caseIds:     map[repo.Id]repo.Id{},
+		attIds:      map[repo.Id]repo.Id{},
+		attSizes:    map[repo.Id]int64{},
+	}
+	if err := im.read(rd); err != nil {
+		im.cleanUp()
+		return repo.Id{}, err
+	}
+	return *im.suiteId, nil
+}
+
+func (im *importer) read(rd io.Reader) error {
+	gr, err := gzip.NewReader(rd)
+	if err != nil {
+		return errBadArchive{err}
+	}
+	defer gr.Close()
+	tr := tar.NewReader(gr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return errBadArchive{err}
+		}
+		if err := im.readEntry(hdr.Name, tr); err != nil {
+			return err
+		}
+	}
+	if im.suiteId == nil {
+		return errBadArchive{errors.New("missing suite")}
+	}
+	// the suite is revealed once all of it is imported
+	return im.repo.RestoreSuite(im.ctx, *im.suiteId, nil)
+}
+
+// cleanUp removes the suite and blobs imported so far. It is independent of
+// the context of the import, which may be why it failed.
+func (im *importer) cleanUp() {
+	for _, p := range im.blobs {
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			log.Printf("remove imported blob: %v", err)
+		}
+	}
+	if im.suiteId == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), cleanUpTimeout)
+	defer cancel()
+	// otherwise the suite is purged with other deleted suites
+	if err := im.repo.PurgeSuite(ctx, *im.suiteId); err != nil {
+		log.Printf("purge imported suite %s: %v", im.suiteId, err)
+	}
+}
+
+func (im *importer) readEntry(name string, r io.Reader) error {
+	if im.manifest == nil && name != manifestName {
+		return errBadArchive{fmt.Errorf("want %s first, got %s",
+			manifestName, name)}
+	}
+	if im.suiteId == nil && name != manifestName && name != suiteName {
+		return errBadArchive{fmt.Errorf("want %s before %s", suiteName, name)}
+	}
+	switch {
+	case name == manifestName:
+		return im.readManifest(r)
+	case name == suiteName:
+		return im.readSuite(r)
+	case name == casesName:
+		return readNdjson(r, func(dec *json.Decoder) error {
+			var c repo.Case
+			if err := dec.Decode(&c); err != nil {
+				return errBadArchive{err}
+			}
+			return im.importCase(c)
+		})
+	case name == logsName:
+		return readNdjson(r, func(dec *json.Decoder) error {
+			var ll repo.LogLine
+			if err := dec.Decode(&ll); err != nil {
+				return errBadArchive{err}
+			}
+			return im.importLogLine(ll)
+		})
+	case name == attachmentsName:
+		return readNdjson(r, func(dec *json.Decoder) error {
+			var a repo.Attachment
+			if err := dec.Decode(&a); err != nil {
+				return errBadArchive{err}
+			}
+			return im.importAttachment(a)
+		})
+	case path.Dir(name)+"/" == blobsDir:
+		return im.importBlob(path.Base(name), r)
+	default:
+		return errBadArchive{fmt.Errorf("unknown entry %s", name)}
+	}
+}
+
+func readNdjson(r io.Reader, fn func(dec *json.Decoder) error) error {
+	dec := json.NewDecoder(r)
+	for dec.More() {
+		if err := fn(dec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (im *importer) readManifest(r io.Reader) error {
+	var m Manifest
+	if err := json.NewDecoder(r).Decode(&m); err != nil {
+		return errBadArchive{err}
+	}
+	if m.Version != Version {
+		return errBadArchive{fmt.Errorf("unsupported version %d", m.Version)}
+	}
+	im.manifest = &m
+	return nil
+}
+
+func (im *importer) readSuite(r io.Reader) error {
+	var s repo.Suite
+	if err := json.NewDecoder(r).Decode(&s); err != nil {
+		return errBadArchive{err}
+	}
+	s.Id = nil
+	// hidden until all of it is imported, even if it was exported while
+	// deleted
+	now := repo.MsTime(time.Now())
+	s.DeletedAt = &now
+	// the baseline isn't in the archive
+	s.BaselineId = nil
+	id, err := im.repo.InsertSuite(im.ctx, s)
+	if err != nil {
+		return err
+	}
+	im.suiteId = &id
+	return nil
+}
+
+func (im *importer) importCase(c repo.Case) error {
+	if c.Id == nil {
+		return errBadArchive{errors.New("case without id")}
+	}
+	oldId := *c.Id
+	c.Id = nil
+	c.SuiteId = im.suiteId
+	if c.ParentId != nil {
+		parentId, err := im.remapCaseId(c.ParentId)
+		if err != nil {
+			return err
+		}
+		c.ParentId = parentId
+	}
+	id, err := im.repo.InsertCase(im.ctx, c)
+	if err != nil {
+		return err
+	}
+	im.caseIds[oldId] = id
+	return nil
+}
+
+func (im *importer) importLogLine(ll repo.LogLine) error {
+	ll.Id = nil
+	if ll.SuiteId != nil {
+		ll.SuiteId = im.suiteId
+	} else {
+		caseId, err := im.remapCaseId(ll.CaseId)
+		if err != nil {
+			return err
+		}
+		ll.CaseId = caseId
+	}
+	_, err := im.repo.InsertLogLine(im.ctx, ll)
+	return err
+}
+
+func (im *importer) importAttachment(a repo.Attachment) error {
+	if a.Id == nil {
+		return errBadArchive{errors.New("attachment without id")}
+	}
+	if a.Size != nil && *a.Size > im.maxBlobSize {
+		return errBadArchive{fmt.Errorf("attachment %s is larger than %d bytes",
+			a.Id, im.maxBlobSize)}
+	}
+	oldId := *a.Id
+	a.Id = nil
+	if a.SuiteId != nil {
+		a.SuiteId = im.suiteId
+	}
+	if a.CaseId != nil {
+		caseId, err := im.remapCaseId(a.CaseId)
+		if err != nil {
+			return err
+		}
+		a.CaseId = caseId
+	}
+	id, err := im.repo.InsertAttachment(im.ctx, a)
+	if err != nil {
+		return err
+	}
+	im.attIds[oldId] = id
+	if a.Size != nil {
+		im.attSizes[id] = *a.Size
+	}
+	return nil
+}
+
+func (im *importer) remapCaseId(oldId *repo.Id) (*repo.Id, error) {
+	if oldId == nil {
+		return nil, errBadArchive{errors.New("missing case id")}
+	}
+	id, ok := im.caseIds[*oldId]
+	if !ok {
+		return nil, errBadArchive{fmt.Errorf("unknown case %s", oldId)}
+	}
+	return &id, nil
+}
+
+func (im *importer) importBlob(name string, r io.Reader) error {
+	oldId, err := repo.NewId(name)
+	if err != nil {
+		return errBadArchive{fmt.Errorf("bad blob name %q: %v", name, err)}
+	}
+	id, ok := im.attIds[oldId]
+	if !ok {
+		return errBadArchive{fmt.Errorf("blob for unknown attachment %s",
+			oldId)}
+	}
+	p := filepath.Join(im.blobDir, id.String())
+	f, err := os.Create(p)
+	if err != nil {
+		return err
+	}
+	im.blobs = append(im.blobs, p)
+	n, err := io.Copy(f, io.LimitReader(r, im.maxBlobSize+1))
+	if err != nil {
+		f.Close()
+		return errBadArchive{err}
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if n > im.maxBlobSize {
+		return errBadArchive{fmt.Errorf("blob %s is larger than %d bytes",
+			oldId, im.maxBlobSize)}
+	}
+	if size, ok := im.attSizes[id]; ok && n != size {
+		return errBadArchive{fmt.Errorf("blob %s has %d bytes, want %d",
+			oldId, n, size)}
+	}
+	return nil
+}
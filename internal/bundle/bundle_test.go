@@ -0,0 +1,335 @@
+package bundle_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/suiteserve/suiteserve/internal/bundle"
+	"github.com/suiteserve/suiteserve/internal/repo"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type memRepo struct {
+	attachments []repo.Attachment
+	suites      []repo.Suite
+	cases       []repo.Case
+	logs        []repo.LogLine
+}
+
+func newId() *repo.Id {
+	id := repo.Id(primitive.NewObjectID())
+	return &id
+}
+
+func (m *memRepo) InsertAttachment(_ context.Context, a repo.Attachment) (repo.Id, error) {
+	a.Id = newId()
+	m.attachments = append(m.attachments, a)
+	return *a.Id, nil
+}
+
+func (m *memRepo) SuiteAttachments(_ context.Context, suiteId repo.Id) ([]repo.Attachment, error) {
+	var as []repo.Attachment
+	for _, a := range m.attachments {
+		if a.SuiteId != nil && *a.SuiteId == suiteId {
+			as = append(as, a)
+		}
+	}
+	return as, nil
+}
+
+func (m *memRepo) CaseAttachments(_ context.Context, caseId repo.Id) ([]repo.Attachment, error) {
+	var as []repo.Attachment
+	for _, a := range m.attachments {
+		if a.SuiteId == nil && a.CaseId != nil && *a.CaseId == caseId {
+			as = append(as, a)
+		}
+	}
+	return as, nil
+}
+
+func (m *memRepo) InsertSuite(_ context.Context, s repo.Suite) (repo.Id, error) {
+	s.Id = newId()
+	m.suites = append(m.suites, s)
+	return *s.Id, nil
+}
+
+func (m *memRepo) Suite(_ context.Context, id repo.Id) (repo.Suite, error) {
+	for _, s := range m.suites {
+		if *s.Id == id {
+			return s, nil
+		}
+	}
+	panic("suite not found")
+}
+
+func (m *memRepo) RestoreSuite(_ context.Context, id repo.Id, _ []int64) error {
+	for i, s := range m.suites {
+		if *s.Id == id {
+			m.suites[i].DeletedAt = nil
+		}
+	}
+	return nil
+}
+
+func (m *memRepo) PurgeSuite(_ context.Context, id repo.Id) error {
+	var ss []repo.Suite
+	for _, s := range m.suites {
+		if *s.Id != id {
+			ss = append(ss, s)
+		}
+	}
+	m.suites = ss
+	m.cases, m.logs, m.attachments = nil, nil, nil
+	return nil
+}
+
+func (m *memRepo) InsertCase(_ context.Context, c repo.Case) (repo.Id, error) {
+	c.Id = newId()
+	c.Ancestors = nil
+	if c.ParentId != nil {
+		for _, parent := range m.cases {
+			if *parent.Id == *c.ParentId {
+				c.Ancestors = append(parent.Ancestors, *parent.Id)
+			}
+		}
+	}
+	m.cases = append(m.cases, c)
+	return *c.Id, nil
+}
+
+func (m *memRepo) SuiteCases(_ context.Context, suiteId repo.Id) ([]repo.Case, error) {
+	var cs []repo.Case
+	for _, c := range m.cases {
+		if *c.SuiteId == suiteId {
+			cs = append(cs, c)
+		}
+	}
+	return cs, nil
+}
+
+func (m *memRepo) InsertLogLine(_ context.Context, ll repo.LogLine) (repo.Id, error) {
+	ll.Id = newId()
+	m.logs = append(m.logs, ll)
+	return *ll.Id, nil
+}
+
+func (m *memRepo) SuiteLogLines(_ context.Context, suiteId repo.Id) ([]repo.LogLine, error) {
+	var lls []repo.LogLine
+	for _, ll := range m.logs {
+		if ll.SuiteId != nil && *ll.SuiteId == suiteId {
+			lls = append(lls, ll)
+		}
+	}
+	return lls, nil
+}
+
+func (m *memRepo) CaseLogLines(_ context.Context, caseId repo.Id) ([]repo.LogLine, error) {
+	var lls []repo.LogLine
+	for _, ll := range m.logs {
+		if ll.CaseId != nil && *ll.CaseId == caseId {
+			lls = append(lls, ll)
+		}
+	}
+	return lls, nil
+}
+
+func TestExportImport(t *testing.T) {
+	ctx := context.Background()
+	srcDir, dstDir := t.TempDir(), t.TempDir()
+	var src memRepo
+	startedAt := repo.NewMsTime(1597422555541)
+	suiteId, err := src.InsertSuite(ctx, repo.Suite{
+		Project:   repo.String("api"),
+		Tags:      []string{"nightly"},
+		StartedAt: &startedAt,
+	})
+	require.Nil(t, err)
+	caseIds := make([]repo.Id, 2)
+	for i := range caseIds {
+		caseIds[i], err = src.InsertCase(ctx, repo.Case{
+			SuiteId:   &suiteId,
+			Name:      repo.String("case"),
+			Idx:       repo.Int64(int64(i)),
+			CreatedAt: &startedAt,
+		})
+		require.Nil(t, err)
+		_, err = src.InsertLogLine(ctx, repo.LogLine{
+			CaseId: &caseIds[i],
+			Idx:    repo.Int64(int64(i + 10)),
+			Line:   repo.String("hello"),
+		})
+		require.Nil(t, err)
+	}
+	_, err = src.InsertLogLine(ctx, repo.LogLine{
+		SuiteId: &suiteId,
+		Idx:     repo.Int64(0),
+		Line:    repo.String("suite"),
+	})
+	require.Nil(t, err)
+	attId, err := src.InsertAttachment(ctx, repo.Attachment{
+		CaseId:   &caseIds[1],
+		Filename: repo.String("out.txt"),
+	})
+	require.Nil(t, err)
+	require.Nil(t, ioutil.WriteFile(filepath.Join(srcDir, attId.String()),
+		[]byte("blob"), 0644))
+
+	var buf bytes.Buffer
+	require.Nil(t, bundle.Export(ctx, &buf, &src, srcDir, suiteId))
+	var dst memRepo
+	newSuiteId, err := bundle.Import(ctx, &buf, &dst, dstDir, 1<<20)
+	require.Nil(t, err)
+
+	require.Len(t, dst.suites, 1)
+	assert.NotEqual(t, suiteId, newSuiteId)
+	assert.Nil(t, dst.suites[0].DeletedAt)
+	assert.Equal(t, "api", *dst.suites[0].Project)
+	assert.Equal(t, startedAt, *dst.suites[0].StartedAt)
+	require.Len(t, dst.logs, 3)
+	assert.Equal(t, newSuiteId, *dst.logs[0].SuiteId)
+	assert.Nil(t, dst.logs[0].CaseId)
+	require.Len(t, dst.cases, 2)
+	for i, c := range dst.cases {
+		assert.Equal(t, newSuiteId, *c.SuiteId)
+		assert.Equal(t, int64(i), *c.Idx)
+		assert.Equal(t, startedAt, *c.CreatedAt)
+		assert.Equal(t, *c.Id, *dst.logs[i+1].CaseId)
+		assert.Equal(t, int64(i+10), *dst.logs[i+1].Idx)
+	}
+	require.Len(t, dst.attachments, 1)
+	a := dst.attachments[0]
+	assert.Equal(t, *dst.cases[1].Id, *a.CaseId)
+	b, err := ioutil.ReadFile(filepath.Join(dstDir, a.Id.String()))
+	require.Nil(t, err)
+	assert.Equal(t, "blob", string(b))
+}
+
+func TestExportImport_Parents(t *testing.T) {
+	ctx := context.Background()
+	var src memRepo
+	suiteId, err := src.InsertSuite(ctx, repo.Suite{})
+	require.Nil(t, err)
+	var parentId *repo.Id
+	for i := 0; i < 3; i++ {
+		id, err := src.InsertCase(ctx, repo.Case{
+			SuiteId:  &suiteId,
+			ParentId: parentId,
+			Name:     repo.String("case"),
+			Idx:      repo.Int64(int64(i)),
+		})
+		require.Nil(t, err)
+		parentId = &id
+	}
+	// children first, as a repo may list them in any order
+	for i, j := 0, len(src.cases)-1; i < j; i, j = i+1, j-1 {
+		src.cases[i], src.cases[j] = src.cases[j], src.cases[i]
+	}
+
+	var buf bytes.Buffer
+	require.Nil(t, bundle.Export(ctx, &buf, &src, t.TempDir(), suiteId))
+	var dst memRepo
+	_, err = bundle.Import(ctx, &buf, &dst, t.TempDir(), 1<<20)
+	require.Nil(t, err)
+
+	require.Len(t, dst.cases, 3)
+	for i, c := range dst.cases {
+		assert.Equal(t, int64(i), *c.Idx)
+		assert.Len(t, c.Ancestors, i)
+		if i > 0 {
+			assert.Equal(t, *dst.cases[i-1].Id, *c.ParentId)
+		}
+	}
+}
+
+func TestImport_BadArchive(t *testing.T) {
+	var dst memRepo
+	_, err := bundle.Import(context.Background(),
+		bytes.NewBufferString("not an archive"), &dst, t.TempDir(), 1<<20)
+	var badFormat interface {
+		BadFormat()
+	}
+	assert.True(t, errors.As(err, &badFormat), "want bad format")
+}
+
+func TestImport_BlobTooLarge(t *testing.T) {
+	ctx := context.Background()
+	srcDir, dstDir := t.TempDir(), t.TempDir()
+	var src memRepo
+	suiteId, err := src.InsertSuite(ctx, repo.Suite{})
+	require.Nil(t, err)
+	attId, err := src.InsertAttachment(ctx, repo.Attachment{
+		SuiteId: &suiteId,
+	})
+	require.Nil(t, err)
+	require.Nil(t, ioutil.WriteFile(filepath.Join(srcDir, attId.String()),
+		[]byte("blob"), 0644))
+
+	var buf bytes.Buffer
+	require.Nil(t, bundle.Export(ctx, &buf, &src, srcDir, suiteId))
+	var dst memRepo
+	_, err = bundle.Import(ctx, &buf, &dst, dstDir, 3)
+	var badFormat interface {
+		BadFormat()
+	}
+	assert.True(t, errors.As(err, &badFormat), "want bad format")
+	assert.Empty(t, dst.suites)
+	assert.Empty(t, dst.attachments)
+	files, err := ioutil.ReadDir(dstDir)
+	require.Nil(t, err)
+	assert.Empty(t, files)
+}
+
+func TestImport_BlobSizeMismatch(t *testing.T) {
+	ctx := context.Background()
+	srcDir := t.TempDir()
+	var src memRepo
+	suiteId, err := src.InsertSuite(ctx, repo.Suite{})
+	require.Nil(t, err)
+	attId, err := src.InsertAttachment(ctx, repo.Attachment{
+		SuiteId: &suiteId,
+		Size:    repo.Int64(2),
+	})
+	require.Nil(t, err)
+	require.Nil(t, ioutil.WriteFile(filepath.Join(srcDir, attId.String()),
+		[]byte("blob"), 0644))
+
+	var buf bytes.Buffer
+	require.Nil(t, bundle.Export(ctx, &buf, &src, srcDir, suiteId))
+	var dst memRepo
+	_, err = bundle.Import(ctx, &buf, &dst, t.TempDir(), 1<<20)
+	var badFormat interface {
+		BadFormat()
+	}
+	assert.True(t, errors.As(err, &badFormat), "want bad format")
+	assert.Empty(t, dst.suites)
+}
+
+func TestExport_Failed(t *testing.T) {
+	ctx := context.Background()
+	srcDir := t.TempDir()
+	var src memRepo
+	suiteId, err := src.InsertSuite(ctx, repo.Suite{})
+	require.Nil(t, err)
+	attId, err := src.InsertAttachment(ctx, repo.Attachment{
+		SuiteId: &suiteId,
+	})
+	require.Nil(t, err)
+	// a blob that can't be read fails the export after the entries before it
+	require.Nil(t, os.Mkdir(filepath.Join(srcDir, attId.String()), 0755))
+
+	var buf bytes.Buffer
+	require.NotNil(t, bundle.Export(ctx, &buf, &src, srcDir, suiteId))
+	var dst memRepo
+	_, err = bundle.Import(ctx, &buf, &dst, t.TempDir(), 1<<20)
+	var badFormat interface {
+		BadFormat()
+	}
+	assert.True(t, errors.As(err, &badFormat), "want bad format")
+	assert.Empty(t, dst.suites)
+}
@@ -0,0 +1,206 @@
+// Package compare compares the cases of two suites, such as those of a pull
+// request and of its base branch, to find out what the changes between them
+// broke, fixed, added, removed or slowed down.
+package compare
+
+import (
+	"github.com/suiteserve/suiteserve/internal/repo"
+	"time"
+)
+
+const (
+	// SlowerRatio is how many times longer than its base a case must take to
+	// be significantly slower.
+	SlowerRatio = 1.5
+	// MinSlowdown is how much longer than its base a case must take to be
+	// significantly slower, so that fast cases don't jitter into it.
+	MinSlowdown = 100 * time.Millisecond
+)
+
+// Entry is a test with its cases in the base and the head suite, either of
+// which may be missing.
+type Entry struct {
+	Name        *string           `json:"name,omitempty"`
+	Params      map[string]string `json:"params,omitempty"`
+	Fingerprint *string           `json:"fingerprint,omitempty"`
+	Base        *Run              `json:"base,omitempty"`
+	Head        *Run              `json:"head,omitempty"`
+	// DeltaMs is how much longer the head case took than the base case.
+	DeltaMs *int64 `json:"deltaMs,omitempty"`
+}
+
+// Run is a case of a test in one of the suites compared.
+type Run struct {
+	CaseId     repo.Id          `json:"caseId"`
+	Result     *repo.CaseResult `json:"result,omitempty"`
+	DurationMs *int64           `json:"durationMs,omitempty"`
+}
+
+type Report struct {
+	BaseId       repo.Id `json:"baseId"`
+	HeadId       repo.Id `json:"headId"`
+	NewlyFailing []Entry `json:"newlyFailing"`
+	NewlyPassing []Entry `json:"newlyPassing"`
+	StillFailing []Entry `json:"stillFailing"`
+	Added        []Entry `json:"added"`
+	Removed      []Entry `json:"removed"`
+	Slower       []Entry `json:"slower"`
+	// Unchanged counts the tests in both suites that are in none of the
+	// above.
+	Unchanged int `json:"unchanged"`
+}
+
+// Suites compares the cases of the head suite with those of the base suite.
+// Cases are matched by fingerprint, and else by name and parameters, so that
+// suites of different projects can be compared. If a suite has several cases
+// of a test, the one with the highest index counts.
+func Suites(baseId repo.Id, base []repo.Case, headId repo.Id,
+	head []repo.Case) Report {
+	rep := Report{
+		BaseId:       baseId,
+		HeadId:       headId,
+		NewlyFailing: []Entry{},
+		NewlyPassing: []Entry{},
+		StillFailing: []Entry{},
+		Added:        []Entry{},
+		Removed:      []Entry{},
+		Slower:       []Entry{},
+	}
+	baseByFp, baseByName := index(base)
+	matched := map[repo.Id]bool{}
+	for _, h := range latest(head) {
+		b, ok := repo.Case{}, false
+		if h.Fingerprint != nil {
+			b, ok = baseByFp[*h.Fingerprint]
+		}
+		if !ok {
+			b, ok = baseByName[nameKey(h)]
+		}
+		if !ok || matched[*b.Id] {
+			rep.Added = append(rep.Added, newEntry(nil, &h))
+			continue
+		}
+		matched[*b.Id] = true
+		e := newEntry(&b, &h)
+		switch {
+		case failed(h) && passed(b):
+			rep.NewlyFailing = append(rep.NewlyFailing, e)
+		case passed(h) && failed(b):
+			rep.NewlyPassing = append(rep.NewlyPassing, e)
+		case failed(h) && failed(b):
+			rep.StillFailing = append(rep.StillFailing, e)
+		case passed(h) && passed(b) && slower(e):
+			rep.Slower = append(rep.Slower, e)
+		default:
+			rep.Unchanged++
+		}
+	}
+	for _, b := range latest(base) {
+		if !matched[*b.Id] {
+			rep.Removed = append(rep.Removed, newEntry(&b, nil))
+		}
+	}
+	return rep
+}
+
+// latest returns the cases with the highest index of each test, in the order
+// they are given.
+func latest(cs []repo.Case) []repo.Case {
+	byKey := map[string]int{}
+	var out []repo.Case
+	for _, c := range cs {
+		if c.Id == nil {
+			continue
+		}
+		k := key(c)
+		if i, ok := byKey[k]; ok {
+			if idx(c) >= idx(out[i]) {
+				out[i] = c
+			}
+			continue
+		}
+		byKey[k] = len(out)
+		out = append(out, c)
+	}
+	return out
+}
+
+func index(cs []repo.Case) (byFp, byName map[string]repo.Case) {
+	byFp, byName = map[string]repo.Case{}, map[string]repo.Case{}
+	for _, c := range latest(cs) {
+		if c.Fingerprint != nil {
+			byFp[*c.Fingerprint] = c
+		}
+		byName[nameKey(c)] = c
+	}
+	return byFp, byName
+}
+
+func key(c repo.Case) string {
+	if c.Fingerprint != nil {
+		return *c.Fingerprint
+	}
+	return nameKey(c)
+}
+
+// nameKey identifies a test by its name and parameters, whatever its project.
+func nameKey(c repo.Case) string {
+	return repo.Fingerprint(nil, c.Name, c.Params)
+}
+
+func idx(c repo.Case) int64 {
+	if c.Idx == nil {
+		return 0
+	}
+	return *c.Idx
+}
+
+func passed(c repo.Case) bool {
+	return c.Result != nil && (*c.Result == repo.CaseResultPassed ||
+		*c.Result == repo.CaseResultPassedOnRetry)
+}
+
+func failed(c repo.Case) bool {
+	return c.Result != nil && (*c.Result == repo.CaseResultFailed ||
+		*c.Result == repo.CaseResultErrored)
+}
+
+func slower(e Entry) bool {
+	if e.DeltaMs == nil || *e.Base.DurationMs <= 0 {
+		return false
+	}
+	delta := time.Duration(*e.DeltaMs) * time.Millisecond
+	return delta >= MinSlowdown &&
+		float64(*e.Head.DurationMs) >= SlowerRatio*float64(*e.Base.DurationMs)
+}
+
+func newEntry(base, head *repo.Case) Entry {
+	var e Entry
+	for _, c := range []*repo.Case{head, base} {
+		if c != nil && e.Name == nil {
+			e.Name, e.Params, e.Fingerprint = c.Name, c.Params, c.Fingerprint
+		}
+	}
+	if base != nil {
+		e.Base = newRun(*base)
+	}
+	if head != nil {
+		e.Head = newRun(*head)
+	}
+	if e.Base != nil && e.Head != nil && e.Base.DurationMs != nil &&
+		e.Head.DurationMs != nil {
+		d := *e.Head.DurationMs - *e.Base.DurationMs
+		e.DeltaMs = &d
+	}
+	return e
+}
+
+func newRun(c repo.Case) *Run {
+	run := Run{CaseId: *c.Id, Result: c.Result}
+	if c.StartedAt != nil && c.FinishedAt != nil {
+		d := time.Time(*c.FinishedAt).Sub(time.Time(*c.StartedAt)).
+			Milliseconds()
+		run.DurationMs = &d
+	}
+	return &run
+}
@@ -0,0 +1,95 @@
+package compare_test
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/suiteserve/suiteserve/internal/compare"
+	"github.com/suiteserve/suiteserve/internal/repo"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"testing"
+)
+
+func newId() *repo.Id {
+	id := repo.Id(primitive.NewObjectID())
+	return &id
+}
+
+func newCase(project, name string, res repo.CaseResult,
+	durationMs int64) repo.Case {
+	fp := repo.Fingerprint(&project, &name, nil)
+	started := repo.NewMsTime(1600000000000)
+	finished := repo.NewMsTime(1600000000000 + durationMs)
+	return repo.Case{
+		Entity:      repo.Entity{Id: newId()},
+		Name:        &name,
+		Fingerprint: &fp,
+		Result:      &res,
+		StartedAt:   &started,
+		FinishedAt:  &finished,
+	}
+}
+
+func names(es []compare.Entry) []string {
+	var ns []string
+	for _, e := range es {
+		ns = append(ns, *e.Name)
+	}
+	return ns
+}
+
+func TestSuites(t *testing.T) {
+	const (
+		passed = repo.CaseResultPassed
+		failed = repo.CaseResultFailed
+	)
+	base := []repo.Case{
+		newCase("api", "broken", passed, 10),
+		newCase("api", "fixed", failed, 10),
+		newCase("api", "still", failed, 10),
+		newCase("api", "removed", passed, 10),
+		newCase("api", "slow", passed, 100),
+		newCase("api", "jitter", passed, 10),
+		newCase("api", "same", passed, 10),
+	}
+	head := []repo.Case{
+		newCase("api", "broken", repo.CaseResultErrored, 10),
+		newCase("api", "fixed", repo.CaseResultPassedOnRetry, 10),
+		newCase("api", "still", failed, 10),
+		newCase("api", "added", passed, 10),
+		newCase("api", "slow", passed, 300),
+		newCase("api", "jitter", passed, 30),
+		newCase("api", "same", passed, 10),
+	}
+	rep := compare.Suites(*newId(), base, *newId(), head)
+	assert.Equal(t, []string{"broken"}, names(rep.NewlyFailing))
+	assert.Equal(t, []string{"fixed"}, names(rep.NewlyPassing))
+	assert.Equal(t, []string{"still"}, names(rep.StillFailing))
+	assert.Equal(t, []string{"added"}, names(rep.Added))
+	assert.Equal(t, []string{"removed"}, names(rep.Removed))
+	require.Equal(t, []string{"slow"}, names(rep.Slower))
+	assert.Equal(t, int64(200), *rep.Slower[0].DeltaMs)
+	assert.Equal(t, int64(300), *rep.Slower[0].Head.DurationMs)
+	assert.Equal(t, 2, rep.Unchanged)
+	assert.Nil(t, rep.Added[0].Base)
+	assert.Nil(t, rep.Removed[0].Head)
+}
+
+func TestSuites_ByName(t *testing.T) {
+	base := []repo.Case{newCase("main", "test", repo.CaseResultPassed, 10)}
+	head := []repo.Case{newCase("fork", "test", repo.CaseResultFailed, 10)}
+	rep := compare.Suites(*newId(), base, *newId(), head)
+	assert.Equal(t, []string{"test"}, names(rep.NewlyFailing))
+	assert.Empty(t, rep.Added)
+	assert.Empty(t, rep.Removed)
+}
+
+func TestSuites_LatestIdx(t *testing.T) {
+	first := newCase("api", "retried", repo.CaseResultFailed, 10)
+	first.Idx = repo.Int64(0)
+	second := newCase("api", "retried", repo.CaseResultPassed, 10)
+	second.Idx = repo.Int64(1)
+	base := []repo.Case{newCase("api", "retried", repo.CaseResultPassed, 10)}
+	rep := compare.Suites(*newId(), base, *newId(), []repo.Case{second, first})
+	assert.Equal(t, 1, rep.Unchanged)
+	assert.Empty(t, rep.NewlyFailing)
+}
@@ -14,6 +14,24 @@ type Config struct {
 		PublicDir       string `json:"public_dir"`
 		UserContentHost string `json:"user_content_host"`
 	} `json:"http"`
+	Auth struct {
+		// Anonymous grants full access to API requests without a token or
+		// session, unless single sign-on is enabled.
+		Anonymous bool `json:"anonymous"`
+		Oidc      struct {
+			Issuer           string   `json:"issuer"`
+			ClientId         string   `json:"client_id"`
+			ClientSecretFile string   `json:"client_secret_file"`
+			RedirectUrl      string   `json:"redirect_url"`
+			Scopes           []string `json:"scopes"`
+			SessionHours     int      `json:"session_hours"`
+		} `json:"oidc"`
+	} `json:"auth"`
+	Retention struct {
+		DeletedGraceHours int             `json:"deleted_grace_hours"`
+		BatchSize         int             `json:"batch_size"`
+		Rules             []RetentionRule `json:"rules"`
+	} `json:"retention"`
 	Storage struct {
 		UserContent struct {
 			Dir       string `json:"dir"`
@@ -28,6 +46,27 @@ type Config struct {
 			Db       string `json:"db"`
 		} `json:"mongodb"`
 	} `json:"storage"`
+	Baselines []BaselineRule `json:"baselines"`
+}
+
+// RetentionRule keeps the suites of a project, or of any project if it is
+// empty, that have a result, or any result if it is empty, for MaxAgeDays and
+// only the latest KeepLast of each project. Zero limits do not apply.
+type RetentionRule struct {
+	Project    string `json:"project"`
+	Result     string `json:"result"`
+	MaxAgeDays int    `json:"max_age_days"`
+	KeepLast   int    `json:"keep_last"`
+}
+
+// BaselineRule selects the baseline of the suites of a project, or of any
+// project if it is empty: the latest earlier finished suite with Labels, with
+// the same values of SameLabels and, if SameTags, with the same tags.
+type BaselineRule struct {
+	Project    string            `json:"project"`
+	Labels     map[string]string `json:"labels"`
+	SameLabels []string          `json:"same_labels"`
+	SameTags   bool              `json:"same_tags"`
 }
 
 func Load(filename string) (*Config, error) {
@@ -0,0 +1,34 @@
+// Package flaky scores how flaky a test is by how often its result flips
+// between passing and failing over its latest runs. A test that always fails
+// is broken rather than flaky, and scores 0 like one that always passes.
+package flaky
+
+// Window is how many of the latest results of a test its score is computed
+// from.
+const Window = 50
+
+// MinRuns is how many results a test needs before it can score above 0.
+const MinRuns = 5
+
+// Threshold is the score from which a test is considered flaky.
+const Threshold = 0.1
+
+// Score returns how many times the results flip, oldest first, where each
+// result is whether a run failed, and the fraction of consecutive runs that
+// flip.
+func Score(failed []bool) (flips int, score float64) {
+	for i := 1; i < len(failed); i++ {
+		if failed[i] != failed[i-1] {
+			flips++
+		}
+	}
+	if len(failed) < MinRuns {
+		return flips, 0
+	}
+	return flips, float64(flips) / float64(len(failed)-1)
+}
+
+// IsFlaky reports whether a test with the given score is flaky.
+func IsFlaky(score float64) bool {
+	return score >= Threshold
+}
@@ -0,0 +1,29 @@
+package flaky_test
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/suiteserve/suiteserve/internal/flaky"
+	"testing"
+)
+
+func TestScore(t *testing.T) {
+	flips, score := flaky.Score([]bool{false, true, false, false, true})
+	assert.Equal(t, 3, flips)
+	assert.Equal(t, 0.75, score)
+	assert.True(t, flaky.IsFlaky(score))
+}
+
+func TestScore_Stable(t *testing.T) {
+	for _, failed := range []bool{false, true} {
+		flips, score := flaky.Score([]bool{failed, failed, failed, failed,
+			failed, failed})
+		assert.Equal(t, 0, flips)
+		assert.False(t, flaky.IsFlaky(score))
+	}
+}
+
+func TestScore_TooFewRuns(t *testing.T) {
+	flips, score := flaky.Score([]bool{false, true, false})
+	assert.Equal(t, 2, flips)
+	assert.Equal(t, 0.0, score)
+}
@@ -0,0 +1,300 @@
+// Package oidc implements the OpenID Connect authorization code flow with PKCE
+// for a confidential or public client. ID tokens must be signed with RS256.
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const leeway = time.Minute
+
+type Config struct {
+	Issuer       string
+	ClientId     string
+	ClientSecret string
+	RedirectUrl  string
+	// Scopes are requested in addition to openid.
+	Scopes []string
+}
+
+type Claims struct {
+	Issuer   string   `json:"iss"`
+	Subject  string   `json:"sub"`
+	Audience audience `json:"aud"`
+	Expiry   int64    `json:"exp"`
+	IssuedAt int64    `json:"iat"`
+	Nonce    string   `json:"nonce"`
+	Email    string   `json:"email"`
+	Name     string   `json:"name"`
+	Groups   []string `json:"groups"`
+}
+
+// audience is a string or an array of strings.
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*a = audience{s}
+		return nil
+	}
+	return json.Unmarshal(b, (*[]string)(a))
+}
+
+func (a audience) contains(s string) bool {
+	for _, v := range a {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+type Provider struct {
+	cfg  Config
+	http *http.Client
+
+	authUrl  string
+	tokenUrl string
+	jwksUrl  string
+
+	mu   sync.Mutex
+	keys map[string]*rsa.PublicKey
+}
+
+// Discover returns the Provider at cfg.Issuer using its discovery document.
+func Discover(ctx context.Context, hc *http.Client, cfg Config) (*Provider, error) {
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	var doc struct {
+		Issuer   string `json:"issuer"`
+		AuthUrl  string `json:"authorization_endpoint"`
+		TokenUrl string `json:"token_endpoint"`
+		JwksUrl  string `json:"jwks_uri"`
+	}
+	u := strings.TrimSuffix(cfg.Issuer, "/") + "/.well-known/openid-configuration"
+	if err := getJson(ctx, hc, u, &doc); err != nil {
+		return nil, fmt.Errorf("discover: %v", err)
+	}
+	if doc.Issuer != cfg.Issuer {
+		return nil, fmt.Errorf("discover: issuer %q does not match %q",
+			doc.Issuer, cfg.Issuer)
+	}
+	return &Provider{
+		cfg:      cfg,
+		http:     hc,
+		authUrl:  doc.AuthUrl,
+		tokenUrl: doc.TokenUrl,
+		jwksUrl:  doc.JwksUrl,
+	}, nil
+}
+
+// Login holds the secrets of a login attempt, which are checked when the
+// provider redirects back.
+type Login struct {
+	State    string
+	Verifier string
+	Nonce    string
+}
+
+func NewLogin() (Login, error) {
+	var l Login
+	for _, s := range []*string{&l.State, &l.Verifier, &l.Nonce} {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return l, err
+		}
+		*s = base64.RawURLEncoding.EncodeToString(b)
+	}
+	return l, nil
+}
+
+// AuthCodeUrl returns the URL to send the user to for the login attempt l.
+func (p *Provider) AuthCodeUrl(l Login) string {
+	challenge := sha256.Sum256([]byte(l.Verifier))
+	v := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.cfg.ClientId},
+		"redirect_uri":          {p.cfg.RedirectUrl},
+		"scope":                 {strings.Join(append([]string{"openid"}, p.cfg.Scopes...), " ")},
+		"state":                 {l.State},
+		"nonce":                 {l.Nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(p.authUrl, "?") {
+		sep = "&"
+	}
+	return p.authUrl + sep + v.Encode()
+}
+
+// Exchange redeems the authorization code of the login attempt l and returns
+// the claims of the verified ID token.
+func (p *Provider) Exchange(ctx context.Context, l Login,
+	code string) (Claims, error) {
+	var claims Claims
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.cfg.RedirectUrl},
+		"client_id":     {p.cfg.ClientId},
+		"code_verifier": {l.Verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.tokenUrl,
+		strings.NewReader(form.Encode()))
+	if err != nil {
+		return claims, err
+	}
+	req.Header.Set("content-type", "application/x-www-form-urlencoded")
+	if p.cfg.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.cfg.ClientId),
+			url.QueryEscape(p.cfg.ClientSecret))
+	}
+	var res struct {
+		IdToken string `json:"id_token"`
+	}
+	if err := doJson(p.http, req, &res); err != nil {
+		return claims, fmt.Errorf("exchange code: %v", err)
+	}
+	if err := p.verify(ctx, res.IdToken, &claims); err != nil {
+		return claims, fmt.Errorf("verify id token: %v", err)
+	}
+	now := time.Now()
+	switch {
+	case claims.Issuer != p.cfg.Issuer:
+		return claims, fmt.Errorf("verify id token: bad issuer %q", claims.Issuer)
+	case !claims.Audience.contains(p.cfg.ClientId):
+		return claims, errors.New("verify id token: bad audience")
+	case now.After(time.Unix(claims.Expiry, 0).Add(leeway)):
+		return claims, errors.New("verify id token: expired")
+	case claims.Nonce != l.Nonce:
+		return claims, errors.New("verify id token: bad nonce")
+	case claims.Subject == "":
+		return claims, errors.New("verify id token: no subject")
+	}
+	return claims, nil
+}
+
+func (p *Provider) verify(ctx context.Context, jwt string, dst interface{}) error {
+	parts := strings.Split(jwt, ".")
+	if len(parts) != 3 {
+		return errors.New("malformed")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return err
+	}
+	if header.Alg != "RS256" {
+		return fmt.Errorf("unsupported alg %q", header.Alg)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return err
+	}
+	key, err := p.key(ctx, header.Kid)
+	if err != nil {
+		return err
+	}
+	h := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, h[:], sig); err != nil {
+		return err
+	}
+	return decodeSegment(parts[1], dst)
+}
+
+// key returns the key with the given ID, fetching the key set again if it is
+// unknown, as the provider may have rotated its keys.
+func (p *Provider) key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if k, ok := p.keys[kid]; ok {
+		return k, nil
+	}
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := getJson(ctx, p.http, p.jwksUrl, &set); err != nil {
+		return nil, fmt.Errorf("get keys: %v", err)
+	}
+	p.keys = map[string]*rsa.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		p.keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	if k, ok := p.keys[kid]; ok {
+		return k, nil
+	}
+	return nil, fmt.Errorf("unknown key %q", kid)
+}
+
+func decodeSegment(seg string, dst interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, dst)
+}
+
+func getJson(ctx context.Context, hc *http.Client, u string,
+	dst interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	return doJson(hc, req, dst)
+}
+
+func doJson(hc *http.Client, req *http.Request, dst interface{}) error {
+	req.Header.Set("accept", "application/json")
+	res, err := hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", res.Status, strings.TrimSpace(string(b)))
+	}
+	return json.Unmarshal(b, dst)
+}
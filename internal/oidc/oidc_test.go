@@ -0,0 +1,101 @@
+package oidc_test
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/suiteserve/suiteserve/internal/oidc"
+	"github.com/suiteserve/suiteserve/internal/oidc/oidctest"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+const redirectUrl = "https://suiteserve.test/auth/callback"
+
+func newProvider(t *testing.T, secret string) (*oidctest.Provider, *oidc.Provider) {
+	fake := oidctest.NewProvider("suiteserve", "secret", oidctest.User{
+		Subject: "alice",
+		Email:   "alice@example.com",
+		Name:    "Alice",
+		Groups:  []string{"qa"},
+	})
+	t.Cleanup(fake.Close)
+	p, err := oidc.Discover(context.Background(), fake.Client(), oidc.Config{
+		Issuer:       fake.URL,
+		ClientId:     "suiteserve",
+		ClientSecret: secret,
+		RedirectUrl:  redirectUrl,
+		Scopes:       []string{"email", "profile"},
+	})
+	require.Nil(t, err)
+	return fake, p
+}
+
+// authorize follows the login attempt to the provider and returns the code and
+// state it redirects back with.
+func authorize(t *testing.T, fake *oidctest.Provider, u string) (string, string) {
+	hc := fake.Client()
+	hc.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	res, err := hc.Get(u)
+	require.Nil(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusFound, res.StatusCode)
+	loc, err := url.Parse(res.Header.Get("location"))
+	require.Nil(t, err)
+	assert.Equal(t, redirectUrl, (&url.URL{
+		Scheme: loc.Scheme,
+		Host:   loc.Host,
+		Path:   loc.Path,
+	}).String())
+	return loc.Query().Get("code"), loc.Query().Get("state")
+}
+
+func TestExchange(t *testing.T) {
+	fake, p := newProvider(t, "secret")
+	l, err := oidc.NewLogin()
+	require.Nil(t, err)
+	code, state := authorize(t, fake, p.AuthCodeUrl(l))
+	assert.Equal(t, l.State, state)
+
+	claims, err := p.Exchange(context.Background(), l, code)
+	require.Nil(t, err)
+	assert.Equal(t, "alice", claims.Subject)
+	assert.Equal(t, "alice@example.com", claims.Email)
+	assert.Equal(t, "Alice", claims.Name)
+	assert.Equal(t, []string{"qa"}, claims.Groups)
+
+	_, err = p.Exchange(context.Background(), l, code)
+	assert.NotNil(t, err, "code was redeemed twice")
+}
+
+func TestExchange_BadVerifier(t *testing.T) {
+	fake, p := newProvider(t, "secret")
+	l, err := oidc.NewLogin()
+	require.Nil(t, err)
+	code, _ := authorize(t, fake, p.AuthCodeUrl(l))
+	l.Verifier = "wrong"
+	_, err = p.Exchange(context.Background(), l, code)
+	assert.NotNil(t, err)
+}
+
+func TestExchange_BadNonce(t *testing.T) {
+	fake, p := newProvider(t, "secret")
+	l, err := oidc.NewLogin()
+	require.Nil(t, err)
+	code, _ := authorize(t, fake, p.AuthCodeUrl(l))
+	l.Nonce = "wrong"
+	_, err = p.Exchange(context.Background(), l, code)
+	assert.NotNil(t, err)
+}
+
+func TestExchange_BadClientSecret(t *testing.T) {
+	fake, p := newProvider(t, "wrong")
+	l, err := oidc.NewLogin()
+	require.Nil(t, err)
+	code, _ := authorize(t, fake, p.AuthCodeUrl(l))
+	_, err = p.Exchange(context.Background(), l, code)
+	assert.NotNil(t, err)
+}
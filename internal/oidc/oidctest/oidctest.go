@@ -0,0 +1,209 @@
+// Package oidctest provides an in-process OpenID Connect provider for tests.
+// It logs in a configured user without asking and checks PKCE, so that the
+// whole authorization code flow can be run without a real provider.
+package oidctest
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const kid = "test"
+
+type User struct {
+	Subject string
+	Email   string
+	Name    string
+	Groups  []string
+}
+
+type Provider struct {
+	*httptest.Server
+	ClientId     string
+	ClientSecret string
+
+	key *rsa.PrivateKey
+
+	mu    sync.Mutex
+	user  User
+	codes map[string]grant
+}
+
+type grant struct {
+	clientId    string
+	redirectUri string
+	challenge   string
+	nonce       string
+	user        User
+}
+
+// NewProvider starts a Provider that logs in user. Its issuer is its URL.
+func NewProvider(clientId, clientSecret string, user User) *Provider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	p := Provider{
+		ClientId:     clientId,
+		ClientSecret: clientSecret,
+		key:          key,
+		user:         user,
+		codes:        map[string]grant{},
+	}
+	var m http.ServeMux
+	m.HandleFunc("/.well-known/openid-configuration", p.discovery)
+	m.HandleFunc("/authorize", p.authorize)
+	m.HandleFunc("/token", p.token)
+	m.HandleFunc("/keys", p.keys)
+	p.Server = httptest.NewServer(&m)
+	return &p
+}
+
+// SetUser changes the user that is logged in.
+func (p *Provider) SetUser(u User) {
+	p.mu.Lock()
+	p.user = u
+	p.mu.Unlock()
+}
+
+func (p *Provider) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJson(w, map[string]string{
+		"issuer":                 p.URL,
+		"authorization_endpoint": p.URL + "/authorize",
+		"token_endpoint":         p.URL + "/token",
+		"jwks_uri":               p.URL + "/keys",
+	})
+}
+
+func (p *Provider) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("response_type") != "code" || q.Get("client_id") != p.ClientId ||
+		q.Get("code_challenge_method") != "S256" ||
+		q.Get("code_challenge") == "" {
+		http.Error(w, "invalid_request", http.StatusBadRequest)
+		return
+	}
+	code := randString()
+	p.mu.Lock()
+	p.codes[code] = grant{
+		clientId:    q.Get("client_id"),
+		redirectUri: q.Get("redirect_uri"),
+		challenge:   q.Get("code_challenge"),
+		nonce:       q.Get("nonce"),
+		user:        p.user,
+	}
+	p.mu.Unlock()
+	u, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil {
+		http.Error(w, "invalid_request", http.StatusBadRequest)
+		return
+	}
+	v := u.Query()
+	v.Set("code", code)
+	v.Set("state", q.Get("state"))
+	u.RawQuery = v.Encode()
+	http.Redirect(w, r, u.String(), http.StatusFound)
+}
+
+func (p *Provider) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid_request", http.StatusBadRequest)
+		return
+	}
+	clientId, secret, ok := r.BasicAuth()
+	if ok {
+		clientId, _ = url.QueryUnescape(clientId)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientId, secret = r.PostForm.Get("client_id"),
+			r.PostForm.Get("client_secret")
+	}
+	if clientId != p.ClientId || secret != p.ClientSecret {
+		http.Error(w, "invalid_client", http.StatusUnauthorized)
+		return
+	}
+	code := r.PostForm.Get("code")
+	p.mu.Lock()
+	g, ok := p.codes[code]
+	delete(p.codes, code)
+	p.mu.Unlock()
+	challenge := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || r.PostForm.Get("grant_type") != "authorization_code" ||
+		g.clientId != clientId ||
+		g.redirectUri != r.PostForm.Get("redirect_uri") ||
+		g.challenge != base64.RawURLEncoding.EncodeToString(challenge[:]) {
+		http.Error(w, "invalid_grant", http.StatusBadRequest)
+		return
+	}
+	now := time.Now()
+	writeJson(w, map[string]string{
+		"access_token": randString(),
+		"token_type":   "Bearer",
+		"id_token": p.sign(map[string]interface{}{
+			"iss":    p.URL,
+			"sub":    g.user.Subject,
+			"aud":    clientId,
+			"exp":    now.Add(time.Hour).Unix(),
+			"iat":    now.Unix(),
+			"nonce":  g.nonce,
+			"email":  g.user.Email,
+			"name":   g.user.Name,
+			"groups": g.user.Groups,
+		}),
+	})
+}
+
+func (p *Provider) keys(w http.ResponseWriter, r *http.Request) {
+	writeJson(w, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": kid,
+			"alg": "RS256",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(p.key.N.Bytes()),
+			"e": base64.RawURLEncoding.EncodeToString(
+				big.NewInt(int64(p.key.E)).Bytes()),
+		}},
+	})
+}
+
+func (p *Provider) sign(claims map[string]interface{}) string {
+	seg := func(v interface{}) string {
+		b, err := json.Marshal(v)
+		if err != nil {
+			panic(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	s := seg(map[string]string{"alg": "RS256", "typ": "JWT", "kid": kid}) +
+		"." + seg(claims)
+	h := sha256.Sum256([]byte(s))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, h[:])
+	if err != nil {
+		panic(err)
+	}
+	return s + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func randString() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func writeJson(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("content-type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,202 @@
+package purge
+
+import (
+	"context"
+	"fmt"
+	"github.com/suiteserve/suiteserve/internal/repo"
+	"log"
+	"time"
+)
+
+// defaultBatchSize is the batch size of a Pruner without one.
+const defaultBatchSize = 100
+
+// Rule is a retention rule for the suites it selects that no earlier rule
+// does. It removes those that started more than MaxAge ago, and those beyond
+// the latest KeepLast of each project. A zero limit does not apply.
+type Rule struct {
+	repo.SuiteSelector
+	MaxAge   time.Duration
+	KeepLast int
+}
+
+func (r Rule) String() string {
+	s := "suites"
+	if r.Project != nil {
+		s += fmt.Sprintf(" of project %q", *r.Project)
+	}
+	if r.Result != nil {
+		s += fmt.Sprintf(" that %s", *r.Result)
+	}
+	if r.MaxAge > 0 {
+		s += fmt.Sprintf(" older than %v", r.MaxAge)
+	}
+	if r.KeepLast > 0 {
+		s += fmt.Sprintf(" beyond the latest %d", r.KeepLast)
+	}
+	return s
+}
+
+type PruneRepo interface {
+	Repo
+	SuitesStartedBefore(ctx context.Context, sel repo.SuiteSelector, excl []repo.SuiteSelector, before repo.MsTime, limit int) ([]repo.Suite, error)
+	SuitesBeyondLatest(ctx context.Context, sel repo.SuiteSelector, excl []repo.SuiteSelector, keep, limit int) ([]repo.Suite, error)
+	DeleteSuite(ctx context.Context, id repo.Id, versions []int64, at repo.MsTime) error
+	InsertAuditEntry(ctx context.Context, e repo.AuditEntry) (id repo.Id, err error)
+}
+
+// ProjectRules returns the rules of the projects that override the retention
+// rules for their suites.
+func ProjectRules(ps []repo.Project) []Rule {
+	var rules []Rule
+	for _, p := range ps {
+		ret := p.Retention
+		if p.Name == nil || ret == nil {
+			continue
+		}
+		r := Rule{SuiteSelector: repo.SuiteSelector{Project: p.Name}}
+		if ret.MaxAgeDays != nil && *ret.MaxAgeDays > 0 {
+			r.MaxAge = time.Duration(*ret.MaxAgeDays) * 24 * time.Hour
+		}
+		if ret.KeepLast != nil && *ret.KeepLast > 0 {
+			r.KeepLast = int(*ret.KeepLast)
+		}
+		if r.MaxAge > 0 || r.KeepLast > 0 {
+			rules = append(rules, r)
+		}
+	}
+	return rules
+}
+
+// Pruner removes suites by retention rules, recording each in the audit log.
+type Pruner struct {
+	Repo           PruneRepo
+	UserContentDir string
+	Rules          []Rule
+	// ProjectRules, if set, returns the rules that apply before Rules, such as
+	// those of the projects that override them.
+	ProjectRules func(ctx context.Context) ([]Rule, error)
+	// BatchSize is the most suites removed at once.
+	BatchSize int
+}
+
+// Pruned is how many suites a rule removed.
+type Pruned struct {
+	Rule  Rule
+	Count int
+}
+
+// Run prunes suites every interval until ctx is done. Batches follow each
+// other until there is nothing left to prune.
+func (p *Pruner) Run(ctx context.Context, interval time.Duration) {
+	if len(p.Rules) == 0 && p.ProjectRules == nil {
+		return
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		for {
+			pruned, err := p.Prune(ctx, time.Now())
+			if err != nil && ctx.Err() == nil {
+				log.Printf("prune suites: %v", err)
+			}
+			n := 0
+			for _, pr := range pruned {
+				if pr.Count > 0 {
+					log.Printf("Pruned %d %s", pr.Count, pr.Rule)
+				}
+				n += pr.Count
+			}
+			if err != nil || n < p.batchSize() {
+				break
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+func (p *Pruner) batchSize() int {
+	if p.BatchSize > 0 {
+		return p.BatchSize
+	}
+	return defaultBatchSize
+}
+
+// Prune removes up to a batch of suites that the rules select as of now, and
+// returns how many it removed by each rule. It stops at the first error.
+func (p *Pruner) Prune(ctx context.Context, now time.Time) ([]Pruned, error) {
+	rules := p.Rules
+	if p.ProjectRules != nil {
+		projectRules, err := p.ProjectRules(ctx)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(projectRules, rules...)
+	}
+	pruned := make([]Pruned, len(rules))
+	left := p.batchSize()
+	excl := make([]repo.SuiteSelector, 0, len(rules))
+	for i, r := range rules {
+		pruned[i].Rule = r
+		var ss []repo.Suite
+		if r.MaxAge > 0 && left > 0 {
+			page, err := p.Repo.SuitesStartedBefore(ctx, r.SuiteSelector, excl,
+				repo.MsTime(now.Add(-r.MaxAge)), left)
+			if err != nil {
+				return pruned, err
+			}
+			ss = append(ss, page...)
+		}
+		if r.KeepLast > 0 && left > 0 {
+			page, err := p.Repo.SuitesBeyondLatest(ctx, r.SuiteSelector, excl,
+				r.KeepLast, left)
+			if err != nil {
+				return pruned, err
+			}
+			ss = append(ss, page...)
+		}
+		// a suite may be both too old and beyond the latest
+		removed := map[repo.Id]bool{}
+		for _, s := range ss {
+			if removed[*s.Id] || left == 0 {
+				continue
+			}
+			if err := p.remove(ctx, s, r, now); err != nil {
+				return pruned, err
+			}
+			removed[*s.Id] = true
+			pruned[i].Count++
+			left--
+		}
+		excl = append(excl, r.SuiteSelector)
+	}
+	return pruned, nil
+}
+
+// remove deletes and purges a suite at once, skipping the grace period of
+// deletes through the API.
+func (p *Pruner) remove(ctx context.Context, s repo.Suite, r Rule,
+	now time.Time) error {
+	at := repo.MsTime(now)
+	if err := p.Repo.DeleteSuite(ctx, *s.Id, nil, at); err != nil {
+		return err
+	}
+	purger := Purger{Repo: p.Repo, UserContentDir: p.UserContentDir}
+	if err := purger.purgeSuite(ctx, *s.Id); err != nil {
+		return err
+	}
+	action, actor, coll := "prune", "retention: "+r.String(), repo.Suites
+	_, err := p.Repo.InsertAuditEntry(ctx, repo.AuditEntry{
+		Action:   &action,
+		Coll:     &coll,
+		TargetId: s.Id,
+		Project:  s.Project,
+		Actor:    &actor,
+		At:       &at,
+	})
+	return err
+}
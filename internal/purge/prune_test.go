@@ -0,0 +1,179 @@
+package purge_test
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/suiteserve/suiteserve/internal/purge"
+	"github.com/suiteserve/suiteserve/internal/repo"
+	"sort"
+	"testing"
+	"time"
+)
+
+type pruneRepo struct {
+	memRepo
+	suites []repo.Suite
+	audit  []repo.AuditEntry
+}
+
+func (m *pruneRepo) selected(sel repo.SuiteSelector, excl []repo.SuiteSelector) []repo.Suite {
+	matches := func(sel repo.SuiteSelector, s repo.Suite) bool {
+		return (sel.Project == nil || s.Project != nil && *s.Project == *sel.Project) &&
+			(sel.Result == nil || s.Result != nil && *s.Result == *sel.Result)
+	}
+	gone := map[repo.Id]bool{}
+	for _, id := range m.purged {
+		gone[id] = true
+	}
+	var ss []repo.Suite
+	for _, s := range m.suites {
+		if _, deleted := m.deletedAt[*s.Id]; deleted || gone[*s.Id] ||
+			!matches(sel, s) {
+			continue
+		}
+		ok := true
+		for _, e := range excl {
+			ok = ok && !matches(e, s)
+		}
+		if ok {
+			ss = append(ss, s)
+		}
+	}
+	sort.Slice(ss, func(i, j int) bool {
+		return time.Time(*ss[i].StartedAt).Before(time.Time(*ss[j].StartedAt))
+	})
+	return ss
+}
+
+func (m *pruneRepo) SuitesStartedBefore(_ context.Context, sel repo.SuiteSelector, excl []repo.SuiteSelector, before repo.MsTime, limit int) ([]repo.Suite, error) {
+	var ss []repo.Suite
+	for _, s := range m.selected(sel, excl) {
+		if len(ss) < limit && time.Time(*s.StartedAt).Before(time.Time(before)) {
+			ss = append(ss, s)
+		}
+	}
+	return ss, nil
+}
+
+func (m *pruneRepo) SuitesBeyondLatest(_ context.Context, sel repo.SuiteSelector, excl []repo.SuiteSelector, keep, limit int) ([]repo.Suite, error) {
+	byProject := map[string][]repo.Suite{}
+	for _, s := range m.selected(sel, excl) {
+		byProject[*s.Project] = append(byProject[*s.Project], s)
+	}
+	var ss []repo.Suite
+	for _, ps := range byProject {
+		for i := len(ps) - keep - 1; i >= 0 && len(ss) < limit; i-- {
+			ss = append(ss, ps[i])
+		}
+	}
+	return ss, nil
+}
+
+func (m *pruneRepo) DeleteSuite(_ context.Context, id repo.Id, _ []int64, at repo.MsTime) error {
+	m.deletedAt[id] = time.Time(at)
+	return nil
+}
+
+func (m *pruneRepo) InsertAuditEntry(_ context.Context, e repo.AuditEntry) (repo.Id, error) {
+	m.audit = append(m.audit, e)
+	return newId(), nil
+}
+
+func (m *pruneRepo) add(project string, res repo.SuiteResult, startedAt time.Time) repo.Id {
+	id := newId()
+	at := repo.MsTime(startedAt)
+	m.suites = append(m.suites, repo.Suite{
+		Entity:    repo.Entity{Id: &id},
+		Project:   &project,
+		Result:    &res,
+		StartedAt: &at,
+	})
+	return id
+}
+
+func counts(pruned []purge.Pruned) []int {
+	var cs []int
+	for _, pr := range pruned {
+		cs = append(cs, pr.Count)
+	}
+	return cs
+}
+
+func TestPrune(t *testing.T) {
+	now := time.Now()
+	day := 24 * time.Hour
+	m := &pruneRepo{memRepo: memRepo{deletedAt: map[repo.Id]time.Time{}}}
+	oldFailed := m.add("a", repo.SuiteResultFailed, now.Add(-30*day))
+	oldPassed := m.add("a", repo.SuiteResultPassed, now.Add(-30*day))
+	m.add("a", repo.SuiteResultPassed, now.Add(-day))
+	m.add("b", repo.SuiteResultPassed, now.Add(-3*day))
+	latestB := m.add("b", repo.SuiteResultPassed, now.Add(-2*day))
+
+	failed := repo.SuiteResultFailed
+	p := purge.Pruner{
+		Repo: m,
+		Rules: []purge.Rule{
+			{SuiteSelector: repo.SuiteSelector{Result: &failed}, MaxAge: 365 * day},
+			{MaxAge: 14 * day, KeepLast: 1},
+		},
+		BatchSize: 2,
+	}
+	pruned, err := p.Prune(context.Background(), now)
+	require.Nil(t, err)
+	assert.Equal(t, []int{0, 2}, counts(pruned))
+	assert.Contains(t, m.purged, oldPassed)
+	assert.NotContains(t, m.purged, oldFailed)
+	assert.NotContains(t, m.purged, latestB)
+	require.Len(t, m.audit, 2)
+	assert.Equal(t, "prune", *m.audit[0].Action)
+	assert.Equal(t, "retention: suites older than 336h0m0s beyond the latest 1",
+		*m.audit[0].Actor)
+
+	pruned, err = p.Prune(context.Background(), now)
+	require.Nil(t, err)
+	assert.Equal(t, []int{0, 0}, counts(pruned))
+	assert.Len(t, m.purged, 2)
+}
+
+func TestPrune_ProjectRules(t *testing.T) {
+	now := time.Now()
+	day := 24 * time.Hour
+	m := &pruneRepo{memRepo: memRepo{deletedAt: map[repo.Id]time.Time{}}}
+	oldA := m.add("a", repo.SuiteResultPassed, now.Add(-30*day))
+	recentA := m.add("a", repo.SuiteResultPassed, now.Add(-3*day))
+	oldB := m.add("b", repo.SuiteResultPassed, now.Add(-30*day))
+
+	a := "a"
+	p := purge.Pruner{
+		Repo:  m,
+		Rules: []purge.Rule{{MaxAge: 14 * day}},
+		ProjectRules: func(context.Context) ([]purge.Rule, error) {
+			return purge.ProjectRules([]repo.Project{{
+				Name: &a,
+				Retention: &repo.ProjectRetention{
+					MaxAgeDays: repo.Int64(2),
+				},
+			}}), nil
+		},
+	}
+	pruned, err := p.Prune(context.Background(), now)
+	require.Nil(t, err)
+	assert.Equal(t, []int{2, 1}, counts(pruned))
+	assert.Equal(t, `suites of project "a" older than 48h0m0s`,
+		pruned[0].Rule.String())
+	assert.ElementsMatch(t, []repo.Id{oldA, recentA, oldB}, m.purged)
+}
+
+func TestProjectRules(t *testing.T) {
+	a, b, c := "a", "b", "c"
+	rules := purge.ProjectRules([]repo.Project{
+		{Name: &a, Retention: &repo.ProjectRetention{KeepLast: repo.Int64(5)}},
+		{Name: &b},
+		{Name: &c, Retention: &repo.ProjectRetention{KeepLast: repo.Int64(0)}},
+	})
+	require.Len(t, rules, 1)
+	assert.Equal(t, &a, rules[0].Project)
+	assert.Equal(t, 5, rules[0].KeepLast)
+	assert.Zero(t, rules[0].MaxAge)
+}
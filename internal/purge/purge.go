@@ -0,0 +1,83 @@
+// Package purge permanently removes suites, along with their cases, logs,
+// attachments and attachment files, some time after they are deleted or when
+// retention rules no longer keep them.
+package purge
+
+import (
+	"context"
+	"github.com/suiteserve/suiteserve/internal/repo"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+type Repo interface {
+	DeletedSuites(ctx context.Context, before repo.MsTime) ([]repo.Id, error)
+	SuiteTreeAttachments(ctx context.Context, id repo.Id) ([]repo.Attachment, error)
+	PurgeSuite(ctx context.Context, id repo.Id) error
+}
+
+// defaultGrace is the grace period of a Purger without one.
+const defaultGrace = 7 * 24 * time.Hour
+
+type Purger struct {
+	Repo           Repo
+	UserContentDir string
+	// Grace is how long deleted suites can be restored for.
+	Grace time.Duration
+}
+
+// Run purges suites every interval until ctx is done.
+func (p *Purger) Run(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		n, err := p.Purge(ctx, time.Now())
+		if err != nil && ctx.Err() == nil {
+			log.Printf("purge suites: %v", err)
+		} else if n > 0 {
+			log.Printf("Purged %d suites", n)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// Purge removes the suites that were deleted longer than the grace period
+// before now and returns how many it removed. It stops at the first error.
+func (p *Purger) Purge(ctx context.Context, now time.Time) (int, error) {
+	grace := p.Grace
+	if grace == 0 {
+		grace = defaultGrace
+	}
+	ids, err := p.Repo.DeletedSuites(ctx, repo.MsTime(now.Add(-grace)))
+	if err != nil {
+		return 0, err
+	}
+	for i, id := range ids {
+		if err := p.purgeSuite(ctx, id); err != nil {
+			return i, err
+		}
+	}
+	return len(ids), nil
+}
+
+// purgeSuite removes the attachment files of a suite before the suite, so
+// that none are left behind if it fails.
+func (p *Purger) purgeSuite(ctx context.Context, id repo.Id) error {
+	as, err := p.Repo.SuiteTreeAttachments(ctx, id)
+	if err != nil {
+		return err
+	}
+	for _, a := range as {
+		err := os.Remove(filepath.Join(p.UserContentDir, a.Id.String()))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return p.Repo.PurgeSuite(ctx, id)
+}
@@ -0,0 +1,90 @@
+package purge_test
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/suiteserve/suiteserve/internal/purge"
+	"github.com/suiteserve/suiteserve/internal/repo"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type memRepo struct {
+	deletedAt   map[repo.Id]time.Time
+	attachments map[repo.Id][]repo.Attachment
+	purged      []repo.Id
+	failPurge   bool
+}
+
+func (m *memRepo) DeletedSuites(_ context.Context, before repo.MsTime) ([]repo.Id, error) {
+	var ids []repo.Id
+	for id, at := range m.deletedAt {
+		if at.Before(time.Time(before)) {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+func (m *memRepo) SuiteTreeAttachments(_ context.Context, id repo.Id) ([]repo.Attachment, error) {
+	return m.attachments[id], nil
+}
+
+func (m *memRepo) PurgeSuite(_ context.Context, id repo.Id) error {
+	if m.failPurge {
+		return errors.New("failed")
+	}
+	delete(m.deletedAt, id)
+	m.purged = append(m.purged, id)
+	return nil
+}
+
+func newId() repo.Id {
+	return repo.Id(primitive.NewObjectID())
+}
+
+func TestPurge(t *testing.T) {
+	dir, err := ioutil.TempDir("", "purge")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	now := time.Now()
+	old, recent := newId(), newId()
+	blob, missing := newId(), newId()
+	require.Nil(t, ioutil.WriteFile(filepath.Join(dir, blob.String()), nil, 0644))
+	m := &memRepo{
+		deletedAt: map[repo.Id]time.Time{
+			old:    now.Add(-2 * time.Hour),
+			recent: now.Add(-time.Minute),
+		},
+		attachments: map[repo.Id][]repo.Attachment{
+			old: {{Entity: repo.Entity{Id: &blob}}, {Entity: repo.Entity{Id: &missing}}},
+		},
+	}
+	p := purge.Purger{Repo: m, UserContentDir: dir, Grace: time.Hour}
+
+	n, err := p.Purge(context.Background(), now)
+	require.Nil(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, []repo.Id{old}, m.purged)
+	assert.Contains(t, m.deletedAt, recent)
+	_, err = os.Stat(filepath.Join(dir, blob.String()))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestPurge_Error(t *testing.T) {
+	m := &memRepo{
+		deletedAt: map[repo.Id]time.Time{newId(): time.Unix(0, 0)},
+		failPurge: true,
+	}
+	p := purge.Purger{Repo: m, Grace: time.Hour}
+	n, err := p.Purge(context.Background(), time.Now())
+	assert.NotNil(t, err)
+	assert.Equal(t, 0, n)
+}
@@ -12,9 +12,10 @@ type Attachment struct {
 	VersionedEntity `bson:",inline"`
 	SuiteId         *Id     `json:"suiteId,omitempty" bson:"suite_id"`
 	CaseId          *Id     `json:"caseId,omitempty" bson:"case_id"`
-	Filename        *string `json:"filename,omitempty"`
-	ContentType     *string `json:"contentType,omitempty" bson:"content_type"`
-	Size            *int64  `json:"size,omitempty"`
+	Attempt         *int64  `json:"attempt,omitempty" bson:",omitempty"`
+	Filename        *string `json:"filename,omitempty" validate:"max=1024"`
+	ContentType     *string `json:"contentType,omitempty" bson:"content_type" validate:"max=256"`
+	Size            *int64  `json:"size,omitempty" validate:"readonly"`
 	Timestamp       *MsTime `json:"timestamp,omitempty"`
 }
 
@@ -57,3 +58,17 @@ func (r *Repo) CaseAttachments(ctx context.Context,
 		})
 	})
 }
+
+// CaseAttemptAttachments returns the attachments of an attempt of the case
+// with the given id.
+func (r *Repo) CaseAttemptAttachments(ctx context.Context, caseId Id,
+	attempt int64) ([]Attachment, error) {
+	as := []Attachment{}
+	return as, readAll(ctx, &as, func() (*mongo.Cursor, error) {
+		return r.db.Collection(attachments).Find(ctx, bson.D{
+			{"suite_id", nil},
+			{"case_id", caseId},
+			attemptMatch(attempt),
+		})
+	})
+}
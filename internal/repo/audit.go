@@ -0,0 +1,85 @@
+package repo
+
+import (
+	"context"
+	"encoding/json"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// AuditChange is the JSON of a field before and after a change. A missing
+// value means the field was unset.
+type AuditChange struct {
+	Before json.RawMessage `json:"before,omitempty" bson:",omitempty"`
+	After  json.RawMessage `json:"after,omitempty" bson:",omitempty"`
+}
+
+// AuditEntry records a change made through the API. Entries are only ever
+// inserted.
+type AuditEntry struct {
+	Entity     `bson:",inline"`
+	Action     *string                `json:"action,omitempty"`
+	Coll       *Coll                  `json:"coll,omitempty"`
+	TargetId   *Id                    `json:"targetId,omitempty" bson:"target_id"`
+	Project    *string                `json:"project,omitempty" bson:",omitempty"`
+	Actor      *string                `json:"actor,omitempty" bson:",omitempty"`
+	TokenId    *Id                    `json:"tokenId,omitempty" bson:"token_id,omitempty"`
+	RemoteAddr *string                `json:"remoteAddr,omitempty" bson:"remote_addr,omitempty"`
+	Diff       map[string]AuditChange `json:"diff,omitempty" bson:",omitempty"`
+	At         *MsTime                `json:"at,omitempty"`
+}
+
+// AuditFilter restricts the entries of a page. A nil field matches every
+// entry.
+type AuditFilter struct {
+	Projects []string
+	TargetId *Id
+}
+
+type AuditPage struct {
+	// Next is the cursor of the next page, if there is one.
+	Next    *Id          `json:"next,omitempty"`
+	Entries []AuditEntry `json:"entries"`
+}
+
+func (r *Repo) InsertAuditEntry(ctx context.Context, e AuditEntry) (Id, error) {
+	return r.insert(ctx, audit, e)
+}
+
+// AuditPage returns the latest entries, starting from the cursor from if it
+// is given.
+func (r *Repo) AuditPage(ctx context.Context, f AuditFilter,
+	from *Id) (AuditPage, error) {
+	const limit = 100
+	match := bson.D{}
+	if f.Projects != nil {
+		match = append(match, bson.E{"project", bson.D{
+			{"$in", f.Projects},
+		}})
+	}
+	if f.TargetId != nil {
+		match = append(match, bson.E{"target_id", *f.TargetId})
+	}
+	if from != nil {
+		match = append(match, bson.E{"_id", bson.D{
+			{"$lte", *from},
+		}})
+	}
+	page := AuditPage{
+		Entries: []AuditEntry{},
+	}
+	err := readAll(ctx, &page.Entries, func() (*mongo.Cursor, error) {
+		return r.db.Collection(audit).Find(ctx, match, options.Find().
+			SetSort(bson.D{{"_id", -1}}).
+			SetLimit(limit+1))
+	})
+	if err != nil {
+		return AuditPage{}, err
+	}
+	if len(page.Entries) > limit {
+		page.Next = page.Entries[limit].Id
+		page.Entries = page.Entries[:limit]
+	}
+	return page, nil
+}
@@ -0,0 +1,91 @@
+package repo
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"reflect"
+)
+
+// BaselineSelector selects the suites that may be the baseline of a suite: the
+// finished suites of its project that started before it.
+type BaselineSelector struct {
+	// Project is that of the suites, which have none if it is nil.
+	Project *string
+	// Labels are what the suites must have of their labels.
+	Labels map[string]string
+	// Tags, if not nil, are all the tags the suites must have.
+	Tags   []string
+	Before MsTime
+	// Exclude is the suite whose baseline is selected.
+	Exclude Id
+}
+
+func (s BaselineSelector) match() bson.D {
+	match := bson.D{
+		{"project", s.Project},
+		{"status", SuiteStatusFinished},
+		{"deleted_at", nil},
+		{"started_at", bson.D{{"$lt", s.Before}}},
+		{"_id", bson.D{{"$ne", s.Exclude}}},
+	}
+	match = append(match, labelsMatch(s.Labels)...)
+	if len(s.Tags) > 0 {
+		match = append(match, bson.E{"tags", bson.D{
+			{"$all", s.Tags},
+			{"$size", len(s.Tags)},
+		}})
+	} else if s.Tags != nil {
+		match = append(match, bson.E{"tags.0", bson.D{{"$exists", false}}})
+	}
+	return match
+}
+
+// LatestBaseline returns the latest suite that sel selects.
+func (r *Repo) LatestBaseline(ctx context.Context,
+	sel BaselineSelector) (Suite, error) {
+	var s Suite
+	return s, readOne(ctx, &s, func() (*mongo.Cursor, error) {
+		return r.db.Collection(suites).Aggregate(ctx, mongo.Pipeline{
+			{{"$match", sel.match()}},
+			{{"$sort", bson.D{
+				{"started_at", -1},
+				{"_id", -1},
+			}}},
+			{{"$limit", 1}},
+		})
+	})
+}
+
+// SetSuiteBaseline sets the baseline of the suite with the given id.
+func (r *Repo) SetSuiteBaseline(ctx context.Context, id, baselineId Id) error {
+	return r.updateById(ctx, Suites, id, nil, bson.D{
+		{"baseline_id", baselineId},
+	})
+}
+
+// RegressionSummary is how a finished suite compares with its baseline, for
+// integrations to watch.
+type RegressionSummary struct {
+	Entity       `bson:",inline"`
+	SuiteId      Id      `json:"suiteId" bson:"suite_id"`
+	BaselineId   Id      `json:"baselineId" bson:"baseline_id"`
+	Project      *string `json:"project,omitempty" bson:",omitempty"`
+	NewlyFailing int     `json:"newlyFailing" bson:"newly_failing"`
+	NewlyPassing int     `json:"newlyPassing" bson:"newly_passing"`
+	StillFailing int     `json:"stillFailing" bson:"still_failing"`
+	Added        int     `json:"added"`
+	Removed      int     `json:"removed"`
+	Slower       int     `json:"slower"`
+	// Regressions has the names of the newly failing tests, or of the first
+	// of them.
+	Regressions []string `json:"regressions,omitempty" bson:",omitempty"`
+	At          MsTime   `json:"at"`
+}
+
+var regressionSummaryType = reflect.TypeOf(RegressionSummary{})
+
+func (r *Repo) InsertRegressionSummary(ctx context.Context,
+	s RegressionSummary) (Id, error) {
+	return r.insert(ctx, Regressions, s)
+}
@@ -2,8 +2,10 @@ package repo
 
 import (
 	"context"
+	"errors"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 	"reflect"
 )
 
@@ -23,26 +25,88 @@ const (
 	CaseResultSkipped CaseResult = "skipped"
 	CaseResultAborted CaseResult = "aborted"
 	CaseResultErrored CaseResult = "errored"
+	// CaseResultPassedOnRetry is the result of a case that passed in its last
+	// attempt after failing in an earlier one. Attempts never have it.
+	CaseResultPassedOnRetry CaseResult = "passed_on_retry"
 )
 
+// Attempt is one run of a case that is retried.
+type Attempt struct {
+	Status     *CaseStatus `json:"status,omitempty"`
+	Result     *CaseResult `json:"result,omitempty" bson:",omitempty"`
+	StartedAt  *MsTime     `json:"startedAt,omitempty" bson:"started_at,omitempty"`
+	FinishedAt *MsTime     `json:"finishedAt,omitempty" bson:"finished_at,omitempty"`
+}
+
 type Case struct {
 	Entity          `bson:",inline"`
 	VersionedEntity `bson:",inline"`
-	SuiteId         *Id                        `json:"suiteId,omitempty" bson:"suite_id"`
-	Name            *string                    `json:"name,omitempty" bson:",omitempty"`
-	Description     *string                    `json:"description,omitempty" bson:",omitempty"`
-	Tags            []string                   `json:"tags,omitempty" bson:",omitempty"`
-	Idx             *int64                     `json:"idx,omitempty"`
-	Status          *CaseStatus                `json:"status,omitempty"`
-	Result          *CaseResult                `json:"result,omitempty" bson:",omitempty"`
-	CreatedAt       *MsTime                    `json:"createdAt,omitempty" bson:"created_at"`
-	StartedAt       *MsTime                    `json:"startedAt,omitempty" bson:"started_at,omitempty"`
-	FinishedAt      *MsTime                    `json:"finishedAt,omitempty" bson:"finished_at,omitempty"`
+	SuiteId         *Id               `json:"suiteId,omitempty" bson:"suite_id" validate:"required"`
+	ParentId        *Id               `json:"parentId,omitempty" bson:"parent_id,omitempty"`
+	Ancestors       []Id              `json:"-" bson:",omitempty"`
+	RunRecorded     *bool             `json:"-" bson:"run_recorded,omitempty"`
+	Name            *string           `json:"name,omitempty" bson:",omitempty" validate:"required,max=1024"`
+	Description     *string           `json:"description,omitempty" bson:",omitempty" validate:"max=4096"`
+	Tags            []string          `json:"tags,omitempty" bson:",omitempty" validate:"max=256,maxItems=64"`
+	Labels          map[string]string `json:"labels,omitempty" bson:",omitempty" validate:"keys=64,max=1024,maxItems=64"`
+	Params          map[string]string `json:"params,omitempty" bson:",omitempty" validate:"keys=64,max=1024,maxItems=32"`
+	Fingerprint     *string           `json:"fingerprint,omitempty" bson:",omitempty" validate:"readonly"`
+	Idx             *int64            `json:"idx,omitempty"`
+	Status          *CaseStatus       `json:"status,omitempty" validate:"oneof=created|started"`
+	Result          *CaseResult       `json:"result,omitempty" bson:",omitempty" validate:"readonly"`
+	CreatedAt       *MsTime           `json:"createdAt,omitempty" bson:"created_at"`
+	StartedAt       *MsTime           `json:"startedAt,omitempty" bson:"started_at,omitempty"`
+	FinishedAt      *MsTime           `json:"finishedAt,omitempty" bson:"finished_at,omitempty" validate:"readonly"`
+	Flaky           *bool             `json:"flaky,omitempty" bson:",omitempty" validate:"readonly"`
+	Quarantined     *bool             `json:"quarantined,omitempty" bson:",omitempty" validate:"readonly"`
+	Attempts        []Attempt         `json:"attempts,omitempty" bson:",omitempty" validate:"readonly"`
+	Signature       *string           `json:"signature,omitempty" bson:",omitempty" validate:"readonly"`
 }
 
 var caseType = reflect.TypeOf(Case{})
 
+// CurrentAttempt returns the number of the latest attempt of the case, which
+// is 1 until it is retried.
+func (c Case) CurrentAttempt() int64 {
+	if len(c.Attempts) == 0 {
+		return 1
+	}
+	return int64(len(c.Attempts))
+}
+
+// InsertCase inserts c with the fingerprint of the test it is a run of, for
+// which its suite must exist, and with the ancestors of its parent, which must
+// be a case of the same suite.
 func (r *Repo) InsertCase(ctx context.Context, c Case) (Id, error) {
+	var s Suite
+	if c.SuiteId != nil {
+		err := r.findByIdProj(ctx, Suites, *c.SuiteId, bson.D{
+			{"project", 1},
+		}, &s)
+		if err != nil {
+			return nilId, err
+		}
+	}
+	c.Ancestors = nil
+	c.RunRecorded = nil
+	if c.ParentId != nil {
+		var parent Case
+		err := r.findByIdProj(ctx, Cases, *c.ParentId, bson.D{
+			{"suite_id", 1},
+			{"ancestors", 1},
+		}, &parent)
+		if err != nil {
+			return nilId, err
+		}
+		if parent.SuiteId == nil || c.SuiteId == nil ||
+			*parent.SuiteId != *c.SuiteId {
+			return nilId, errConflict{errors.New(
+				"parent case belongs to another suite")}
+		}
+		c.Ancestors = append(parent.Ancestors, *c.ParentId)
+	}
+	fp := Fingerprint(s.Project, c.Name, c.Params)
+	c.Fingerprint = &fp
 	return r.insert(ctx, Cases, c)
 }
 
@@ -52,6 +116,24 @@ func (r *Repo) Case(ctx context.Context, id Id) (Case, error) {
 	return c, err
 }
 
+// CaseSubtree returns the case with the given id and its descendants, by
+// index.
+func (r *Repo) CaseSubtree(ctx context.Context, id Id) ([]Case, error) {
+	cs := []Case{}
+	err := readAll(ctx, &cs, func() (*mongo.Cursor, error) {
+		return r.db.Collection(cases).Find(ctx, bson.D{
+			{"$or", bson.A{
+				bson.D{{"_id", id}},
+				bson.D{{"ancestors", id}},
+			}},
+		}, options.Find().SetSort(bson.D{{"idx", 1}, {"_id", 1}}))
+	})
+	if err == nil && len(cs) == 0 {
+		return nil, errNotFound{}
+	}
+	return cs, err
+}
+
 func (r *Repo) SuiteCases(ctx context.Context,
 	suiteId Id) ([]Case, error) {
 	cs := []Case{}
@@ -62,11 +144,247 @@ func (r *Repo) SuiteCases(ctx context.Context,
 	})
 }
 
-func (r *Repo) FinishCase(ctx context.Context, id Id, res CaseResult,
-	at MsTime) error {
-	return r.updateById(ctx, Cases, id, bson.D{
+// UpdateCase sets the fields of the case with the given JSON names to their
+// values in c, or removes them if they are empty, like UpdateSuite.
+func (r *Repo) UpdateCase(ctx context.Context, id Id, versions []int64,
+	c Case, fields []string) error {
+	return r.updateFieldsById(ctx, Cases, id, versions, c, fields)
+}
+
+// maxFinishTries is how many times FinishCase reads and finishes a case that
+// changes in between before giving up.
+const maxFinishTries = 3
+
+// FinishCase finishes the latest attempt of the case with the given id. The
+// result of a retried case is derived from its attempts. A case that passed is
+// recorded as a run in the history of the test, while one that failed is
+// recorded when its suite ends, as it may still be retried. If it failed, the
+// case is hinted flaky if the test is, and marked quarantined if the test is
+// at the given time, so that its failure doesn't fail the suite. The failure
+// is recorded by the signature of its error output, and rolled up to the
+// ancestors of the case unless it is quarantined. A case with a failed
+// descendant fails. If versions is not nil, the case must currently have one
+// of them, or else the case is read again if it changed while finishing it.
+func (r *Repo) FinishCase(ctx context.Context, id Id, versions []int64,
+	res CaseResult, at MsTime) error {
+	for try := 1; ; try++ {
+		err := r.finishCase(ctx, id, versions, res, at)
+		if _, ok := err.(errPrecondition); !ok || versions != nil {
+			return err
+		}
+		if try == maxFinishTries {
+			return errConflict{errors.New("case changed while finishing it")}
+		}
+	}
+}
+
+func (r *Repo) finishCase(ctx context.Context, id Id, versions []int64,
+	res CaseResult, at MsTime) error {
+	var c Case
+	if err := r.findById(ctx, Cases, id, &c); err != nil {
+		return err
+	}
+	result := attemptsResult(c.Attempts, res)
+	// the run is final once the case passed
+	recordRun := (result == CaseResultPassed ||
+		result == CaseResultPassedOnRetry) && c.RunRecorded == nil
+	var set bson.D
+	if n := len(c.Attempts); n > 0 {
+		// the whole array is set for watchers to get it in updates
+		attempts := append([]Attempt{}, c.Attempts...)
+		finished := CaseStatusFinished
+		attempts[n-1].Status = &finished
+		attempts[n-1].Result = &res
+		attempts[n-1].FinishedAt = &at
+		set = append(set, bson.E{"attempts", attempts})
+	}
+	failed, _ := isFailure(res)
+	quarantined := false
+	if failed && c.Fingerprint != nil {
+		known, err := r.isFlakyTest(ctx, *c.Fingerprint)
+		if err != nil {
+			return err
+		}
+		if known {
+			set = append(set, bson.E{"flaky", true})
+		}
+		quarantined, err = r.isQuarantined(ctx, *c.Fingerprint, at)
+		if err != nil {
+			return err
+		}
+		if quarantined {
+			set = append(set, bson.E{"quarantined", true})
+		}
+	}
+	var failure *Failure
+	if failed {
+		var err error
+		if failure, err = r.failureOf(ctx, c, at); err != nil {
+			return err
+		}
+		if failure != nil {
+			set = append(set, bson.E{"signature", failure.Signature})
+		}
+	}
+	if recordRun {
+		set = append(set, bson.E{"run_recorded", true})
+	}
+	own := result
+	if result == CaseResultPassed || result == CaseResultPassedOnRetry {
+		descFailed, err := r.hasFailedDescendants(ctx, id)
+		if err != nil {
+			return err
+		}
+		if descFailed {
+			result = CaseResultFailed
+		}
+	}
+	set = append(bson.D{
 		{"status", CaseStatusFinished},
-		{"result", res},
+		{"result", result},
 		{"finished_at", at},
+	}, set...)
+	// the case is read first, so it must not have changed since
+	current := versions
+	if current == nil {
+		current = []int64{c.CurrentVersion()}
+	}
+	if err := r.updateById(ctx, Cases, id, current, set); err != nil {
+		return err
+	}
+	if failure != nil {
+		// a case has the failure of its latest failed attempt
+		_, err := r.db.Collection(failures).ReplaceOne(ctx, bson.D{
+			{"case_id", failure.CaseId},
+		}, *failure, options.Replace().SetUpsert(true))
+		if err != nil {
+			return err
+		}
+	}
+	if failed && !quarantined && len(c.Ancestors) > 0 {
+		if err := r.failAncestors(ctx, c.Ancestors); err != nil {
+			return err
+		}
+	}
+	if !recordRun {
+		return nil
+	}
+	return r.recordTestRun(ctx, c, own, at)
+}
+
+// passing matches the cases that passed, in any attempt.
+var passing = bson.E{"result", bson.D{{"$in", bson.A{
+	CaseResultPassed,
+	CaseResultPassedOnRetry,
+}}}}
+
+// blockingFailure matches the cases that failed and are not quarantined.
+var blockingFailure = bson.D{
+	{"result", bson.D{{"$in", bson.A{
+		CaseResultFailed,
+		CaseResultErrored,
+	}}}},
+	{"quarantined", bson.D{{"$ne", true}}},
+}
+
+// hasFailedDescendants reports whether a descendant of the case with the
+// given id failed in a way that fails its ancestors.
+func (r *Repo) hasFailedDescendants(ctx context.Context, id Id) (bool, error) {
+	n, err := r.db.Collection(cases).CountDocuments(ctx,
+		append(bson.D{{"ancestors", id}}, blockingFailure...))
+	return n > 0, err
+}
+
+// failAncestors rolls a failure up to the given ancestors of a case that
+// already passed. Those that haven't finished roll it up when they do.
+func (r *Repo) failAncestors(ctx context.Context, ancestors []Id) error {
+	_, err := r.db.Collection(cases).UpdateMany(ctx, bson.D{
+		{"_id", bson.D{{"$in", ancestors}}},
+		{"status", CaseStatusFinished},
+		passing,
+	}, bson.D{
+		{"$inc", bson.D{{"version", 1}}},
+		{"$set", bson.D{{"result", CaseResultFailed}}},
+	})
+	return err
+}
+
+// attemptsResult returns the result of a case whose latest attempt of the
+// given ones finished with res.
+func attemptsResult(attempts []Attempt, res CaseResult) CaseResult {
+	if res != CaseResultPassed || len(attempts) == 0 {
+		return res
+	}
+	for _, a := range attempts[:len(attempts)-1] {
+		if a.Result == nil {
+			continue
+		}
+		if failed, _ := isFailure(*a.Result); failed {
+			return CaseResultPassedOnRetry
+		}
+	}
+	return res
+}
+
+// attemptMatch matches the log lines and attachments of an attempt, where
+// those without one belong to the first.
+func attemptMatch(attempt int64) bson.E {
+	if attempt == 1 {
+		return bson.E{"attempt", bson.D{{"$in", bson.A{nil, 1}}}}
+	}
+	return bson.E{"attempt", attempt}
+}
+
+// RetryCase starts another attempt of the finished case with the given id at
+// the given time. The first retry keeps what the case has as its first
+// attempt. If versions is not nil, the case must currently have one of them.
+func (r *Repo) RetryCase(ctx context.Context, id Id, versions []int64,
+	at MsTime) error {
+	var c Case
+	if err := r.findById(ctx, Cases, id, &c); err != nil {
+		return err
+	}
+	if c.Status == nil || *c.Status != CaseStatusFinished {
+		return errConflict{errors.New("case is not finished")}
+	}
+	attempts := c.Attempts
+	if len(attempts) == 0 {
+		attempts = []Attempt{{
+			Status:     c.Status,
+			Result:     c.Result,
+			StartedAt:  c.StartedAt,
+			FinishedAt: c.FinishedAt,
+		}}
+	}
+	started := CaseStatusStarted
+	attempts = append(attempts, Attempt{
+		Status:    &started,
+		StartedAt: &at,
+	})
+	if c.StartedAt == nil {
+		c.StartedAt = &at
+	}
+	// the case is read first, so it must not have changed since
+	current := versions
+	if current == nil {
+		current = []int64{c.CurrentVersion()}
+	}
+	err := r.updateByIdWith(ctx, Cases, id, current, bson.D{
+		{"$set", bson.D{
+			{"status", CaseStatusStarted},
+			{"started_at", *c.StartedAt},
+			{"attempts", attempts},
+		}},
+		{"$unset", bson.D{
+			{"result", ""},
+			{"finished_at", ""},
+			{"flaky", ""},
+			{"quarantined", ""},
+			{"signature", ""},
+		}},
 	})
+	if _, ok := err.(errPrecondition); ok && versions == nil {
+		return errConflict{errors.New("case changed while retrying it")}
+	}
+	return err
 }
@@ -7,9 +7,24 @@ const (
 	Cases       Coll = "cases"
 	Logs        Coll = "logs"
 	Suites      Coll = "suites"
+	Quarantines Coll = "quarantines"
+	Regressions Coll = "regressions"
+	Projects    Coll = "projects"
 
 	attachments = string(Attachments)
 	cases       = string(Cases)
 	logs        = string(Logs)
 	suites      = string(Suites)
+	quarantines = string(Quarantines)
+	regressions = string(Regressions)
+	projects    = string(Projects)
+
+	idempotencyKeys = "idempotency_keys"
+	tokens          = "tokens"
+	sessions        = "sessions"
+	roleBindings    = "role_bindings"
+	groups          = "groups"
+	audit           = "audit"
+	tests           = "tests"
+	failures        = "failures"
 )
@@ -1,6 +1,10 @@
 package repo
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/mongo"
+)
 
 type errNotFound struct{}
 
@@ -27,3 +31,40 @@ func (e errBadFormat) BadFormat() {}
 func errBadId(err error) error {
 	return errBadFormat{fmt.Errorf("bad id: %v", err)}
 }
+
+type errConflict struct {
+	error
+}
+
+func (e errConflict) Error() string {
+	return fmt.Sprintf("conflict: %v", e.error)
+}
+
+func (e errConflict) Unwrap() error {
+	return e.error
+}
+
+func (e errConflict) Conflict() {}
+
+// errPrecondition means that a document was not updated because it does not
+// have the expected version.
+type errPrecondition struct{}
+
+func (errPrecondition) Error() string {
+	return "version mismatch"
+}
+
+func (errPrecondition) PreconditionFailed() {}
+
+func isDuplicateKey(err error) bool {
+	var we mongo.WriteException
+	if !errors.As(err, &we) {
+		return false
+	}
+	for _, e := range we.WriteErrors {
+		if e.Code == 11000 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,145 @@
+package repo
+
+import (
+	"context"
+	"github.com/suiteserve/suiteserve/internal/signature"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Failure is the latest failed attempt of a case, by the signature of its error
+// output.
+type Failure struct {
+	Entity    `bson:",inline"`
+	Signature string   `json:"signature"`
+	Message   string   `json:"message"`
+	Frames    []string `json:"frames,omitempty" bson:",omitempty"`
+	CaseId    Id       `json:"caseId" bson:"case_id"`
+	SuiteId   Id       `json:"suiteId" bson:"suite_id"`
+	// Project is that of the suite when the case failed.
+	Project *string `json:"project,omitempty" bson:",omitempty"`
+	At      MsTime  `json:"at"`
+}
+
+// FailureCluster is the failures with the same signature.
+type FailureCluster struct {
+	Signature string   `json:"signature" bson:"_id"`
+	Message   string   `json:"message"`
+	Frames    []string `json:"frames,omitempty" bson:",omitempty"`
+	// Count is how many failures there are, and Suites in how many suites.
+	Count     int64  `json:"count"`
+	Suites    int64  `json:"suites"`
+	FirstSeen MsTime `json:"firstSeen" bson:"first_seen"`
+	LastSeen  MsTime `json:"lastSeen" bson:"last_seen"`
+	// CaseIds has the latest failed cases.
+	CaseIds []Id `json:"caseIds" bson:"case_ids"`
+}
+
+// FailureFilter restricts failures. A nil field matches every failure.
+type FailureFilter struct {
+	Projects []string
+	SuiteId  *Id
+}
+
+// maxClusterCases is how many of the latest cases a failure cluster has.
+const maxClusterCases = 10
+
+// failureOf extracts the signature of the failure of the latest attempt of c,
+// which failed at the given time, from its error log lines. It returns nil if
+// there is no error output.
+func (r *Repo) failureOf(ctx context.Context, c Case,
+	at MsTime) (*Failure, error) {
+	var lls []LogLine
+	err := readAll(ctx, &lls, func() (*mongo.Cursor, error) {
+		return r.db.Collection(logs).Find(ctx, bson.D{
+			{"case_id", *c.Id},
+			{"error", true},
+			attemptMatch(c.CurrentAttempt()),
+		}, options.Find().SetSort(bson.D{{"idx", 1}}))
+	})
+	if err != nil {
+		return nil, err
+	}
+	output := make([]string, 0, len(lls))
+	for _, ll := range lls {
+		if ll.Line != nil {
+			output = append(output, *ll.Line)
+		}
+	}
+	sig, ok := signature.Extract(output)
+	if !ok || c.SuiteId == nil {
+		return nil, nil
+	}
+	var s Suite
+	err = r.findByIdProj(ctx, Suites, *c.SuiteId, bson.D{
+		{"project", 1},
+	}, &s)
+	if err != nil {
+		return nil, err
+	}
+	return &Failure{
+		Signature: sig.Hash(),
+		Message:   sig.Message,
+		Frames:    sig.Frames,
+		CaseId:    *c.Id,
+		SuiteId:   *c.SuiteId,
+		Project:   s.Project,
+		At:        at,
+	}, nil
+}
+
+// FailureClusters returns up to limit clusters of the failures that f
+// matches, the largest first.
+func (r *Repo) FailureClusters(ctx context.Context, f FailureFilter,
+	limit int) ([]FailureCluster, error) {
+	match := bson.D{}
+	if f.Projects != nil {
+		match = append(match, bson.E{"project", bson.D{
+			{"$in", f.Projects},
+		}})
+	}
+	if f.SuiteId != nil {
+		match = append(match, bson.E{"suite_id", *f.SuiteId})
+	}
+	cs := []FailureCluster{}
+	return cs, readAll(ctx, &cs, func() (*mongo.Cursor, error) {
+		return r.db.Collection(failures).Aggregate(ctx, mongo.Pipeline{
+			{{"$match", match}},
+			{{"$sort", bson.D{{"at", -1}, {"_id", -1}}}},
+			{{"$group", bson.D{
+				{"_id", "$signature"},
+				{"message", bson.D{{"$first", "$message"}}},
+				{"frames", bson.D{{"$first", "$frames"}}},
+				{"count", bson.D{{"$sum", 1}}},
+				{"suites", bson.D{{"$addToSet", "$suite_id"}}},
+				{"first_seen", bson.D{{"$min", "$at"}}},
+				{"last_seen", bson.D{{"$max", "$at"}}},
+			}}},
+			{{"$sort", bson.D{{"count", -1}, {"last_seen", -1}}}},
+			{{"$limit", limit}},
+			// the latest cases are looked up for the clusters that are
+			// returned rather than collected for every cluster
+			{{"$lookup", bson.D{
+				{"from", failures},
+				{"let", bson.D{{"signature", "$_id"}}},
+				{"pipeline", bson.A{
+					bson.D{{"$match", append(bson.D{
+						{"$expr", bson.D{{"$eq", bson.A{
+							"$signature",
+							"$$signature",
+						}}}},
+					}, match...)}},
+					bson.D{{"$sort", bson.D{{"at", -1}, {"_id", -1}}}},
+					bson.D{{"$limit", maxClusterCases}},
+					bson.D{{"$project", bson.D{{"case_id", 1}}}},
+				}},
+				{"as", "case_ids"},
+			}}},
+			{{"$set", bson.D{
+				{"suites", bson.D{{"$size", "$suites"}}},
+				{"case_ids", "$case_ids.case_id"},
+			}}},
+		})
+	})
+}
@@ -0,0 +1,55 @@
+package repo
+
+import (
+	"reflect"
+	"strings"
+)
+
+// eachField calls fn with each field of the struct v, including the fields of
+// embedded structs instead of the structs themselves.
+func eachField(v reflect.Value, fn func(sf reflect.StructField,
+	fv reflect.Value)) {
+	v = reflect.Indirect(v)
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if sf := t.Field(i); sf.Anonymous {
+			eachField(v.Field(i), fn)
+		} else {
+			fn(sf, v.Field(i))
+		}
+	}
+}
+
+func jsonName(sf reflect.StructField) string {
+	if name := strings.Split(sf.Tag.Get("json"), ",")[0]; name != "" {
+		return name
+	}
+	return sf.Name
+}
+
+// bsonName returns the name of a field in the database, which like the driver
+// defaults to the lowercase name of the field.
+func bsonName(sf reflect.StructField) string {
+	if name := strings.Split(sf.Tag.Get("bson"), ",")[0]; name != "" {
+		return name
+	}
+	return strings.ToLower(sf.Name)
+}
+
+// jsonNames maps the database names of fields of the struct type t to their
+// JSON names, leaving unknown names as they are.
+func jsonNames(t reflect.Type, names []string) []string {
+	byBson := map[string]string{}
+	eachField(reflect.New(t), func(sf reflect.StructField, _ reflect.Value) {
+		byBson[bsonName(sf)] = jsonName(sf)
+	})
+	out := make([]string, len(names))
+	for i, n := range names {
+		if j, ok := byBson[n]; ok {
+			out[i] = j
+		} else {
+			out[i] = n
+		}
+	}
+	return out
+}
@@ -0,0 +1,98 @@
+package repo
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Fingerprint identifies a test across suites by the project of its suite, its
+// name and its parameters. Cases keep the fingerprint they were inserted with,
+// even if the project of their suite changes.
+func Fingerprint(project, name *string, params map[string]string) string {
+	if len(params) == 0 {
+		params = nil
+	}
+	// maps are marshalled with sorted keys
+	b := mustMarshalJSON([]interface{}{
+		stringOr(project, ""),
+		stringOr(name, ""),
+		params,
+	})
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:16])
+}
+
+func stringOr(s *string, or string) string {
+	if s == nil {
+		return or
+	}
+	return *s
+}
+
+// TestRun is a case as a run of the test with its fingerprint.
+type TestRun struct {
+	CaseId     Id          `json:"caseId" bson:"_id"`
+	SuiteId    *Id         `json:"suiteId,omitempty" bson:"suite_id"`
+	Name       *string     `json:"name,omitempty"`
+	Status     *CaseStatus `json:"status,omitempty"`
+	Result     *CaseResult `json:"result,omitempty"`
+	StartedAt  *MsTime     `json:"startedAt,omitempty" bson:"started_at"`
+	FinishedAt *MsTime     `json:"finishedAt,omitempty" bson:"finished_at"`
+	// DurationMs is how long a finished run took.
+	DurationMs *int64 `json:"durationMs,omitempty" bson:"-"`
+}
+
+// TestRuns returns the latest runs of the test with the given fingerprint, up
+// to limit, latest first. Cases of deleted suites are left out.
+func (r *Repo) TestRuns(ctx context.Context, fingerprint string,
+	limit int) ([]TestRun, error) {
+	runs := []TestRun{}
+	err := readAll(ctx, &runs, func() (*mongo.Cursor, error) {
+		return r.db.Collection(cases).Aggregate(ctx,
+			testRunsPipeline(fingerprint, limit))
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i, run := range runs {
+		if run.StartedAt != nil && run.FinishedAt != nil {
+			d := run.FinishedAt.toMs() - run.StartedAt.toMs()
+			runs[i].DurationMs = &d
+		}
+	}
+	return runs, nil
+}
+
+func testRunsPipeline(fingerprint string, limit int) mongo.Pipeline {
+	return mongo.Pipeline{
+		{{"$match", bson.D{{"fingerprint", fingerprint}}}},
+		{{"$sort", bson.D{{"created_at", -1}, {"_id", -1}}}},
+		{{"$lookup", bson.D{
+			{"from", suites},
+			{"let", bson.D{{"suite_id", "$suite_id"}}},
+			{"pipeline", bson.A{
+				bson.D{{"$match", bson.D{
+					{"$expr", bson.D{{"$eq", bson.A{"$_id", "$$suite_id"}}}},
+					{"deleted_at", nil},
+				}}},
+				bson.D{{"$project", bson.D{{"_id", 1}}}},
+			}},
+			{"as", "suite"},
+		}}},
+		{{"$match", bson.D{{"suite", bson.D{{"$ne", bson.A{}}}}}}},
+		{{"$limit", limit}},
+		{{"$project", testRunProjection}},
+	}
+}
+
+var testRunProjection = bson.D{
+	{"suite_id", 1},
+	{"name", 1},
+	{"status", 1},
+	{"result", 1},
+	{"started_at", 1},
+	{"finished_at", 1},
+}
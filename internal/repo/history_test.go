@@ -0,0 +1,31 @@
+package repo
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"testing"
+)
+
+func TestTestRunsPipeline(t *testing.T) {
+	p := testRunsPipeline("abc", 10)
+	var stages []string
+	for _, stage := range p {
+		stages = append(stages, stage[0].Key)
+	}
+	// deleted suites are left out before the limit, so that they don't take
+	// the place of runs
+	require.Equal(t, []string{
+		"$match", "$sort", "$lookup", "$match", "$limit", "$project",
+	}, stages)
+	assert.Equal(t, bson.D{{"fingerprint", "abc"}}, p[0][0].Value)
+	assert.Equal(t, 10, p[4][0].Value)
+
+	lookup := p[2][0].Value.(bson.D).Map()
+	assert.Equal(t, suites, lookup["from"])
+	match := lookup["pipeline"].(bson.A)[0].(bson.D).Map()["$match"].(bson.D)
+	assert.Contains(t, match, bson.E{"deleted_at", nil})
+	assert.Equal(t, bson.D{{lookup["as"].(string), bson.D{
+		{"$ne", bson.A{}},
+	}}}, p[3][0].Value)
+}
@@ -0,0 +1,125 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"time"
+)
+
+// idempotencyLease is how long a call may take before a key without a result
+// is taken to have been left by a call that failed to finish.
+const idempotencyLease = time.Minute
+
+// idempotencyStoreTimeout is how long storing the result of a call may take.
+// It doesn't depend on the context of the call, which may be canceled once fn
+// is done.
+const idempotencyStoreTimeout = 10 * time.Second
+
+type idempotencyKey struct {
+	Scope  string `bson:"scope"`
+	Key    string `bson:"key"`
+	Result []byte `bson:"result,omitempty"`
+	// Failed is whether fn succeeded but its result couldn't be stored.
+	Failed    bool   `bson:"failed,omitempty"`
+	CreatedAt MsTime `bson:"created_at"`
+}
+
+// errInProgress means that a call with the same idempotency key is still
+// running, and that the call may be made again later.
+type errInProgress struct{}
+
+func (errInProgress) Error() string {
+	return "request with idempotency key in progress"
+}
+
+func (errInProgress) InProgress() {}
+
+// Idempotent calls fn at most once for a scope and key, storing and returning
+// its result. Later calls with the same scope and key return the stored result
+// without calling fn, until the key expires. If fn returns an error, nothing is
+// stored and the key may be used again. If fn succeeds but its result can't be
+// stored, the key is marked failed, and later calls fail with a conflict
+// rather than calling fn again. A call made while fn is still running for the
+// same scope and key fails with errInProgress, unless the other call has been
+// running for longer than idempotencyLease, in which case this call takes over
+// the key.
+func (r *Repo) Idempotent(ctx context.Context, scope, key string,
+	fn func() ([]byte, error)) ([]byte, error) {
+	coll := r.db.Collection(idempotencyKeys)
+	filter := bson.D{
+		{"scope", scope},
+		{"key", key},
+	}
+	now := MsTime(time.Now())
+	_, err := coll.InsertOne(ctx, idempotencyKey{
+		Scope:     scope,
+		Key:       key,
+		CreatedAt: now,
+	})
+	if isDuplicateKey(err) {
+		var k idempotencyKey
+		err := coll.FindOne(ctx, filter).Decode(&k)
+		if err == mongo.ErrNoDocuments {
+			return nil, errInProgress{}
+		} else if err != nil {
+			return nil, err
+		}
+		if k.Result != nil {
+			return k.Result, nil
+		}
+		if k.Failed {
+			return nil, errConflict{errors.New("request with idempotency " +
+				"key was applied, but its response was lost")}
+		}
+		if time.Time(now).Sub(time.Time(k.CreatedAt)) < idempotencyLease {
+			return nil, errInProgress{}
+		}
+		// only one of the calls that find the same expired lease renews it
+		res, err := coll.UpdateOne(ctx, bson.D{
+			{"scope", scope},
+			{"key", key},
+			{"result", nil},
+			{"failed", nil},
+			{"created_at", k.CreatedAt},
+		}, bson.D{
+			{"$set", bson.D{
+				{"created_at", now},
+			}},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if res.ModifiedCount == 0 {
+			return nil, errInProgress{}
+		}
+	} else if err != nil {
+		return nil, err
+	}
+	res, err := fn()
+	if err != nil {
+		if _, derr := coll.DeleteOne(ctx, filter); derr != nil {
+			return nil, derr
+		}
+		return nil, err
+	}
+	storeCtx, cancel := context.WithTimeout(context.Background(),
+		idempotencyStoreTimeout)
+	defer cancel()
+	_, err = coll.UpdateOne(storeCtx, filter, bson.D{
+		{"$set", bson.D{
+			{"result", res},
+		}},
+	})
+	if err != nil {
+		// fn must not be called again, as what it did stays done
+		_, _ = coll.UpdateOne(storeCtx, filter, bson.D{
+			{"$set", bson.D{
+				{"failed", true},
+			}},
+		})
+		return nil, err
+	}
+	return res, nil
+}
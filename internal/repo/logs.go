@@ -8,11 +8,13 @@ import (
 )
 
 type LogLine struct {
-	Entity `bson:",inline"`
-	CaseId *Id     `json:"caseId,omitempty" bson:"case_id"`
-	Idx    *int64  `json:"idx,omitempty"`
-	Error  *bool   `json:"error,omitempty" bson:",omitempty"`
-	Line   *string `json:"line,omitempty" bson:",omitempty"`
+	Entity  `bson:",inline"`
+	SuiteId *Id     `json:"suiteId,omitempty" bson:"suite_id,omitempty"`
+	CaseId  *Id     `json:"caseId,omitempty" bson:"case_id"`
+	Attempt *int64  `json:"attempt,omitempty" bson:",omitempty"`
+	Idx     *int64  `json:"idx,omitempty" validate:"required"`
+	Error   *bool   `json:"error,omitempty" bson:",omitempty"`
+	Line    *string `json:"line,omitempty" bson:",omitempty" validate:"max=65536"`
 }
 
 var logLineType = reflect.TypeOf(LogLine{})
@@ -21,12 +23,34 @@ func (r *Repo) InsertLogLine(ctx context.Context, ll LogLine) (Id, error) {
 	return r.insert(ctx, Logs, ll)
 }
 
+func (r *Repo) InsertLogLines(ctx context.Context,
+	lls []LogLine) ([]Id, error) {
+	if len(lls) == 0 {
+		return []Id{}, nil
+	}
+	vs := make([]interface{}, len(lls))
+	for i, ll := range lls {
+		vs[i] = ll
+	}
+	return r.insertMany(ctx, Logs, vs)
+}
+
 func (r *Repo) LogLine(ctx context.Context, id Id) (LogLine, error) {
 	var ll LogLine
 	err := r.findById(ctx, Logs, id, &ll)
 	return ll, err
 }
 
+func (r *Repo) SuiteLogLines(ctx context.Context,
+	suiteId Id) ([]LogLine, error) {
+	lls := []LogLine{}
+	return lls, readAll(ctx, &lls, func() (*mongo.Cursor, error) {
+		return r.db.Collection(logs).Find(ctx, bson.D{
+			{"suite_id", suiteId},
+		})
+	})
+}
+
 func (r *Repo) CaseLogLines(ctx context.Context,
 	caseId Id) ([]LogLine, error) {
 	lls := []LogLine{}
@@ -36,3 +60,16 @@ func (r *Repo) CaseLogLines(ctx context.Context,
 		})
 	})
 }
+
+// CaseAttemptLogLines returns the log lines of an attempt of the case with the
+// given id.
+func (r *Repo) CaseAttemptLogLines(ctx context.Context, caseId Id,
+	attempt int64) ([]LogLine, error) {
+	lls := []LogLine{}
+	return lls, readAll(ctx, &lls, func() (*mongo.Cursor, error) {
+		return r.db.Collection(logs).Find(ctx, bson.D{
+			{"case_id", caseId},
+			attemptMatch(attempt),
+		})
+	})
+}
@@ -0,0 +1,186 @@
+package repo
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+// maxRunningSuites is how many running suites a project summary has.
+const maxRunningSuites = 100
+
+// Project is the settings of the suites with its name as their project. It is
+// created with the first of them.
+type Project struct {
+	Entity          `bson:",inline"`
+	VersionedEntity `bson:",inline"`
+	Name            *string `json:"name,omitempty" bson:",omitempty" validate:"readonly"`
+	DisplayName     *string `json:"displayName,omitempty" bson:"display_name,omitempty" validate:"max=256"`
+	DefaultBranch   *string `json:"defaultBranch,omitempty" bson:"default_branch,omitempty" validate:"max=256"`
+	// Retention, if set, replaces the retention rules of the config for the
+	// suites of the project.
+	Retention *ProjectRetention `json:"retention,omitempty" bson:",omitempty" validate:"dive"`
+	// Baseline, if set, selects the baseline of the suites of the project
+	// before the baseline rules of the config.
+	Baseline *ProjectBaseline `json:"baseline,omitempty" bson:",omitempty" validate:"dive"`
+	// Owners are who to contact about the project.
+	Owners    []string `json:"owners,omitempty" bson:",omitempty" validate:"max=256,maxItems=64"`
+	CreatedAt *MsTime  `json:"createdAt,omitempty" bson:"created_at" validate:"readonly"`
+}
+
+// ProjectRetention keeps the suites of a project for MaxAgeDays and only the
+// latest KeepLast of them. Limits that aren't positive do not apply.
+type ProjectRetention struct {
+	MaxAgeDays *int64 `json:"maxAgeDays,omitempty" bson:"max_age_days,omitempty"`
+	KeepLast   *int64 `json:"keepLast,omitempty" bson:"keep_last,omitempty"`
+}
+
+// ProjectBaseline selects the latest earlier finished suite of the project with
+// Labels, with the same values of SameLabels and, if SameTags, with the same
+// tags.
+type ProjectBaseline struct {
+	Labels     map[string]string `json:"labels,omitempty" bson:",omitempty" validate:"keys=64,max=1024,maxItems=64"`
+	SameLabels []string          `json:"sameLabels,omitempty" bson:"same_labels,omitempty" validate:"max=64,maxItems=64"`
+	SameTags   *bool             `json:"sameTags,omitempty" bson:"same_tags,omitempty"`
+}
+
+// ProjectFilter restricts projects. A nil field matches every project.
+type ProjectFilter struct {
+	Names []string
+}
+
+// ProjectSummary is the state of the suites of a project.
+type ProjectSummary struct {
+	Project Project `json:"project"`
+	// Latest is the suite that started last.
+	Latest *Suite `json:"latest,omitempty"`
+	// Passed is how many of the Finished latest finished suites passed, and
+	// PassRate their ratio.
+	Finished int      `json:"finished"`
+	Passed   int      `json:"passed"`
+	PassRate *float64 `json:"passRate,omitempty"`
+	Running  []Suite  `json:"running"`
+}
+
+// ensureProject creates the project with the given name if there is none.
+func (r *Repo) ensureProject(ctx context.Context, name string) error {
+	_, err := r.db.Collection(projects).UpdateOne(ctx, bson.D{
+		{"name", name},
+	}, bson.D{
+		{"$setOnInsert", bson.D{
+			{"created_at", MsTime(time.Now())},
+		}},
+	}, options.Update().SetUpsert(true))
+	if isDuplicateKey(err) {
+		// created concurrently
+		return nil
+	}
+	return err
+}
+
+func (r *Repo) Project(ctx context.Context, id Id) (Project, error) {
+	var p Project
+	err := r.findById(ctx, Projects, id, &p)
+	return p, err
+}
+
+func (r *Repo) ProjectByName(ctx context.Context,
+	name string) (Project, error) {
+	var p Project
+	return p, readOne(ctx, &p, func() (*mongo.Cursor, error) {
+		return r.db.Collection(projects).Find(ctx, bson.D{{"name", name}},
+			options.Find().SetLimit(1))
+	})
+}
+
+// Projects returns the projects that f matches by name.
+func (r *Repo) Projects(ctx context.Context,
+	f ProjectFilter) ([]Project, error) {
+	match := bson.D{}
+	if f.Names != nil {
+		match = append(match, bson.E{"name", bson.D{{"$in", f.Names}}})
+	}
+	ps := []Project{}
+	return ps, readAll(ctx, &ps, func() (*mongo.Cursor, error) {
+		return r.db.Collection(projects).Find(ctx, match,
+			options.Find().SetSort(bson.D{{"name", 1}}))
+	})
+}
+
+func (r *Repo) UpdateProject(ctx context.Context, id Id, versions []int64,
+	p Project, fields []string) error {
+	return r.updateFieldsById(ctx, Projects, id, versions, p, fields)
+}
+
+// ProjectSummary summarizes the suites of the project with the given name,
+// with the pass rate of its latest last finished suites.
+func (r *Repo) ProjectSummary(ctx context.Context, name string,
+	last int) (ProjectSummary, error) {
+	p, err := r.ProjectByName(ctx, name)
+	if err != nil {
+		return ProjectSummary{}, err
+	}
+	var facets struct {
+		Latest []Suite
+		Recent []struct {
+			Result *SuiteResult
+		}
+		Running []Suite
+	}
+	err = readOne(ctx, &facets, func() (*mongo.Cursor, error) {
+		return r.db.Collection(suites).Aggregate(ctx, mongo.Pipeline{
+			{{"$match", bson.D{
+				{"project", name},
+				{"deleted_at", nil},
+			}}},
+			{{"$sort", bson.D{
+				{"started_at", -1},
+				{"_id", -1},
+			}}},
+			{{"$facet", bson.D{
+				{"latest", bson.A{
+					bson.D{{"$limit", 1}},
+				}},
+				{"recent", bson.A{
+					bson.D{{"$match", bson.D{
+						{"status", SuiteStatusFinished},
+					}}},
+					bson.D{{"$limit", last}},
+					bson.D{{"$project", bson.D{{"result", 1}}}},
+				}},
+				{"running", bson.A{
+					bson.D{{"$match", bson.D{
+						{"status", SuiteStatusStarted},
+					}}},
+					bson.D{{"$limit", maxRunningSuites}},
+				}},
+			}}},
+		})
+	})
+	if err != nil {
+		return ProjectSummary{}, err
+	}
+	sum := ProjectSummary{
+		Project:  p,
+		Finished: len(facets.Recent),
+		Running:  facets.Running,
+	}
+	if len(facets.Latest) > 0 {
+		sum.Latest = &facets.Latest[0]
+	}
+	for _, s := range facets.Recent {
+		if s.Result != nil && *s.Result == SuiteResultPassed {
+			sum.Passed++
+		}
+	}
+	if sum.Finished > 0 {
+		rate := float64(sum.Passed) / float64(sum.Finished)
+		sum.PassRate = &rate
+	}
+	if sum.Running == nil {
+		sum.Running = []Suite{}
+	}
+	return sum, nil
+}
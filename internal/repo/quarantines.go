@@ -0,0 +1,112 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+// Quarantine keeps the failures of a test from failing the suites it runs in
+// until it expires. The test keeps running, and its cases keep their results.
+type Quarantine struct {
+	Entity          `bson:",inline"`
+	VersionedEntity `bson:",inline"`
+	Fingerprint     *string           `json:"fingerprint,omitempty" validate:"readonly"`
+	Project         *string           `json:"project,omitempty" bson:",omitempty" validate:"max=256,not=*"`
+	Name            *string           `json:"name,omitempty" bson:",omitempty" validate:"required,max=1024"`
+	Params          map[string]string `json:"params,omitempty" bson:",omitempty" validate:"keys=64,max=1024,maxItems=32"`
+	Owner           *string           `json:"owner,omitempty" bson:",omitempty" validate:"required,max=256"`
+	Reason          *string           `json:"reason,omitempty" bson:",omitempty" validate:"required,max=4096"`
+	ExpiresAt       *MsTime           `json:"expiresAt,omitempty" bson:"expires_at" validate:"required"`
+	CreatedAt       *MsTime           `json:"createdAt,omitempty" bson:"created_at" validate:"readonly"`
+}
+
+// QuarantinedTest is how a runner finds a quarantined test among its own.
+type QuarantinedTest struct {
+	Fingerprint string            `json:"fingerprint"`
+	Name        string            `json:"name"`
+	Params      map[string]string `json:"params,omitempty"`
+	ExpiresAt   MsTime            `json:"expiresAt"`
+}
+
+// QuarantineFilter restricts quarantines. A nil field matches every
+// quarantine.
+type QuarantineFilter struct {
+	Projects []string
+	// ActiveAt selects the quarantines that have not expired at that time.
+	ActiveAt *MsTime
+}
+
+func (f QuarantineFilter) match() bson.D {
+	match := bson.D{}
+	if f.Projects != nil {
+		match = append(match, bson.E{"project", bson.D{
+			{"$in", f.Projects},
+		}})
+	}
+	if f.ActiveAt != nil {
+		match = append(match, bson.E{"expires_at", bson.D{
+			{"$gt", *f.ActiveAt},
+		}})
+	}
+	return match
+}
+
+// InsertQuarantine quarantines the test of q by its fingerprint. A test can
+// only be quarantined once.
+func (r *Repo) InsertQuarantine(ctx context.Context, q Quarantine) (Id, error) {
+	fp := Fingerprint(q.Project, q.Name, q.Params)
+	q.Fingerprint = &fp
+	if q.CreatedAt == nil {
+		now := MsTime(time.Now())
+		q.CreatedAt = &now
+	}
+	id, err := r.insert(ctx, Quarantines, q)
+	if isDuplicateKey(err) {
+		return nilId, errConflict{errors.New("test is already quarantined")}
+	}
+	return id, err
+}
+
+func (r *Repo) Quarantine(ctx context.Context, id Id) (Quarantine, error) {
+	var q Quarantine
+	err := r.findById(ctx, Quarantines, id, &q)
+	return q, err
+}
+
+// Quarantines returns the quarantines that f matches, those expiring first
+// first.
+func (r *Repo) Quarantines(ctx context.Context,
+	f QuarantineFilter) ([]Quarantine, error) {
+	qs := []Quarantine{}
+	return qs, readAll(ctx, &qs, func() (*mongo.Cursor, error) {
+		return r.db.Collection(quarantines).Find(ctx, f.match(),
+			options.Find().SetSort(bson.D{{"expires_at", 1}, {"_id", 1}}))
+	})
+}
+
+// UpdateQuarantine sets the fields of the quarantine with the given JSON
+// names to their values in q, or removes them if they are empty. If versions
+// is not nil, the quarantine must currently have one of them.
+func (r *Repo) UpdateQuarantine(ctx context.Context, id Id, versions []int64,
+	q Quarantine, fields []string) error {
+	return r.updateFieldsById(ctx, Quarantines, id, versions, q, fields)
+}
+
+func (r *Repo) DeleteQuarantine(ctx context.Context, id Id) error {
+	return r.deleteById(ctx, Quarantines, id)
+}
+
+// isQuarantined reports whether the test with the given fingerprint is
+// quarantined at the given time.
+func (r *Repo) isQuarantined(ctx context.Context, fingerprint string,
+	at MsTime) (bool, error) {
+	n, err := r.db.Collection(quarantines).CountDocuments(ctx, bson.D{
+		{"fingerprint", fingerprint},
+		{"expires_at", bson.D{{"$gt", at}}},
+	})
+	return n > 0, err
+}
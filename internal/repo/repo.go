@@ -8,17 +8,26 @@ import (
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"reflect"
 	"time"
 )
 
 const timeout = 10 * time.Second
 
 type Entity struct {
-	Id *Id `json:"id,omitempty" bson:"_id,omitempty"`
+	Id *Id `json:"id,omitempty" bson:"_id,omitempty" validate:"readonly"`
 }
 
 type VersionedEntity struct {
-	Version *int64 `json:"version,omitempty"`
+	Version *int64 `json:"version,omitempty" validate:"readonly"`
+}
+
+// CurrentVersion returns the version, which is 0 until the first update.
+func (e VersionedEntity) CurrentVersion() int64 {
+	if e.Version == nil {
+		return 0
+	}
+	return *e.Version
 }
 
 type Repo struct {
@@ -67,6 +76,19 @@ func (r *Repo) insert(ctx context.Context, coll Coll,
 	return Id(res.InsertedID.(primitive.ObjectID)), nil
 }
 
+func (r *Repo) insertMany(ctx context.Context, coll Coll,
+	vs []interface{}) ([]Id, error) {
+	res, err := r.db.Collection(string(coll)).InsertMany(ctx, vs)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]Id, len(res.InsertedIDs))
+	for i, id := range res.InsertedIDs {
+		ids[i] = Id(id.(primitive.ObjectID))
+	}
+	return ids, nil
+}
+
 func (r *Repo) findByIdProj(ctx context.Context, coll Coll, id Id,
 	proj, v interface{}) error {
 	filter := bson.D{{"_id", id}}
@@ -83,18 +105,93 @@ func (r *Repo) findById(ctx context.Context, coll Coll, id Id,
 	return r.findByIdProj(ctx, coll, id, nil, v)
 }
 
+// updateById sets fields of the document with the given id and increments its
+// version. If versions is not nil, the document is only updated if its current
+// version is one of them, or else errPrecondition is returned.
 func (r *Repo) updateById(ctx context.Context, coll Coll, id Id,
-	set interface{}) error {
+	versions []int64, set interface{}) error {
+	return r.updateByIdWith(ctx, coll, id, versions, bson.D{{"$set", set}})
+}
+
+// updateFieldsById sets the fields of the document with the given id that
+// have the given JSON names to their values in v, a struct, or removes them if
+// they are empty. It is otherwise like updateById.
+func (r *Repo) updateFieldsById(ctx context.Context, coll Coll, id Id,
+	versions []int64, v interface{}, fields []string) error {
+	only := map[string]bool{}
+	for _, f := range fields {
+		only[f] = true
+	}
+	set, unset := bson.D{}, bson.D{}
+	eachField(reflect.ValueOf(v), func(sf reflect.StructField,
+		fv reflect.Value) {
+		if !only[jsonName(sf)] {
+			return
+		}
+		if fv.IsZero() || (fv.Kind() == reflect.Slice ||
+			fv.Kind() == reflect.Map) && fv.Len() == 0 {
+			unset = append(unset, bson.E{bsonName(sf), ""})
+		} else {
+			set = append(set, bson.E{bsonName(sf), fv.Interface()})
+		}
+	})
+	var update bson.D
+	if len(set) > 0 {
+		update = append(update, bson.E{"$set", set})
+	}
+	if len(unset) > 0 {
+		update = append(update, bson.E{"$unset", unset})
+	}
+	return r.updateByIdWith(ctx, coll, id, versions, update)
+}
+
+// updateByIdWith applies update to the document with the given id and
+// increments its version, like updateById.
+func (r *Repo) updateByIdWith(ctx context.Context, coll Coll, id Id,
+	versions []int64, update bson.D) error {
 	filter := bson.D{{"_id", id}}
-	update := bson.D{
-		{"$inc", bson.D{{"version", 1}}},
-		{"$set", set},
+	if versions != nil {
+		in := bson.A{}
+		for _, v := range versions {
+			if v == 0 {
+				in = append(in, nil)
+			}
+			in = append(in, v)
+		}
+		filter = append(filter, bson.E{"version", bson.D{
+			{"$in", in},
+		}})
 	}
+	update = append(bson.D{{"$inc", bson.D{{"version", 1}}}}, update...)
 	res, err := r.db.Collection(string(coll)).UpdateOne(ctx, filter, update)
 	if err != nil {
 		return err
 	}
-	if res.MatchedCount == 0 {
+	if res.MatchedCount > 0 {
+		return nil
+	}
+	if versions != nil {
+		n, err := r.db.Collection(string(coll)).CountDocuments(ctx, bson.D{
+			{"_id", id},
+		})
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			return errPrecondition{}
+		}
+	}
+	return errNotFound{}
+}
+
+func (r *Repo) deleteById(ctx context.Context, coll Coll, id Id) error {
+	res, err := r.db.Collection(string(coll)).DeleteOne(ctx, bson.D{
+		{"_id", id},
+	})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
 		return errNotFound{}
 	}
 	return nil
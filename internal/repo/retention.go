@@ -0,0 +1,107 @@
+package repo
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// SuiteSelector selects suites by project and result. A nil field selects
+// every suite.
+type SuiteSelector struct {
+	Project *string
+	Result  *SuiteResult
+}
+
+func (s SuiteSelector) match() bson.D {
+	match := bson.D{}
+	if s.Project != nil {
+		match = append(match, bson.E{"project", *s.Project})
+	}
+	if s.Result != nil {
+		match = append(match, bson.E{"result", *s.Result})
+	}
+	return match
+}
+
+// prunable matches the suites that sel selects and none of excl do, which are
+// not deleted.
+func prunable(sel SuiteSelector, excl []SuiteSelector) bson.D {
+	match := append(sel.match(), bson.E{"deleted_at", nil})
+	if len(excl) > 0 {
+		nor := make(bson.A, len(excl))
+		for i, s := range excl {
+			nor[i] = s.match()
+		}
+		match = append(match, bson.E{"$nor", nor})
+	}
+	return match
+}
+
+// notStarted matches suites that are no longer running.
+var notStarted = bson.E{"status", bson.D{{"$ne", SuiteStatusStarted}}}
+
+// SuitesStartedBefore returns up to limit suites that sel selects and none of
+// excl do, which started before the given time and are neither running nor
+// deleted, oldest first.
+func (r *Repo) SuitesStartedBefore(ctx context.Context, sel SuiteSelector,
+	excl []SuiteSelector, before MsTime, limit int) ([]Suite, error) {
+	match := append(prunable(sel, excl), notStarted, bson.E{"started_at",
+		bson.D{{"$lt", before}}})
+	ss := []Suite{}
+	return ss, readAll(ctx, &ss, func() (*mongo.Cursor, error) {
+		return r.db.Collection(suites).Aggregate(ctx, mongo.Pipeline{
+			{{"$match", match}},
+			{{"$sort", bson.D{
+				{"started_at", 1},
+				{"_id", 1},
+			}}},
+			{{"$limit", limit}},
+		})
+	})
+}
+
+// SuitesBeyondLatest returns up to limit suites that sel selects and none of
+// excl do, other than the latest keep of each project, which are neither
+// running nor deleted. Suites without a project count as one project.
+func (r *Repo) SuitesBeyondLatest(ctx context.Context, sel SuiteSelector,
+	excl []SuiteSelector, keep, limit int) ([]Suite, error) {
+	match := prunable(sel, excl)
+	matches := []bson.D{match}
+	if sel.Project == nil {
+		projects, err := r.db.Collection(suites).Distinct(ctx, "project",
+			match)
+		if err != nil {
+			return nil, err
+		}
+		matches = nil
+		for _, p := range append(projects, nil) {
+			m := append(bson.D{{"project", p}}, match...)
+			matches = append(matches, m)
+		}
+	}
+	ss := []Suite{}
+	for _, m := range matches {
+		if len(ss) >= limit {
+			break
+		}
+		var page []Suite
+		err := readAll(ctx, &page, func() (*mongo.Cursor, error) {
+			return r.db.Collection(suites).Aggregate(ctx, mongo.Pipeline{
+				{{"$match", m}},
+				{{"$sort", bson.D{
+					{"started_at", -1},
+					{"_id", -1},
+				}}},
+				{{"$skip", keep}},
+				{{"$match", bson.D{notStarted}}},
+				{{"$limit", limit - len(ss)}},
+			})
+		})
+		if err != nil {
+			return nil, err
+		}
+		ss = append(ss, page...)
+	}
+	return ss, nil
+}
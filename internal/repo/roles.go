@@ -0,0 +1,164 @@
+package repo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// AllProjects is the project of a role binding for every project.
+const AllProjects = "*"
+
+type Role string
+
+const (
+	// RoleViewer can read suites.
+	RoleViewer Role = "viewer"
+	// RoleReporter can read and report suites.
+	RoleReporter Role = "reporter"
+	// RoleAdmin can do anything, including deleting suites.
+	RoleAdmin Role = "admin"
+)
+
+var roleRanks = map[Role]int{
+	RoleViewer:   1,
+	RoleReporter: 2,
+	RoleAdmin:    3,
+}
+
+func (r *Role) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	if _, ok := roleRanks[Role(s)]; !ok {
+		return errBadFormat{fmt.Errorf("bad role %q", s)}
+	}
+	*r = Role(s)
+	return nil
+}
+
+// Includes returns whether r grants everything other does.
+func (r Role) Includes(other Role) bool {
+	return roleRanks[r] >= roleRanks[other] && roleRanks[r] > 0
+}
+
+// RoleBinding grants a role for a project to a user or a group. Users are
+// identified by the subject or email of their single sign-on identity.
+type RoleBinding struct {
+	Entity  `bson:",inline"`
+	Project *string `json:"project,omitempty"`
+	Role    *Role   `json:"role,omitempty"`
+	User    *string `json:"user,omitempty" bson:",omitempty"`
+	Group   *string `json:"group,omitempty" bson:",omitempty"`
+}
+
+// Group is a group of users kept by SuiteServe, in addition to the groups
+// given by the identity provider.
+type Group struct {
+	Entity  `bson:",inline"`
+	Name    *string  `json:"name,omitempty"`
+	Members []string `json:"members,omitempty"`
+}
+
+func (r *Repo) InsertRoleBinding(ctx context.Context, b RoleBinding) (Id, error) {
+	if (b.User == nil) == (b.Group == nil) {
+		return nilId, errBadFormat{fmt.Errorf(
+			"role binding needs either a user or a group")}
+	}
+	return r.insert(ctx, roleBindings, b)
+}
+
+func (r *Repo) RoleBindings(ctx context.Context) ([]RoleBinding, error) {
+	bs := []RoleBinding{}
+	return bs, readAll(ctx, &bs, func() (*mongo.Cursor, error) {
+		return r.db.Collection(roleBindings).Find(ctx, bson.D{})
+	})
+}
+
+// RoleBindingsOf returns the role bindings of any of users or groups, and of
+// the local groups that any of users are members of.
+func (r *Repo) RoleBindingsOf(ctx context.Context, users,
+	groups []string) ([]RoleBinding, error) {
+	local, err := r.groupsOf(ctx, users)
+	if err != nil {
+		return nil, err
+	}
+	groups = append(append([]string{}, groups...), local...)
+	bs := []RoleBinding{}
+	return bs, readAll(ctx, &bs, func() (*mongo.Cursor, error) {
+		return r.db.Collection(roleBindings).Find(ctx, bson.D{
+			{"$or", bson.A{
+				bson.D{{"user", bson.D{
+					{"$in", append([]string{}, users...)},
+				}}},
+				bson.D{{"group", bson.D{
+					{"$in", groups},
+				}}},
+			}},
+		})
+	})
+}
+
+func (r *Repo) DeleteRoleBinding(ctx context.Context, id Id) error {
+	return r.deleteById(ctx, roleBindings, id)
+}
+
+func (r *Repo) Groups(ctx context.Context) ([]Group, error) {
+	gs := []Group{}
+	return gs, readAll(ctx, &gs, func() (*mongo.Cursor, error) {
+		return r.db.Collection(groups).Find(ctx, bson.D{})
+	})
+}
+
+func (r *Repo) groupsOf(ctx context.Context, users []string) ([]string, error) {
+	var gs []Group
+	err := readAll(ctx, &gs, func() (*mongo.Cursor, error) {
+		return r.db.Collection(groups).Find(ctx, bson.D{
+			{"members", bson.D{
+				{"$in", append([]string{}, users...)},
+			}},
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(gs))
+	for i, g := range gs {
+		names[i] = *g.Name
+	}
+	return names, nil
+}
+
+// AddGroupMember adds user to the group with the given name, creating it if
+// needed.
+func (r *Repo) AddGroupMember(ctx context.Context, group, user string) error {
+	_, err := r.db.Collection(groups).UpdateOne(ctx, bson.D{
+		{"name", group},
+	}, bson.D{
+		{"$addToSet", bson.D{
+			{"members", user},
+		}},
+	}, options.Update().SetUpsert(true))
+	return err
+}
+
+func (r *Repo) RemoveGroupMember(ctx context.Context, group, user string) error {
+	res, err := r.db.Collection(groups).UpdateOne(ctx, bson.D{
+		{"name", group},
+	}, bson.D{
+		{"$pull", bson.D{
+			{"members", user},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return errNotFound{}
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package repo
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestRole_Includes(t *testing.T) {
+	tests := []struct {
+		r, other Role
+		want     bool
+	}{
+		{RoleViewer, RoleViewer, true},
+		{RoleViewer, RoleReporter, false},
+		{RoleViewer, RoleAdmin, false},
+		{RoleReporter, RoleViewer, true},
+		{RoleReporter, RoleReporter, true},
+		{RoleReporter, RoleAdmin, false},
+		{RoleAdmin, RoleViewer, true},
+		{RoleAdmin, RoleReporter, true},
+		{RoleAdmin, RoleAdmin, true},
+		{"", RoleViewer, false},
+		{"", "", false},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.want, test.r.Includes(test.other),
+			"%q includes %q", test.r, test.other)
+	}
+}
+
+func TestRole_UnmarshalJSON(t *testing.T) {
+	var r Role
+	require.Nil(t, json.Unmarshal([]byte(`"reporter"`), &r))
+	assert.Equal(t, RoleReporter, r)
+
+	err := json.Unmarshal([]byte(`"owner"`), &r)
+	var errFormat interface {
+		BadFormat()
+	}
+	assert.True(t, errors.As(err, &errFormat))
+	assert.Equal(t, RoleReporter, r)
+}
@@ -0,0 +1,65 @@
+package repo
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"strings"
+	"time"
+)
+
+const sessionPrefix = "sss_"
+
+// Session is a user logged in through single sign-on.
+type Session struct {
+	Entity    `bson:",inline"`
+	Subject   *string  `json:"subject,omitempty" bson:",omitempty"`
+	Email     *string  `json:"email,omitempty" bson:",omitempty"`
+	Name      *string  `json:"name,omitempty" bson:",omitempty"`
+	Groups    []string `json:"groups,omitempty" bson:",omitempty"`
+	Hash      []byte   `json:"-"`
+	CreatedAt *MsTime  `json:"createdAt,omitempty" bson:"created_at"`
+	ExpiresAt *MsTime  `json:"expiresAt,omitempty" bson:"expires_at"`
+}
+
+// InsertSession generates a secret for s, which is only stored hashed, and
+// inserts s. The secret is returned to be set as a cookie.
+func (r *Repo) InsertSession(ctx context.Context, s Session) (Id, string, error) {
+	secret, err := newSecret(sessionPrefix)
+	if err != nil {
+		return nilId, "", err
+	}
+	s.Hash = hashSecret(secret)
+	if s.CreatedAt == nil {
+		now := MsTime(time.Now())
+		s.CreatedAt = &now
+	}
+	id, err := r.insert(ctx, sessions, s)
+	return id, secret, err
+}
+
+// SessionBySecret returns the unexpired session with the given secret.
+func (r *Repo) SessionBySecret(ctx context.Context,
+	secret string) (Session, error) {
+	var s Session
+	if !strings.HasPrefix(secret, sessionPrefix) {
+		return s, errNotFound{}
+	}
+	err := r.db.Collection(sessions).FindOne(ctx, bson.D{
+		{"hash", hashSecret(secret)},
+		{"expires_at", bson.D{
+			{"$gt", MsTime(time.Now())},
+		}},
+	}).Decode(&s)
+	if err == mongo.ErrNoDocuments {
+		return s, errNotFound{}
+	}
+	return s, err
+}
+
+func (r *Repo) DeleteSession(ctx context.Context, secret string) error {
+	_, err := r.db.Collection(sessions).DeleteOne(ctx, bson.D{
+		{"hash", hashSecret(secret)},
+	})
+	return err
+}
@@ -3,11 +3,14 @@ package repo
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/bsontype"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -46,18 +49,40 @@ const (
 type Suite struct {
 	Entity          `bson:",inline"`
 	VersionedEntity `bson:",inline"`
-	Project         *string      `json:"project,omitempty" bson:",omitempty"`
-	Tags            []string     `json:"tags,omitempty" bson:",omitempty"`
-	PlannedCases    *int64       `json:"plannedCases,omitempty" bson:"planned_cases,omitempty"`
-	Status          *SuiteStatus `json:"status,omitempty"`
-	Result          *SuiteResult `json:"result,omitempty" bson:",omitempty"`
-	DisconnectedAt  *MsTime      `json:"disconnectedAt,omitempty" bson:"disconnected_at,omitempty"`
-	StartedAt       *MsTime      `json:"startedAt,omitempty" bson:"started_at"`
-	FinishedAt      *MsTime      `json:"finishedAt,omitempty" bson:"finished_at,omitempty"`
+	Project         *string           `json:"project,omitempty" bson:",omitempty" validate:"max=256,not=*"`
+	Description     *string           `json:"description,omitempty" bson:",omitempty" validate:"max=4096"`
+	Tags            []string          `json:"tags,omitempty" bson:",omitempty" validate:"max=256,maxItems=64"`
+	Labels          map[string]string `json:"labels,omitempty" bson:",omitempty" validate:"keys=64,max=1024,maxItems=64"`
+	Env             *SuiteEnv         `json:"env,omitempty" bson:",omitempty" validate:"dive"`
+	PlannedCases    *int64            `json:"plannedCases,omitempty" bson:"planned_cases,omitempty"`
+	Status          *SuiteStatus      `json:"status,omitempty" validate:"oneof=started"`
+	Result          *SuiteResult      `json:"result,omitempty" bson:",omitempty" validate:"readonly"`
+	Quarantined     *int64            `json:"quarantined,omitempty" bson:",omitempty" validate:"readonly"`
+	DisconnectedAt  *MsTime           `json:"disconnectedAt,omitempty" bson:"disconnected_at,omitempty" validate:"readonly"`
+	StartedAt       *MsTime           `json:"startedAt,omitempty" bson:"started_at" validate:"required"`
+	FinishedAt      *MsTime           `json:"finishedAt,omitempty" bson:"finished_at,omitempty" validate:"readonly"`
+	DeletedAt       *MsTime           `json:"deletedAt,omitempty" bson:"deleted_at,omitempty" validate:"readonly"`
+	BaselineId      *Id               `json:"baselineId,omitempty" bson:"baseline_id,omitempty" validate:"readonly"`
 }
 
 var suiteType = reflect.TypeOf(Suite{})
 
+// SuiteEnv is the build a suite tested and the machine it ran on, as reported
+// by the runner.
+type SuiteEnv struct {
+	Commit *string `json:"commit,omitempty" bson:",omitempty" validate:"max=256"`
+	Branch *string `json:"branch,omitempty" bson:",omitempty" validate:"max=256"`
+	// Pr is the number of the pull or merge request that was built.
+	Pr *int64 `json:"pr,omitempty" bson:",omitempty"`
+	// Ci is the CI provider, such as github-actions or gitlab.
+	Ci        *string `json:"ci,omitempty" bson:",omitempty" validate:"max=64"`
+	BuildUrl  *string `json:"buildUrl,omitempty" bson:"build_url,omitempty" validate:"max=2048"`
+	Host      *string `json:"host,omitempty" bson:",omitempty" validate:"max=256"`
+	Os        *string `json:"os,omitempty" bson:",omitempty" validate:"max=64"`
+	Arch      *string `json:"arch,omitempty" bson:",omitempty" validate:"max=64"`
+	GoVersion *string `json:"goVersion,omitempty" bson:"go_version,omitempty" validate:"max=64"`
+}
+
 type SuitePageCursor struct {
 	Id        Id
 	StartedAt MsTime
@@ -117,12 +142,66 @@ func (c SuitePageCursor) String() string {
 	return fmt.Sprintf("%s_%s", c.Id, c.StartedAt)
 }
 
+// SuiteFilter restricts the suites of a page. A nil field matches every
+// suite.
+type SuiteFilter struct {
+	Projects []string
+	// Deleted selects the suites that are deleted instead of the others.
+	Deleted bool
+	// Env has the values the environment of the suites must have. Nil fields
+	// match any value.
+	Env SuiteEnv
+	// Labels are what the suites must have of their labels.
+	Labels map[string]string
+}
+
+func (f SuiteFilter) match() bson.D {
+	match := bson.D{{"deleted_at", nil}}
+	if f.Deleted {
+		match = bson.D{{"deleted_at", bson.D{{"$ne", nil}}}}
+	}
+	if f.Projects != nil {
+		match = append(match, bson.E{"project", bson.D{
+			{"$in", f.Projects},
+		}})
+	}
+	eachField(reflect.ValueOf(f.Env), func(sf reflect.StructField,
+		fv reflect.Value) {
+		if !fv.IsNil() {
+			match = append(match, bson.E{"env." + bsonName(sf),
+				fv.Interface()})
+		}
+	})
+	return append(match, labelsMatch(f.Labels)...)
+}
+
+// labelsMatch matches the documents that have the given labels, in the order
+// of their keys.
+func labelsMatch(labels map[string]string) bson.D {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	match := bson.D{}
+	for _, k := range keys {
+		match = append(match, bson.E{"labels." + k, labels[k]})
+	}
+	return match
+}
+
 type SuitePage struct {
 	Next   *SuitePageCursor `json:"next,omitempty"`
 	Suites []Suite          `json:"suites"`
 }
 
+// InsertSuite inserts s, creating its project if it is the first suite of it.
 func (r *Repo) InsertSuite(ctx context.Context, s Suite) (Id, error) {
+	if s.Project != nil {
+		if err := r.ensureProject(ctx, *s.Project); err != nil {
+			return nilId, err
+		}
+	}
 	return r.insert(ctx, Suites, s)
 }
 
@@ -132,13 +211,14 @@ func (r *Repo) Suite(ctx context.Context, id Id) (Suite, error) {
 	return s, err
 }
 
-func (r *Repo) SuitePage(ctx context.Context) (SuitePage, error) {
-	return r.suitePage(ctx, bson.D{})
+func (r *Repo) SuitePage(ctx context.Context,
+	f SuiteFilter) (SuitePage, error) {
+	return r.suitePage(ctx, f.match())
 }
 
-func (r *Repo) SuitePageAfter(ctx context.Context,
+func (r *Repo) SuitePageAfter(ctx context.Context, f SuiteFilter,
 	cursor SuitePageCursor) (SuitePage, error) {
-	return r.suitePage(ctx, bson.D{
+	return r.suitePage(ctx, append(f.match(), bson.D{
 		{"started_at", bson.D{
 			{"$lte", cursor.StartedAt},
 		}},
@@ -150,7 +230,7 @@ func (r *Repo) SuitePageAfter(ctx context.Context,
 				{"$lt", cursor.Id},
 			}}},
 		}},
-	})
+	}...))
 }
 
 func (r *Repo) suitePage(ctx context.Context, match bson.D) (SuitePage, error) {
@@ -205,18 +285,199 @@ func (r *Repo) suitePage(ctx context.Context, match bson.D) (SuitePage, error) {
 	return suitePage, nil
 }
 
-func (r *Repo) FinishSuite(ctx context.Context, id Id, res SuiteResult,
-	at MsTime) error {
-	return r.updateById(ctx, Suites, id, bson.D{
+// UpdateSuite sets the fields of the suite with the given JSON names to their
+// values in s, or removes them if they are empty. If versions is not nil, the
+// suite must currently have one of them.
+func (r *Repo) UpdateSuite(ctx context.Context, id Id, versions []int64,
+	s Suite, fields []string) error {
+	err := r.updateFieldsById(ctx, Suites, id, versions, s, fields)
+	if err != nil {
+		return err
+	}
+	for _, f := range fields {
+		if f == "project" && s.Project != nil {
+			return r.ensureProject(ctx, *s.Project)
+		}
+	}
+	return nil
+}
+
+// FinishSuite finishes the suite with the given id. A failed suite passes if
+// all of its failed cases are quarantined, which are counted either way. The
+// failed cases are then recorded in the history of their tests. If versions is
+// not nil, the suite must currently have one of them.
+func (r *Repo) FinishSuite(ctx context.Context, id Id, versions []int64,
+	res SuiteResult, at MsTime) error {
+	var counts []struct {
+		Quarantined bool `bson:"_id"`
+		N           int64
+	}
+	err := readAll(ctx, &counts, func() (*mongo.Cursor, error) {
+		return r.db.Collection(cases).Aggregate(ctx, mongo.Pipeline{
+			{{"$match", bson.D{
+				{"suite_id", id},
+				{"result", bson.D{{"$in", bson.A{
+					CaseResultFailed,
+					CaseResultErrored,
+				}}}},
+			}}},
+			{{"$group", bson.D{
+				{"_id", bson.D{{"$eq", bson.A{"$quarantined", true}}}},
+				{"n", bson.D{{"$sum", 1}}},
+			}}},
+		})
+	})
+	if err != nil {
+		return err
+	}
+	var blocking, quarantined int64
+	for _, c := range counts {
+		if c.Quarantined {
+			quarantined = c.N
+		} else {
+			blocking = c.N
+		}
+	}
+	set := bson.D{
 		{"status", SuiteStatusFinished},
 		{"result", res},
 		{"finished_at", at},
-	})
+	}
+	if quarantined > 0 {
+		if res == SuiteResultFailed && blocking == 0 {
+			set[1].Value = SuiteResultPassed
+		}
+		set = append(set, bson.E{"quarantined", quarantined})
+	}
+	if err := r.updateById(ctx, Suites, id, versions, set); err != nil {
+		return err
+	}
+	return r.recordFailedRuns(ctx, id)
 }
 
-func (r *Repo) DisconnectSuite(ctx context.Context, id Id, at MsTime) error {
-	return r.updateById(ctx, Suites, id, bson.D{
+// DisconnectSuite marks the suite with the given id as disconnected, and
+// records its failed cases in the history of their tests like FinishSuite.
+func (r *Repo) DisconnectSuite(ctx context.Context, id Id, versions []int64,
+	at MsTime) error {
+	err := r.updateById(ctx, Suites, id, versions, bson.D{
 		{"status", SuiteStatusDisconnected},
 		{"disconnected_at", at},
 	})
+	if err != nil {
+		return err
+	}
+	return r.recordFailedRuns(ctx, id)
+}
+
+// DeleteSuite marks the suite with the given id as deleted at the given time,
+// which hides it from suite pages until it is restored or purged. Deleting a
+// suite again keeps the earlier time.
+func (r *Repo) DeleteSuite(ctx context.Context, id Id, versions []int64,
+	at MsTime) error {
+	return r.updateByIdWith(ctx, Suites, id, versions, bson.D{
+		{"$min", bson.D{{"deleted_at", at}}},
+	})
+}
+
+func (r *Repo) RestoreSuite(ctx context.Context, id Id,
+	versions []int64) error {
+	return r.updateByIdWith(ctx, Suites, id, versions, bson.D{
+		{"$unset", bson.D{{"deleted_at", ""}}},
+	})
+}
+
+// DeletedSuites returns the ids of the suites deleted before the given time.
+func (r *Repo) DeletedSuites(ctx context.Context, before MsTime) ([]Id, error) {
+	var ss []Entity
+	err := readAll(ctx, &ss, func() (*mongo.Cursor, error) {
+		return r.db.Collection(suites).Find(ctx, bson.D{
+			{"deleted_at", bson.D{{"$lt", before}}},
+		}, options.Find().SetProjection(bson.D{{"_id", 1}}))
+	})
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]Id, len(ss))
+	for i, s := range ss {
+		ids[i] = *s.Id
+	}
+	return ids, nil
+}
+
+// SuiteTreeAttachments returns the attachments of the suite with the given id
+// and of its cases.
+func (r *Repo) SuiteTreeAttachments(ctx context.Context,
+	id Id) ([]Attachment, error) {
+	owned, err := r.ownedBySuite(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	as := []Attachment{}
+	return as, readAll(ctx, &as, func() (*mongo.Cursor, error) {
+		return r.db.Collection(attachments).Find(ctx, owned)
+	})
+}
+
+// PurgeSuite permanently removes the suite with the given id, which must be
+// deleted, and its cases, failures, regression summaries, logs and
+// attachments. The files of the attachments are left for the caller to
+// remove. The suite itself is removed last, so that a failed purge can be
+// retried.
+func (r *Repo) PurgeSuite(ctx context.Context, id Id) error {
+	var s Suite
+	if err := r.findById(ctx, Suites, id, &s); err != nil {
+		return err
+	}
+	if s.DeletedAt == nil {
+		return errConflict{errors.New("suite is not deleted")}
+	}
+	owned, err := r.ownedBySuite(ctx, id)
+	if err != nil {
+		return err
+	}
+	for _, coll := range []string{attachments, logs} {
+		if _, err := r.db.Collection(coll).DeleteMany(ctx, owned); err != nil {
+			return err
+		}
+	}
+	for _, coll := range []string{failures, regressions, cases} {
+		_, err := r.db.Collection(coll).DeleteMany(ctx, bson.D{
+			{"suite_id", id},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	res, err := r.db.Collection(suites).DeleteOne(ctx, bson.D{
+		{"_id", id},
+		{"deleted_at", bson.D{{"$ne", nil}}},
+	})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return errConflict{errors.New("suite was restored")}
+	}
+	return nil
+}
+
+// ownedBySuite returns a filter for the logs and attachments of the suite with
+// the given id and of its cases.
+func (r *Repo) ownedBySuite(ctx context.Context, id Id) (bson.D, error) {
+	var cs []Entity
+	err := readAll(ctx, &cs, func() (*mongo.Cursor, error) {
+		return r.db.Collection(cases).Find(ctx, bson.D{{"suite_id", id}},
+			options.Find().SetProjection(bson.D{{"_id", 1}}))
+	})
+	if err != nil {
+		return nil, err
+	}
+	caseIds := make(bson.A, len(cs))
+	for i, c := range cs {
+		caseIds[i] = *c.Id
+	}
+	return bson.D{{"$or", bson.A{
+		bson.D{{"suite_id", id}},
+		bson.D{{"case_id", bson.D{{"$in", caseIds}}}},
+	}}}, nil
 }
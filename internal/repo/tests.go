@@ -0,0 +1,201 @@
+package repo
+
+import (
+	"context"
+	"github.com/suiteserve/suiteserve/internal/flaky"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Test is what is known of a test across the suites it ran in, by its
+// fingerprint.
+type Test struct {
+	Fingerprint string            `json:"fingerprint" bson:"_id"`
+	Project     *string           `json:"project,omitempty" bson:",omitempty"`
+	Name        *string           `json:"name,omitempty" bson:",omitempty"`
+	Params      map[string]string `json:"params,omitempty" bson:",omitempty"`
+	// Recent has the results of the latest runs that passed, passed on retry
+	// or failed, oldest first.
+	Recent    []CaseResult `json:"recent"`
+	Runs      int64        `json:"runs"`
+	Flips     int          `json:"flips"`
+	Score     float64      `json:"score"`
+	LastRunAt *MsTime      `json:"lastRunAt,omitempty" bson:"last_run_at,omitempty"`
+}
+
+// TestFilter restricts tests to projects. A nil field matches every test.
+type TestFilter struct {
+	Projects []string
+}
+
+// isFailure reports whether a run with the given result failed. Results other
+// than passing or failing say nothing about how flaky a test is.
+func isFailure(res CaseResult) (failed, ok bool) {
+	switch res {
+	case CaseResultPassed:
+		return false, true
+	case CaseResultFailed, CaseResultErrored:
+		return true, true
+	}
+	return false, false
+}
+
+// runFailures returns whether each attempt of the runs with the given results
+// failed, where a run that passed on retry failed and then passed.
+func runFailures(recent []CaseResult) []bool {
+	fails := make([]bool, 0, len(recent))
+	for _, res := range recent {
+		switch res {
+		case CaseResultPassedOnRetry:
+			fails = append(fails, true, false)
+		default:
+			fails = append(fails, res == CaseResultFailed)
+		}
+	}
+	return fails
+}
+
+// recordTestRun adds the final result of c, a run of its test, to the recent
+// results of the test and scores how flaky it is from the results it added
+// to, unless another run was recorded in the meantime.
+func (r *Repo) recordTestRun(ctx context.Context, c Case, res CaseResult,
+	at MsTime) error {
+	failed, ok := isFailure(res)
+	if res == CaseResultPassedOnRetry {
+		ok = true
+	}
+	if !ok || c.Fingerprint == nil {
+		return nil
+	}
+	if failed {
+		res = CaseResultFailed
+	}
+	var s Suite
+	if c.SuiteId != nil {
+		err := r.findByIdProj(ctx, Suites, *c.SuiteId, bson.D{
+			{"project", 1},
+		}, &s)
+		if err != nil {
+			return err
+		}
+	}
+	onInsert := bson.D{}
+	if s.Project != nil {
+		onInsert = append(onInsert, bson.E{"project", *s.Project})
+	}
+	if c.Name != nil {
+		onInsert = append(onInsert, bson.E{"name", *c.Name})
+	}
+	if len(c.Params) > 0 {
+		onInsert = append(onInsert, bson.E{"params", c.Params})
+	}
+	update := bson.D{
+		{"$push", bson.D{{"recent", bson.D{
+			{"$each", bson.A{res}},
+			{"$slice", -flaky.Window},
+		}}}},
+		{"$inc", bson.D{{"runs", 1}}},
+		{"$max", bson.D{{"last_run_at", at}}},
+	}
+	if len(onInsert) > 0 {
+		update = append(update, bson.E{"$setOnInsert", onInsert})
+	}
+	var t Test
+	err := r.db.Collection(tests).FindOneAndUpdate(ctx, bson.D{
+		{"_id", *c.Fingerprint},
+	}, update, options.FindOneAndUpdate().
+		SetUpsert(true).
+		SetReturnDocument(options.After)).Decode(&t)
+	if err != nil {
+		return err
+	}
+	flips, score := flaky.Score(runFailures(t.Recent))
+	// the score is only set if no run was recorded since, in which case the
+	// recorder of the latest run sets it from all of them
+	_, err = r.db.Collection(tests).UpdateOne(ctx, bson.D{
+		{"_id", *c.Fingerprint},
+		{"runs", t.Runs},
+	}, bson.D{{"$set", bson.D{
+		{"flips", flips},
+		{"score", score},
+	}}})
+	return err
+}
+
+// recordFailedRuns records the runs of the cases of the suite with the given
+// id that failed, as they are no longer retried once the suite ends.
+func (r *Repo) recordFailedRuns(ctx context.Context, suiteId Id) error {
+	var cs []Case
+	err := readAll(ctx, &cs, func() (*mongo.Cursor, error) {
+		return r.db.Collection(cases).Find(ctx, bson.D{
+			{"suite_id", suiteId},
+			{"result", bson.D{{"$in", bson.A{
+				CaseResultFailed,
+				CaseResultErrored,
+			}}}},
+			{"run_recorded", bson.D{{"$ne", true}}},
+		}, options.Find().SetProjection(bson.D{
+			{"suite_id", 1},
+			{"name", 1},
+			{"params", 1},
+			{"fingerprint", 1},
+			{"result", 1},
+			{"finished_at", 1},
+		}))
+	})
+	if err != nil {
+		return err
+	}
+	for _, c := range cs {
+		// claimed first, so that a run is recorded once
+		res, err := r.db.Collection(cases).UpdateOne(ctx, bson.D{
+			{"_id", *c.Id},
+			{"run_recorded", bson.D{{"$ne", true}}},
+		}, bson.D{{"$set", bson.D{{"run_recorded", true}}}})
+		if err != nil {
+			return err
+		}
+		if res.ModifiedCount == 0 || c.FinishedAt == nil {
+			continue
+		}
+		err = r.recordTestRun(ctx, c, *c.Result, *c.FinishedAt)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isFlakyTest reports whether the test with the given fingerprint is known to
+// be flaky.
+func (r *Repo) isFlakyTest(ctx context.Context, fingerprint string) (bool,
+	error) {
+	var t Test
+	err := r.db.Collection(tests).FindOne(ctx, bson.D{
+		{"_id", fingerprint},
+	}, options.FindOne().SetProjection(bson.D{{"score", 1}})).Decode(&t)
+	if err == mongo.ErrNoDocuments {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return flaky.IsFlaky(t.Score), nil
+}
+
+// FlakyTests returns up to limit tests that are flaky, the flakiest first.
+func (r *Repo) FlakyTests(ctx context.Context, f TestFilter,
+	limit int) ([]Test, error) {
+	match := bson.D{{"score", bson.D{{"$gte", flaky.Threshold}}}}
+	if f.Projects != nil {
+		match = append(match, bson.E{"project", bson.D{
+			{"$in", f.Projects},
+		}})
+	}
+	ts := []Test{}
+	return ts, readAll(ctx, &ts, func() (*mongo.Cursor, error) {
+		return r.db.Collection(tests).Find(ctx, match, options.Find().
+			SetSort(bson.D{{"score", -1}, {"last_run_at", -1}}).
+			SetLimit(int64(limit)))
+	})
+}
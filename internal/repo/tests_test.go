@@ -0,0 +1,15 @@
+package repo
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestRunFailures(t *testing.T) {
+	got := runFailures([]CaseResult{
+		CaseResultPassed,
+		CaseResultPassedOnRetry,
+		CaseResultFailed,
+	})
+	assert.Equal(t, []bool{false, true, false, true}, got)
+}
@@ -0,0 +1,105 @@
+package repo
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"strings"
+	"time"
+)
+
+const tokenPrefix = "sst_"
+
+type TokenScope string
+
+const (
+	// TokenScopeIngest allows reporting suites of a single project, but not
+	// reading anything.
+	TokenScopeIngest TokenScope = "ingest"
+	// TokenScopeRead allows reading everything, but not reporting.
+	TokenScopeRead TokenScope = "read"
+)
+
+type Token struct {
+	Entity    `bson:",inline"`
+	Name      *string     `json:"name,omitempty" bson:",omitempty"`
+	Scope     *TokenScope `json:"scope,omitempty"`
+	Project   *string     `json:"project,omitempty" bson:",omitempty"`
+	Hash      []byte      `json:"-"`
+	CreatedAt *MsTime     `json:"createdAt,omitempty" bson:"created_at"`
+	RevokedAt *MsTime     `json:"revokedAt,omitempty" bson:"revoked_at,omitempty"`
+}
+
+func hashSecret(secret string) []byte {
+	h := sha256.Sum256([]byte(secret))
+	return h[:]
+}
+
+func newSecret(prefix string) (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return prefix + hex.EncodeToString(b), nil
+}
+
+// InsertToken generates a secret for t, which is only stored hashed, and
+// inserts t. The secret is returned, as it cannot be recovered later.
+func (r *Repo) InsertToken(ctx context.Context, t Token) (Id, string, error) {
+	secret, err := newSecret(tokenPrefix)
+	if err != nil {
+		return nilId, "", err
+	}
+	t.Hash = hashSecret(secret)
+	if t.CreatedAt == nil {
+		now := MsTime(time.Now())
+		t.CreatedAt = &now
+	}
+	id, err := r.insert(ctx, tokens, t)
+	return id, secret, err
+}
+
+func (r *Repo) Tokens(ctx context.Context) ([]Token, error) {
+	ts := []Token{}
+	return ts, readAll(ctx, &ts, func() (*mongo.Cursor, error) {
+		return r.db.Collection(tokens).Find(ctx, bson.D{})
+	})
+}
+
+// TokenBySecret returns the unrevoked token with the given secret.
+func (r *Repo) TokenBySecret(ctx context.Context,
+	secret string) (Token, error) {
+	var t Token
+	if !strings.HasPrefix(secret, tokenPrefix) {
+		return t, errNotFound{}
+	}
+	err := r.db.Collection(tokens).FindOne(ctx, bson.D{
+		{"hash", hashSecret(secret)},
+		{"revoked_at", nil},
+	}).Decode(&t)
+	if err == mongo.ErrNoDocuments {
+		return t, errNotFound{}
+	}
+	return t, err
+}
+
+func (r *Repo) RevokeToken(ctx context.Context, id Id, at MsTime) error {
+	res, err := r.db.Collection(tokens).UpdateOne(ctx, bson.D{
+		{"_id", id},
+		{"revoked_at", nil},
+	}, bson.D{
+		{"$set", bson.D{
+			{"revoked_at", at},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return errNotFound{}
+	}
+	return nil
+}
@@ -7,18 +7,35 @@ import (
 	"fmt"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 	"reflect"
 )
 
 type Change struct {
+	Id   Id
 	Coll Coll
 	Msg  json.RawMessage
+	// Delete is whether the document was deleted, in which case nothing is
+	// known of what it belonged to.
+	Delete bool
+	// Project is the project of a changed suite.
+	Project *string
+	// SuiteId and CaseId are what a changed case, log line or attachment
+	// belongs to.
+	SuiteId *Id
+	CaseId  *Id
 }
 
 type watchEvent struct {
 	Id     Id          `json:"id"`
 	Insert interface{} `json:"insert,omitempty"`
 	Update interface{} `json:"update,omitempty"`
+	// Remove has the JSON names of the fields an update removed.
+	Remove []string `json:"remove,omitempty"`
+	Delete bool     `json:"delete,omitempty"`
+	// ParentId is the parent of a changed case, for watchers to place it in
+	// the tree of cases without fetching it.
+	ParentId *Id `json:"parentId,omitempty"`
 
 	coll Coll
 }
@@ -26,27 +43,69 @@ type watchEvent struct {
 func (r *Repo) Watch(ctx context.Context) (<-chan Change, <-chan error) {
 	changeCh := make(chan Change)
 	errCh := make(chan error, 1)
+	opts := options.ChangeStream().SetFullDocument(options.UpdateLookup)
 	stream, err := r.db.Watch(ctx, mongo.Pipeline{
 		{{"$match", bson.D{
-			{"operationType", bson.D{
-				{"$in", bson.A{
-					"insert",
-					"update",
-				}},
+			{"$or", bson.A{
+				bson.D{
+					{"operationType", bson.D{
+						{"$in", bson.A{
+							"insert",
+							"update",
+						}},
+					}},
+					{"ns.coll", bson.D{
+						{"$in", bson.A{
+							attachments,
+							cases,
+							logs,
+							suites,
+						}},
+					}},
+				},
+				bson.D{
+					{"operationType", "insert"},
+					{"ns.coll", regressions},
+				},
+				// the cases, logs and attachments of a purged suite are
+				// deleted with it
+				bson.D{
+					{"operationType", "delete"},
+					{"ns.coll", suites},
+				},
 			}},
 		}}},
 		{{"$set", bson.D{
 			{"id", "$documentKey._id"},
 			{"coll", "$ns.coll"},
+			{"insert", bson.D{
+				{"$cond", bson.A{
+					bson.D{{"$eq", bson.A{"$operationType", "insert"}}},
+					"$fullDocument",
+					"$$REMOVE",
+				}},
+			}},
 			{"update", "$updateDescription.updatedFields"},
+			{"remove", "$updateDescription.removedFields"},
+			{"delete", bson.D{{"$eq", bson.A{"$operationType", "delete"}}}},
+			{"project", "$fullDocument.project"},
+			{"suite_id", "$fullDocument.suite_id"},
+			{"case_id", "$fullDocument.case_id"},
+			{"parent_id", "$fullDocument.parent_id"},
 		}}},
 		{{"$project", bson.D{
 			{"id", 1},
 			{"coll", 1},
-			{"fullDocument", 1},
+			{"insert", 1},
 			{"update", 1},
+			{"remove", 1},
+			{"delete", 1},
+			{"project", 1},
+			{"suite_id", 1},
+			{"case_id", 1},
+			{"parent_id", 1},
 		}}},
-	})
+	}, opts)
 	if err != nil {
 		close(changeCh)
 		errCh <- err
@@ -64,10 +123,15 @@ func (r *Repo) Watch(ctx context.Context) (<-chan Change, <-chan error) {
 			if err := stream.Decode(&raw); err != nil {
 				return err
 			}
-			evt := bsonToWatchEvent(raw)
+			evt, owner := bsonToWatchEvent(raw)
 			changeCh <- Change{
-				Msg:  mustMarshalJSON(&evt),
-				Coll: evt.coll,
+				Id:      evt.Id,
+				Delete:  evt.Delete,
+				Msg:     mustMarshalJSON(&evt),
+				Coll:    evt.coll,
+				Project: owner.Project,
+				SuiteId: owner.SuiteId,
+				CaseId:  owner.CaseId,
 			}
 		}
 		if stream.Err() != nil && !errors.Is(stream.Err(), context.Canceled) {
@@ -79,17 +143,30 @@ func (r *Repo) Watch(ctx context.Context) (<-chan Change, <-chan error) {
 }
 
 type rawEvent struct {
-	Id     Id
-	Coll   Coll
-	Insert bson.Raw `bson:"fullDocument"`
-	Update bson.Raw
+	Id       Id
+	Coll     Coll
+	Insert   bson.Raw
+	Update   bson.Raw
+	Remove   []string
+	Delete   bool
+	ParentId *Id `bson:"parent_id"`
+}
+
+type rawOwner struct {
+	Project *string
+	SuiteId *Id `bson:"suite_id"`
+	CaseId  *Id `bson:"case_id"`
 }
 
-func bsonToWatchEvent(raw bson.Raw) watchEvent {
+func bsonToWatchEvent(raw bson.Raw) (watchEvent, rawOwner) {
 	var re rawEvent
 	if err := bson.Unmarshal(raw, &re); err != nil {
 		panic(err)
 	}
+	var owner rawOwner
+	if err := bson.Unmarshal(raw, &owner); err != nil {
+		panic(err)
+	}
 	var as reflect.Type
 	switch re.Coll {
 	case Attachments:
@@ -100,15 +177,20 @@ func bsonToWatchEvent(raw bson.Raw) watchEvent {
 		as = caseType
 	case Logs:
 		as = logLineType
+	case Regressions:
+		as = regressionSummaryType
 	default:
 		panic(fmt.Sprintf("bad coll %q", re.Coll))
 	}
 	return watchEvent{
-		Id:     re.Id,
-		Insert: mustUnmarshalBSON(re.Insert, as),
-		Update: mustUnmarshalBSON(re.Update, as),
-		coll:   re.Coll,
-	}
+		Id:       re.Id,
+		Insert:   mustUnmarshalBSON(re.Insert, as),
+		Update:   mustUnmarshalBSON(re.Update, as),
+		Remove:   jsonNames(as, re.Remove),
+		Delete:   re.Delete,
+		ParentId: re.ParentId,
+		coll:     re.Coll,
+	}, owner
 }
 
 func mustUnmarshalBSON(raw bson.Raw, as reflect.Type) interface{} {
@@ -0,0 +1,40 @@
+package repo
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"testing"
+)
+
+func TestBsonToWatchEvent_ParentId(t *testing.T) {
+	id, suiteId, parentId := primitive.NewObjectID(), primitive.NewObjectID(),
+		primitive.NewObjectID()
+	raw, err := bson.Marshal(bson.D{
+		{"id", id},
+		{"coll", cases},
+		{"insert", bson.D{
+			{"_id", id},
+			{"suite_id", suiteId},
+			{"parent_id", parentId},
+			{"name", "TestChild"},
+		}},
+		{"delete", false},
+		{"suite_id", suiteId},
+		{"parent_id", parentId},
+	})
+	require.Nil(t, err)
+
+	evt, owner := bsonToWatchEvent(raw)
+	require.NotNil(t, evt.ParentId)
+	assert.Equal(t, Id(parentId), *evt.ParentId)
+	assert.Equal(t, Id(suiteId), *owner.SuiteId)
+
+	var msg struct {
+		ParentId string `json:"parentId"`
+	}
+	require.Nil(t, json.Unmarshal(mustMarshalJSON(&evt), &msg))
+	assert.Equal(t, parentId.Hex(), msg.ParentId)
+}
@@ -0,0 +1,112 @@
+// Package signature extracts the signature of a failure from its error output,
+// so that failures with the same cause can be grouped however many times they
+// happen. A signature is the message of the failure and its top stack frames,
+// with what varies between runs, such as numbers, ids and paths, masked.
+package signature
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+	"strings"
+)
+
+// Frames is how many of the top stack frames a signature has.
+const Frames = 3
+
+type Signature struct {
+	Message string   `json:"message"`
+	Frames  []string `json:"frames,omitempty"`
+}
+
+var (
+	uuidRe = regexp.MustCompile(
+		`\b[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}\b`)
+	hexRe    = regexp.MustCompile(`\b0x[0-9a-fA-F]+\b`)
+	hexIdRe  = regexp.MustCompile(`\b[0-9a-fA-F]{8,}\b`)
+	pathRe   = regexp.MustCompile(`(?:[A-Za-z]:)?[\w.@~-]*(?:[/\\][\w.@~-]+)+`)
+	numberRe = regexp.MustCompile(`\b\d+\b`)
+	spaceRe  = regexp.MustCompile(`\s+`)
+
+	pythonFrameRe = regexp.MustCompile(`^\s*File ".*", line \d+`)
+	frameRes      = []*regexp.Regexp{
+		// Java, JavaScript
+		regexp.MustCompile(`^\s*at\s+\S`),
+		pythonFrameRe,
+		// Go and anything else that ends with a source line
+		regexp.MustCompile(`\.[A-Za-z]+:\d+(?::\d+)?\)?(?:\s+\+0x[0-9a-fA-F]+)?$`),
+	}
+	// headerRes match lines that start stack traces, which are never messages.
+	headerRes = []*regexp.Regexp{
+		regexp.MustCompile(`^Traceback \(most recent call last\):$`),
+		regexp.MustCompile(`^goroutine \d+ \[.*\]:$`),
+	}
+)
+
+// Mask replaces what varies between runs of the same failure in s: ids with
+// <id>, numbers with <n>, and the directories of paths with <path>. It also
+// collapses whitespace.
+func Mask(s string) string {
+	s = uuidRe.ReplaceAllString(s, "<id>")
+	s = hexRe.ReplaceAllString(s, "<n>")
+	s = hexIdRe.ReplaceAllStringFunc(s, func(id string) string {
+		// long words that happen to be hexadecimal are kept
+		if strings.IndexAny(id, "0123456789") < 0 {
+			return id
+		}
+		return "<id>"
+	})
+	s = pathRe.ReplaceAllStringFunc(s, func(path string) string {
+		i := strings.LastIndexAny(path, `/\`)
+		return "<path>/" + path[i+1:]
+	})
+	s = numberRe.ReplaceAllString(s, "<n>")
+	return strings.TrimSpace(spaceRe.ReplaceAllString(s, " "))
+}
+
+func matchesAny(res []*regexp.Regexp, line string) bool {
+	for _, re := range res {
+		if re.MatchString(line) {
+			return true
+		}
+	}
+	return false
+}
+
+// Extract returns the signature of a failure from its error output, which may
+// have several lines each. The message is the first line that is neither part
+// of a stack trace nor the header of one. It returns false if there is neither
+// a message nor frames.
+func Extract(output []string) (Signature, bool) {
+	var sig Signature
+	// Python frames are followed by the indented line of code they run
+	afterPythonFrame := false
+	for _, o := range output {
+		for _, line := range strings.Split(o, "\n") {
+			trimmed := strings.TrimSpace(line)
+			code := afterPythonFrame && trimmed != line
+			afterPythonFrame = pythonFrameRe.MatchString(line)
+			switch {
+			case trimmed == "" || code || matchesAny(headerRes, trimmed):
+			case matchesAny(frameRes, line):
+				if len(sig.Frames) < Frames {
+					sig.Frames = append(sig.Frames, Mask(line))
+				}
+			case sig.Message == "":
+				sig.Message = Mask(line)
+			}
+		}
+	}
+	return sig, sig.Message != "" || len(sig.Frames) > 0
+}
+
+// Hash identifies the signature.
+func (s Signature) Hash() string {
+	h := sha256.New()
+	h.Write([]byte(s.Message))
+	for _, f := range s.Frames {
+		h.Write([]byte{0})
+		h.Write([]byte(f))
+	}
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
@@ -0,0 +1,102 @@
+package signature_test
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/suiteserve/suiteserve/internal/signature"
+	"testing"
+)
+
+func TestMask(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"dial tcp 127.0.0.1:5432: connect: connection refused",
+			"dial tcp <n>.<n>.<n>.<n>:<n>: connect: connection refused"},
+		{"user 5f3c1e2a9b8d7c6e5f4a3b2c not found",
+			"user <id> not found"},
+		{"request 123e4567-e89b-12d3-a456-426614174000 timed out",
+			"request <id> timed out"},
+		{"open /tmp/build-42/out.txt: no such file",
+			"open <path>/out.txt: no such file"},
+		{"panic at 0xc000123456  in   Test2",
+			"panic at <n> in Test2"},
+		{"deadbeefcafe is not a number", "deadbeefcafe is not a number"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, signature.Mask(tt.in), tt.in)
+	}
+}
+
+func TestExtract_Java(t *testing.T) {
+	sig, ok := signature.Extract([]string{
+		"java.net.ConnectException: Connection refused (port 8080)",
+		"\tat java.base/sun.nio.ch.Net.connect0(Native Method)",
+		"\tat java.base/sun.nio.ch.Net.connect(Net.java:579)",
+		"\tat com.example.Client.open(Client.java:42)",
+		"\tat com.example.ClientTest.testOpen(ClientTest.java:17)",
+	})
+	assert.True(t, ok)
+	assert.Equal(t, "java.net.ConnectException: Connection refused (port <n>)",
+		sig.Message)
+	assert.Equal(t, []string{
+		"at <path>/sun.nio.ch.Net.connect0(Native Method)",
+		"at <path>/sun.nio.ch.Net.connect(Net.java:<n>)",
+		"at com.example.Client.open(Client.java:<n>)",
+	}, sig.Frames)
+}
+
+func TestExtract_GoPanic(t *testing.T) {
+	sig, ok := signature.Extract([]string{
+		"panic: runtime error: index out of range [5] with length 3\n\n" +
+			"goroutine 7 [running]:\n" +
+			"example.com/app.parse(...)\n" +
+			"\t/home/ci/src/app/parse.go:17 +0x1d\n" +
+			"example.com/app.TestParse(0xc000001380)\n" +
+			"\t/home/ci/src/app/parse_test.go:9 +0x45",
+	})
+	assert.True(t, ok)
+	assert.Equal(t,
+		"panic: runtime error: index out of range [<n>] with length <n>",
+		sig.Message)
+	assert.Equal(t, []string{
+		"<path>/parse.go:<n> +<n>",
+		"<path>/parse_test.go:<n> +<n>",
+	}, sig.Frames)
+}
+
+func TestExtract_Python(t *testing.T) {
+	sig, ok := signature.Extract([]string{
+		"Traceback (most recent call last):",
+		`  File "/srv/app/test_db.py", line 12, in test_query`,
+		"    db.query()",
+		"ConnectionRefusedError: [Errno 111] Connection refused",
+	})
+	assert.True(t, ok)
+	assert.Equal(t,
+		"ConnectionRefusedError: [Errno <n>] Connection refused", sig.Message)
+	assert.Equal(t, []string{
+		`File "<path>/test_db.py", line <n>, in test_query`,
+	}, sig.Frames)
+}
+
+func TestExtract_Empty(t *testing.T) {
+	_, ok := signature.Extract([]string{"", " \n\t"})
+	assert.False(t, ok)
+}
+
+func TestHash(t *testing.T) {
+	a, _ := signature.Extract([]string{
+		"dial tcp 10.0.0.1:5432: connection refused",
+		"\tat db.open(/home/a/db.js:10:5)",
+	})
+	b, _ := signature.Extract([]string{
+		"dial tcp 10.0.3.7:5433: connection refused",
+		"\tat db.open(/home/b/db.js:12:7)",
+	})
+	c, _ := signature.Extract([]string{
+		"dial tcp 10.0.3.7:5433: connection reset",
+	})
+	assert.Equal(t, a.Hash(), b.Hash())
+	assert.NotEqual(t, a.Hash(), c.Hash())
+	assert.Len(t, a.Hash(), 32)
+}
@@ -0,0 +1,178 @@
+// Package testfmt parses the output of test runners into events for the cases
+// they run. It understands the output of go test -json and the Test Anything
+// Protocol (TAP).
+package testfmt
+
+import (
+	"encoding/json"
+	"github.com/suiteserve/suiteserve/internal/repo"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Kind int
+
+const (
+	// Start is sent when a test starts.
+	Start Kind = iota
+	// Output is sent for a line of output. It belongs to the suite if Test is
+	// empty.
+	Output
+	// End is sent when a test finishes with Result.
+	End
+)
+
+type Event struct {
+	Kind    Kind
+	Package string
+	Test    string
+	Result  repo.CaseResult
+	Line    string
+	Time    time.Time
+}
+
+type Parser interface {
+	// Parse returns the events for a line of output, without its line ending.
+	Parse(line string) []Event
+	// Flush returns the events held back for lines that may follow, once
+	// there is no more output.
+	Flush() []Event
+}
+
+// Detect returns a Parser for the format of the first line of output, or nil
+// if the format is unknown.
+func Detect(line string) Parser {
+	if _, ok := parseGoTestJson(line); ok {
+		return &GoTestJson{}
+	}
+	if strings.HasPrefix(line, "TAP version ") || tapPlan.MatchString(line) ||
+		tapTest.MatchString(line) {
+		return &Tap{}
+	}
+	return nil
+}
+
+// GoTestJson parses the output of go test -json.
+type GoTestJson struct{}
+
+type goTestEvent struct {
+	Time    time.Time
+	Action  string
+	Package string
+	Test    string
+	Output  string
+}
+
+func parseGoTestJson(line string) (goTestEvent, bool) {
+	var e goTestEvent
+	if !strings.HasPrefix(line, "{") {
+		return e, false
+	}
+	if err := json.Unmarshal([]byte(line), &e); err != nil || e.Action == "" {
+		return e, false
+	}
+	return e, true
+}
+
+func (p *GoTestJson) Parse(line string) []Event {
+	e, ok := parseGoTestJson(line)
+	if !ok {
+		return []Event{{Kind: Output, Line: line, Time: time.Now()}}
+	}
+	evt := Event{
+		Package: e.Package,
+		Test:    e.Test,
+		Time:    e.Time,
+	}
+	switch e.Action {
+	case "run":
+		evt.Kind = Start
+	case "output":
+		evt.Kind = Output
+		evt.Line = strings.TrimSuffix(e.Output, "\n")
+	case "pass", "fail", "skip":
+		if e.Test == "" {
+			// the end of a package, which is reported as output
+			return nil
+		}
+		evt.Kind = End
+		evt.Result = map[string]repo.CaseResult{
+			"pass": repo.CaseResultPassed,
+			"fail": repo.CaseResultFailed,
+			"skip": repo.CaseResultSkipped,
+		}[e.Action]
+	default:
+		return nil
+	}
+	return []Event{evt}
+}
+
+func (p *GoTestJson) Flush() []Event {
+	return nil
+}
+
+var (
+	tapPlan      = regexp.MustCompile(`^1\.\.\d+`)
+	tapTest      = regexp.MustCompile(`^(not )?ok\b\s*(\d+)?\s*(?:- )?([^#]*?)\s*(?:#\s*(\w+).*)?$`)
+	tapBailOut   = regexp.MustCompile(`^Bail out!`)
+	tapDirective = map[string]bool{
+		"skip": true,
+		"todo": true,
+	}
+)
+
+// Tap parses the Test Anything Protocol, version 12 or 13. As TAP reports a
+// test only once it has finished, the Start event is sent for its test line.
+// Diagnostics that follow a test line are output of that test, so its End
+// event is held back until the next test line, plan or bail out.
+type Tap struct {
+	end *Event
+	n   int
+}
+
+func (p *Tap) Parse(line string) []Event {
+	now := time.Now()
+	m := tapTest.FindStringSubmatch(line)
+	if m == nil {
+		if tapPlan.MatchString(line) || tapBailOut.MatchString(line) {
+			return append(p.Flush(), Event{Kind: Output, Line: line, Time: now})
+		}
+		e := Event{Kind: Output, Line: line, Time: now}
+		if p.end != nil {
+			e.Test = p.end.Test
+		}
+		return []Event{e}
+	}
+	p.n++
+	name := m[3]
+	if name == "" {
+		name = "test " + m[2]
+		if m[2] == "" {
+			name = "test " + strconv.Itoa(p.n)
+		}
+	}
+	res := repo.CaseResultPassed
+	if m[1] != "" {
+		res = repo.CaseResultFailed
+	}
+	if tapDirective[strings.ToLower(m[4])] {
+		res = repo.CaseResultSkipped
+	}
+	evts := append(p.Flush(),
+		Event{Kind: Start, Test: name, Time: now},
+		Event{Kind: Output, Test: name, Line: line, Time: now},
+	)
+	p.end = &Event{Kind: End, Test: name, Result: res, Time: now}
+	return evts
+}
+
+func (p *Tap) Flush() []Event {
+	if p.end == nil {
+		return nil
+	}
+	e := *p.end
+	p.end = nil
+	return []Event{e}
+}
@@ -0,0 +1,90 @@
+package testfmt_test
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/suiteserve/suiteserve/internal/repo"
+	"github.com/suiteserve/suiteserve/internal/testfmt"
+	"testing"
+)
+
+type event struct {
+	kind   testfmt.Kind
+	test   string
+	result repo.CaseResult
+	line   string
+}
+
+func parseAll(t *testing.T, lines []string) []event {
+	p := testfmt.Detect(lines[0])
+	require.NotNil(t, p)
+	var evts []event
+	for _, line := range lines {
+		for _, e := range p.Parse(line) {
+			evts = append(evts, event{e.Kind, e.Test, e.Result, e.Line})
+		}
+	}
+	for _, e := range p.Flush() {
+		evts = append(evts, event{e.Kind, e.Test, e.Result, e.Line})
+	}
+	return evts
+}
+
+func TestDetect_Unknown(t *testing.T) {
+	assert.Nil(t, testfmt.Detect("hello, world"))
+	assert.Nil(t, testfmt.Detect(`{"not": "go test"}`))
+}
+
+func TestGoTestJson(t *testing.T) {
+	got := parseAll(t, []string{
+		`{"Action":"run","Package":"p","Test":"TestA"}`,
+		`{"Action":"output","Package":"p","Test":"TestA","Output":"=== RUN   TestA\n"}`,
+		`{"Action":"fail","Package":"p","Test":"TestA","Elapsed":0.1}`,
+		`{"Action":"output","Package":"p","Output":"FAIL\n"}`,
+		`{"Action":"fail","Package":"p","Elapsed":0.2}`,
+	})
+	assert.Equal(t, []event{
+		{testfmt.Start, "TestA", "", ""},
+		{testfmt.Output, "TestA", "", "=== RUN   TestA"},
+		{testfmt.End, "TestA", repo.CaseResultFailed, ""},
+		{testfmt.Output, "", "", "FAIL"},
+	}, got)
+}
+
+func TestTap(t *testing.T) {
+	got := parseAll(t, []string{
+		"TAP version 13",
+		"ok 1 - first",
+		"not ok 2 second # TODO later",
+		"not ok 3",
+		"# diagnostic",
+		"1..3",
+	})
+	assert.Equal(t, []event{
+		{testfmt.Output, "", "", "TAP version 13"},
+		{testfmt.Start, "first", "", ""},
+		{testfmt.Output, "first", "", "ok 1 - first"},
+		{testfmt.End, "first", repo.CaseResultPassed, ""},
+		{testfmt.Start, "second", "", ""},
+		{testfmt.Output, "second", "", "not ok 2 second # TODO later"},
+		{testfmt.End, "second", repo.CaseResultSkipped, ""},
+		{testfmt.Start, "test 3", "", ""},
+		{testfmt.Output, "test 3", "", "not ok 3"},
+		{testfmt.Output, "test 3", "", "# diagnostic"},
+		{testfmt.End, "test 3", repo.CaseResultFailed, ""},
+		{testfmt.Output, "", "", "1..3"},
+	}, got)
+}
+
+func TestTap_Flush(t *testing.T) {
+	got := parseAll(t, []string{
+		"ok 1 - only",
+		"# diagnostic",
+	})
+	assert.Equal(t, []event{
+		{testfmt.Start, "only", "", ""},
+		{testfmt.Output, "only", "", "ok 1 - only"},
+		{testfmt.Output, "only", "", "# diagnostic"},
+		{testfmt.End, "only", repo.CaseResultPassed, ""},
+	}, got)
+}
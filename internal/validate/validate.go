@@ -0,0 +1,242 @@
+// Package validate checks entities sent by clients against the rules in their
+// validate struct tags, collecting every failure by JSON field name. The rules
+// of a tag are separated by commas:
+//
+//	readonly        the field is owned by the server and must not be set
+//	required        the field must be set
+//	max=N           a string, or each string of a slice or map, has at most N
+//	                bytes
+//	maxItems=N      a slice or map has at most N elements
+//	keys=N          each key of a map has 1 to N letters, digits, '_', '-' or
+//	                '/'
+//	oneof=A|B       a string field is one of the given values
+//	not=A|B         a string field is none of the given values
+//	dive            the fields of a struct are checked by their own tags,
+//	                named after the field and a dot
+package validate
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Errors maps JSON field names to what is wrong with them. It implements the
+// BadInput marker.
+type Errors map[string][]string
+
+func (e Errors) Add(field, format string, args ...interface{}) {
+	e[field] = append(e[field], fmt.Sprintf(format, args...))
+}
+
+// Err returns e if it has any errors, or else nil.
+func (e Errors) Err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+func (e Errors) Error() string {
+	fields := make([]string, 0, len(e))
+	for f := range e {
+		fields = append(fields, f)
+	}
+	sort.Strings(fields)
+	var b strings.Builder
+	for i, f := range fields {
+		if i > 0 {
+			b.WriteString("; ")
+		}
+		b.WriteString(f + ": " + strings.Join(e[f], ", "))
+	}
+	return b.String()
+}
+
+func (Errors) BadInput() {}
+
+func (e Errors) FieldErrors() map[string][]string {
+	return e
+}
+
+// Insert checks v, a struct or a pointer to one, as an entity to insert. The
+// names of its fields are prefixed with prefix.
+func Insert(errs Errors, prefix string, v interface{}) {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	checkStruct(errs, prefix, rv, nil)
+}
+
+// Fields checks only the fields of v with the given JSON names, such as those
+// in a patch, which may set neither readonly fields nor required ones to null.
+func Fields(errs Errors, prefix string, v interface{}, names []string) {
+	only := map[string]bool{}
+	for _, n := range names {
+		only[n] = true
+	}
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	checkStruct(errs, prefix, rv, only)
+}
+
+func checkStruct(errs Errors, prefix string, rv reflect.Value,
+	only map[string]bool) {
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		sf := rt.Field(i)
+		if sf.Anonymous {
+			checkStruct(errs, prefix, rv.Field(i), only)
+			continue
+		}
+		name := jsonName(sf)
+		if name == "" || (only != nil && !only[name]) {
+			continue
+		}
+		tag := sf.Tag.Get("validate")
+		if tag == "" {
+			continue
+		}
+		checkField(errs, prefix+name, rv.Field(i), tag, only != nil)
+	}
+}
+
+func checkField(errs Errors, field string, fv reflect.Value, tag string,
+	patch bool) {
+	set := !isEmpty(fv)
+	elem := reflect.Indirect(fv)
+	for _, rule := range strings.Split(tag, ",") {
+		arg := ""
+		if i := strings.IndexByte(rule, '='); i >= 0 {
+			rule, arg = rule[:i], rule[i+1:]
+		}
+		switch rule {
+		case "readonly":
+			if set || patch {
+				errs.Add(field, "is set by the server")
+			}
+		case "required":
+			if !set {
+				errs.Add(field, "is required")
+			}
+		case "max":
+			n := mustAtoi(arg)
+			if !set {
+				break
+			}
+			if elem.Kind() == reflect.String && elem.Len() > n {
+				errs.Add(field, "is longer than %d bytes", n)
+			} else if elem.Kind() == reflect.Slice ||
+				elem.Kind() == reflect.Map {
+				for _, s := range elems(elem) {
+					if s.Kind() == reflect.String && s.Len() > n {
+						errs.Add(field, "has an element longer than %d bytes",
+							n)
+						break
+					}
+				}
+			}
+		case "maxItems":
+			if n := mustAtoi(arg); set && (elem.Kind() == reflect.Slice ||
+				elem.Kind() == reflect.Map) && elem.Len() > n {
+				errs.Add(field, "has more than %d elements", n)
+			}
+		case "keys":
+			n := mustAtoi(arg)
+			if !set || elem.Kind() != reflect.Map {
+				break
+			}
+			for _, k := range elem.MapKeys() {
+				if k.Kind() == reflect.String && !isKey(k.String(), n) {
+					errs.Add(field, "has a key that is not 1 to %d letters, "+
+						"digits, '_', '-' or '/'", n)
+					break
+				}
+			}
+		case "oneof":
+			if !set || elem.Kind() != reflect.String {
+				break
+			}
+			ok := false
+			for _, o := range strings.Split(arg, "|") {
+				ok = ok || elem.String() == o
+			}
+			if !ok {
+				errs.Add(field, "must be one of %s",
+					strings.ReplaceAll(arg, "|", ", "))
+			}
+		case "not":
+			if !set || elem.Kind() != reflect.String {
+				break
+			}
+			for _, o := range strings.Split(arg, "|") {
+				if elem.String() == o {
+					errs.Add(field, "cannot be %s", o)
+				}
+			}
+		case "dive":
+			if set && elem.Kind() == reflect.Struct {
+				checkStruct(errs, field+".", elem, nil)
+			}
+		default:
+			panic(fmt.Sprintf("bad validate rule %q", rule))
+		}
+	}
+}
+
+// elems returns the elements of a slice or the values of a map.
+func elems(v reflect.Value) []reflect.Value {
+	if v.Kind() == reflect.Map {
+		var vs []reflect.Value
+		for _, k := range v.MapKeys() {
+			vs = append(vs, v.MapIndex(k))
+		}
+		return vs
+	}
+	vs := make([]reflect.Value, v.Len())
+	for i := range vs {
+		vs[i] = v.Index(i)
+	}
+	return vs
+}
+
+func isKey(s string, max int) bool {
+	if s == "" || len(s) > max {
+		return false
+	}
+	for _, c := range s {
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' ||
+			'0' <= c && c <= '9' || c == '_' || c == '-' || c == '/') {
+			return false
+		}
+	}
+	return true
+}
+
+func isEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+	case reflect.Slice, reflect.Map:
+		return v.IsNil()
+	}
+	return v.IsZero()
+}
+
+func jsonName(sf reflect.StructField) string {
+	tag := sf.Tag.Get("json")
+	if tag == "-" {
+		return ""
+	}
+	if name := strings.Split(tag, ",")[0]; name != "" {
+		return name
+	}
+	return sf.Name
+}
+
+func mustAtoi(s string) int {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		panic(fmt.Sprintf("bad validate argument %q", s))
+	}
+	return n
+}
@@ -0,0 +1,108 @@
+package validate_test
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/suiteserve/suiteserve/internal/validate"
+	"strings"
+	"testing"
+)
+
+type entity struct {
+	Id *string `json:"id,omitempty" validate:"readonly"`
+}
+
+type thing struct {
+	entity `json:",inline"`
+	Name   *string           `json:"name,omitempty" validate:"required,max=4"`
+	Tags   []string          `json:"tags,omitempty" validate:"max=2,maxItems=2"`
+	Status *string           `json:"status,omitempty" validate:"oneof=a|b"`
+	Scope  *string           `json:"scope,omitempty" validate:"not=*|-"`
+	Labels map[string]string `json:"labels,omitempty" validate:"keys=3,max=2,maxItems=2"`
+	Other  *string           `json:"other,omitempty"`
+}
+
+func str(s string) *string {
+	return &s
+}
+
+func TestInsert(t *testing.T) {
+	errs := validate.Errors{}
+	validate.Insert(errs, "", thing{
+		Name:   str("ok"),
+		Tags:   []string{"ab"},
+		Status: str("a"),
+		Labels: map[string]string{"a/b": "ok"},
+	})
+	assert.Nil(t, errs.Err())
+
+	errs = validate.Errors{}
+	validate.Insert(errs, "[1].", &thing{
+		entity: entity{Id: str("x")},
+		Tags:   []string{"a", "abc", "b"},
+		Status: str("c"),
+		Labels: map[string]string{"a.b": "abc", "": "", "c": ""},
+	})
+	assert.Equal(t, validate.Errors{
+		"[1].id":     {"is set by the server"},
+		"[1].name":   {"is required"},
+		"[1].tags":   {"has an element longer than 2 bytes", "has more than 2 elements"},
+		"[1].status": {"must be one of a, b"},
+		"[1].labels": {
+			"has a key that is not 1 to 3 letters, digits, '_', '-' or '/'",
+			"has an element longer than 2 bytes",
+			"has more than 2 elements",
+		},
+	}, errs)
+	assert.True(t, strings.HasPrefix(errs.Error(), "[1].id: is set by the server; "))
+}
+
+func TestFields(t *testing.T) {
+	errs := validate.Errors{}
+	validate.Fields(errs, "", thing{Name: str("toolong")},
+		[]string{"id", "name", "other"})
+	assert.Equal(t, validate.Errors{
+		"id":   {"is set by the server"},
+		"name": {"is longer than 4 bytes"},
+	}, errs)
+
+	errs = validate.Errors{}
+	validate.Fields(errs, "", thing{}, []string{"name"})
+	assert.Equal(t, validate.Errors{
+		"name": {"is required"},
+	}, errs)
+}
+
+func TestInsert_Not(t *testing.T) {
+	errs := validate.Errors{}
+	validate.Insert(errs, "", thing{Name: str("ok"), Scope: str("a*")})
+	assert.Nil(t, errs.Err())
+
+	errs = validate.Errors{}
+	validate.Insert(errs, "", thing{Name: str("ok"), Scope: str("*")})
+	assert.Equal(t, validate.Errors{
+		"scope": {"cannot be *"},
+	}, errs)
+}
+
+func TestInsert_Dive(t *testing.T) {
+	type inner struct {
+		Name *string `json:"name,omitempty" validate:"max=2"`
+		Id   *string `json:"id,omitempty" validate:"readonly"`
+	}
+	type outer struct {
+		Inner *inner `json:"inner,omitempty" validate:"dive"`
+	}
+	errs := validate.Errors{}
+	validate.Insert(errs, "", outer{})
+	assert.Nil(t, errs.Err())
+
+	errs = validate.Errors{}
+	validate.Insert(errs, "[0].", outer{Inner: &inner{
+		Name: str("abc"),
+		Id:   str("x"),
+	}})
+	assert.Equal(t, validate.Errors{
+		"[0].inner.name": {"is longer than 2 bytes"},
+		"[0].inner.id":   {"is set by the server"},
+	}, errs)
+}
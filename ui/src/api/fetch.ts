@@ -3,6 +3,10 @@ import * as api from './types';
 async function fetchJSON<T>(url: string): Promise<T> {
   const resp = await fetch(url);
   if (!resp.ok) {
+    if (resp.headers.get('content-type') === 'application/problem+json') {
+      const problem: api.Problem = await resp.json();
+      throw new Error(problem.detail || problem.title);
+    }
     throw new Error(resp.statusText);
   }
   return resp.json();
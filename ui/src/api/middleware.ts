@@ -35,7 +35,9 @@ export const newWatchEventsMiddleware = (): Middleware => (
     }
   });
   on('suites', (evt: t.WatchEvent<t.Suite>) => {
-    if (t.isInsertWatchEvent(evt)) {
+    if (evt.delete || evt.update?.deletedAt !== undefined) {
+      store.dispatch(suites.removed(evt.id));
+    } else if (t.isInsertWatchEvent(evt)) {
       store.dispatch(suites.inserted(evt.insert));
     } else if (
       t.isUpdateWatchEvent(evt) &&
@@ -14,9 +14,20 @@ function isVersionedEntity(e: any): e is VersionedEntity {
   return typeof e.version === 'number';
 }
 
+export interface Problem {
+  readonly type: string;
+  readonly title: string;
+  readonly status: number;
+  readonly code: string;
+  readonly detail?: string;
+  readonly field?: string;
+  readonly requestId?: string;
+}
+
 export interface Attachment extends Entity, VersionedEntity {
   readonly suiteId?: Id;
   readonly caseId?: Id;
+  readonly attempt?: number;
   readonly filename: string;
   readonly contentType: string;
   readonly size: number;
@@ -34,15 +45,35 @@ export enum SuiteResult {
   FAILED = 'failed',
 }
 
+export type Labels = { readonly [key: string]: string };
+
+export interface SuiteEnv {
+  readonly commit?: string;
+  readonly branch?: string;
+  readonly pr?: number;
+  readonly ci?: string;
+  readonly buildUrl?: string;
+  readonly host?: string;
+  readonly os?: string;
+  readonly arch?: string;
+  readonly goVersion?: string;
+}
+
 export interface Suite extends Entity, VersionedEntity {
   readonly project?: string;
+  readonly description?: string;
   readonly tags?: string[];
+  readonly labels?: Labels;
+  readonly env?: SuiteEnv;
   readonly plannedCases?: number;
   readonly status: SuiteStatus;
   readonly result?: SuiteResult;
+  readonly quarantined?: number;
   readonly disconnectedAt?: number;
   readonly startedAt: number;
   readonly finishedAt?: number;
+  readonly deletedAt?: number;
+  readonly baselineId?: Id;
 }
 
 export type SuitePageCursor = string;
@@ -64,33 +95,91 @@ export enum CaseResult {
   SKIPPED = 'skipped',
   ABORTED = 'aborted',
   ERRORED = 'errored',
+  PASSED_ON_RETRY = 'passed_on_retry',
+}
+
+export interface Attempt {
+  readonly status: CaseStatus;
+  readonly result?: CaseResult;
+  readonly startedAt?: number;
+  readonly finishedAt?: number;
 }
 
 export interface Case extends Entity, VersionedEntity {
   readonly suiteId: Id;
+  readonly parentId?: Id;
   readonly name?: string;
   readonly description?: string;
   readonly tags?: string[];
+  readonly labels?: Labels;
+  readonly params?: Labels;
+  readonly fingerprint?: string;
   readonly idx: number;
   readonly status: CaseStatus;
   readonly result?: CaseResult;
   readonly createdAt: number;
   readonly startedAt?: number;
   readonly finishedAt?: number;
+  readonly flaky?: boolean;
+  readonly quarantined?: boolean;
+  readonly attempts?: Attempt[];
+  readonly signature?: string;
 }
 
 export interface LogLine extends Entity {
-  readonly caseId: Id;
+  readonly suiteId?: Id;
+  readonly caseId?: Id;
+  readonly attempt?: number;
   readonly idx: number;
   readonly error?: boolean;
   readonly line?: string;
 }
 
-export type Watchable = Suite | Case | LogLine;
+export interface ProjectRetention {
+  readonly maxAgeDays?: number;
+  readonly keepLast?: number;
+}
+
+export interface Project extends Entity, VersionedEntity {
+  readonly name: string;
+  readonly displayName?: string;
+  readonly defaultBranch?: string;
+  readonly retention?: ProjectRetention;
+  readonly owners?: string[];
+  readonly createdAt: number;
+}
+
+export interface ProjectSummary {
+  readonly project: Project;
+  readonly latest?: Suite;
+  readonly finished: number;
+  readonly passed: number;
+  readonly passRate?: number;
+  readonly running: Suite[];
+}
+
+export interface RegressionSummary extends Entity {
+  readonly suiteId: Id;
+  readonly baselineId: Id;
+  readonly project?: string;
+  readonly newlyFailing: number;
+  readonly newlyPassing: number;
+  readonly stillFailing: number;
+  readonly added: number;
+  readonly removed: number;
+  readonly slower: number;
+  readonly regressions?: string[];
+  readonly at: number;
+}
+
+export type Watchable = Suite | Case | LogLine | RegressionSummary;
 
 export interface WatchEvent<E extends Watchable> extends Entity {
   readonly insert?: E;
   readonly update?: Partial<E>;
+  readonly remove?: (keyof E)[];
+  readonly delete?: boolean;
+  readonly parentId?: Id;
 }
 
 export interface InsertWatchEvent<E extends Watchable> extends Entity {
@@ -105,6 +194,7 @@ export function isInsertWatchEvent<E extends Watchable>(
 
 export interface UpdateWatchEvent<E extends Watchable> extends Entity {
   readonly update: Partial<E>;
+  readonly remove?: (keyof E)[];
 }
 
 export function isUpdateWatchEvent<E extends Watchable & VersionedEntity>(
@@ -147,8 +237,12 @@ export function onEntityUpdated<E extends Watchable & VersionedEntity>(
   ) {
     return state;
   }
+  const changes: Partial<E> = { ...evt.update };
+  for (const key of evt.remove ?? []) {
+    changes[key] = undefined;
+  }
   return adapter.updateOne(state, {
     id: evt.id,
-    changes: evt.update,
+    changes,
   });
 }
@@ -54,12 +54,14 @@ export const Cases: React.FC = () => {
                 className={
                   c.result === api.CaseResult.PASSED
                     ? styles.Good
-                    : c.result === api.CaseResult.SKIPPED
+                    : c.result === api.CaseResult.SKIPPED ||
+                      c.result === api.CaseResult.PASSED_ON_RETRY
                     ? styles.Warn
                     : styles.Bad
                 }
               >
                 {c.result}
+                {c.attempts && ` (${c.attempts.length} attempts)`}
               </td>
               <td>{new Date(c.createdAt).toISOString()}</td>
               <td>{!c.startedAt ? '' : new Date(c.startedAt).toISOString()}</td>
@@ -21,6 +21,7 @@ export const Suites: React.FC = () => {
           <tr>
             <td>Name</td>
             <td>Tags</td>
+            <td>Branch</td>
             <td>Planned Cases</td>
             <td>Status</td>
             <td>Result</td>
@@ -36,6 +37,13 @@ export const Suites: React.FC = () => {
                 <Link to={`/suites/${suite.id}`}>{suite.project || suite.id}</Link>
               </td>
               <td>{suite.tags?.join(', ')}</td>
+              <td>
+                {suite.env?.buildUrl ? (
+                  <a href={suite.env.buildUrl}>{suite.env.branch || 'build'}</a>
+                ) : (
+                  suite.env?.branch || ''
+                )}
+              </td>
               <td>{suite.plannedCases || ''}</td>
               <td
                 className={
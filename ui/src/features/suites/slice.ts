@@ -48,11 +48,15 @@ const slice = createSlice({
       state,
       { payload }: PayloadAction<api.UpdateWatchEvent<api.Suite>>
     ) => api.onEntityUpdated(adapter, state, payload),
+    removed: (state, { payload }: PayloadAction<api.Id>) =>
+      adapter.removeOne(state, payload),
   },
   extraReducers: (builder) => {
     builder
       .addCase(fetchOne.fulfilled, (state, { payload }) =>
-        api.onEntityInserted(adapter, state, payload)
+        payload.deletedAt === undefined
+          ? api.onEntityInserted(adapter, state, payload)
+          : adapter.removeOne(state, payload.id)
       )
       .addCase(fetchPage.fulfilled, (state, { payload }) =>
         payload.reduce(
@@ -63,6 +67,6 @@ const slice = createSlice({
   },
 });
 
-export const { inserted, updated } = slice.actions;
+export const { inserted, updated, removed } = slice.actions;
 
 export default slice.reducer;